@@ -0,0 +1,329 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/drillbits/go-ts/ts"
+)
+
+// config is the options of the dump.
+type config struct {
+	pids     map[ts.PID]bool     // nil for all
+	tables   map[ts.TableID]bool // nil for all
+	packets  bool
+	sections bool
+	count    int64
+}
+
+// dumper prints the packets, and the sections completed by them.
+type dumper struct {
+	w   *bufio.Writer
+	c   config
+	d   *ts.Demuxer
+	psi map[ts.PID]bool // PIDs of the sections subscribed
+	n   int64
+}
+
+// dump prints the stream from r to w.
+func dump(w io.Writer, r io.Reader, c config) error {
+	dp := &dumper{
+		w:   bufio.NewWriter(w),
+		c:   c,
+		d:   ts.NewDemuxer(),
+		psi: make(map[ts.PID]bool),
+	}
+	if c.sections {
+		for _, pid := range []ts.PID{ts.PidPAT, ts.PidCAT, ts.PidNIT, ts.PidSDT, ts.PidEIT, ts.PidTDT} {
+			dp.subscribe(pid)
+		}
+	}
+	pr := ts.NewPacketReader(r)
+	for c.count == 0 || dp.n < c.count {
+		p, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		dp.packet(p)
+	}
+	return dp.w.Flush()
+}
+
+func (dp *dumper) selected(pid ts.PID) bool {
+	return dp.c.pids == nil || dp.c.pids[pid]
+}
+
+func (dp *dumper) subscribe(pid ts.PID) {
+	if dp.psi[pid] {
+		return
+	}
+	dp.psi[pid] = true
+	dp.d.HandleSection(pid, dp.section)
+}
+
+func (dp *dumper) printf(indent int, format string, a ...interface{}) {
+	dp.w.WriteString(strings.Repeat("    ", indent))
+	fmt.Fprintf(dp.w, format, a...)
+	dp.w.WriteByte('\n')
+}
+
+func (dp *dumper) separator() {
+	dp.w.WriteString(strings.Repeat("-", 60) + "\n")
+}
+
+// packet prints the packet and passes it to the Demuxer. The packet rejected
+// by the Demuxer is reported, and the dump continues with the next one.
+func (dp *dumper) packet(p ts.Packet) {
+	n := dp.n
+	dp.n++
+	printed := dp.c.packets && dp.selected(p.PID())
+	if printed {
+		dp.printPacket(n, p)
+	}
+	if err := dp.d.WritePacket(p); err != nil && !printed && dp.selected(p.PID()) {
+		// the error of the packet printed is already in its adaptation field
+		dp.separator()
+		dp.printf(0, "TS-Packet: %08d   PID: %d (0x%04x), error: %s", n, p.PID(), uint16(p.PID()), err)
+	}
+}
+
+func (dp *dumper) printPacket(n int64, p ts.Packet) {
+	dp.separator()
+	dp.printf(0, "TS-Packet: %08d   PID: %d (0x%04x), Length: %d", n, p.PID(), uint16(p.PID()), len(p))
+	dp.printf(1, "sync_byte: 0x%02x", p.SyncByte())
+	dp.printf(1, "transport_error_indicator: %d", p.TransportErrorIndicator())
+	dp.printf(1, "payload_unit_start_indicator: %d", p.PayloadUnitStartIndicator())
+	dp.printf(1, "transport_priority: %d", p.TransportPriority())
+	dp.printf(1, "PID: %d (0x%04x)", p.PID(), uint16(p.PID()))
+	dp.printf(1, "transport_scrambling_control: %d [= %s]", p.TransportScramblingControl(), scramblingName(p.TransportScramblingControl()))
+	dp.printf(1, "adaptation_field_control: %d [= %s]", p.AdaptationFieldControl(), adaptationFieldControlName(p.AdaptationFieldControl()))
+	dp.printf(1, "continuity_counter: %d", p.ContinuityCounter())
+	af, err := p.AdaptationField()
+	if err != nil {
+		// the payload can not be located
+		dp.printf(1, "adaptation_field: %s", err)
+		return
+	}
+	if af != nil {
+		dp.adaptationField(af)
+	}
+	if p.IsPayloadUnitStart() && p.Payload().IsPES() {
+		if pes, err := ts.NewPES(p.Payload()); err == nil {
+			dp.pes(pes)
+		}
+	}
+}
+
+func (dp *dumper) adaptationField(af ts.AdaptationField) {
+	dp.printf(1, "adaptation_field:")
+	dp.printf(2, "adaptation_field_length: %d", af.Length())
+	if af.Length() == 0 {
+		return
+	}
+	dp.printf(2, "discontinuity_indicator: %d", af.DiscontinuityIndicator())
+	dp.printf(2, "random_access_indicator: %d", af.RandomAccessIndicator())
+	dp.printf(2, "elementary_stream_priority_indicator: %d", af.ElementaryStreamPriorityIndicator())
+	dp.printf(2, "PCR_flag: %d", af.PCRFlag())
+	dp.printf(2, "OPCR_flag: %d", af.OPCRFlag())
+	dp.printf(2, "splicing_point_flag: %d", af.SplicingPointFlag())
+	dp.printf(2, "transport_private_data_flag: %d", af.TransportPrivateDataFlag())
+	dp.printf(2, "adaptation_field_extension_flag: %d", af.AdaptationFieldExtensionFlag())
+	if af.HasPCR() {
+		pcr := af.PCR()
+		dp.printf(2, "PCR: %d (base %d, extension %d) [= %s]", pcr.Value(), pcr.Base(), pcr.Extension(), clockTime(pcr.Value(), ts.SystemClockFrequency))
+	}
+	if af.HasOPCR() {
+		opcr := af.OPCR()
+		dp.printf(2, "OPCR: %d [= %s]", opcr.Value(), clockTime(opcr.Value(), ts.SystemClockFrequency))
+	}
+	if af.HasSpliceCountdown() {
+		dp.printf(2, "splice_countdown: %d", af.SpliceCountdown())
+	}
+	if af.HasTransportPrivateData() {
+		dp.printf(2, "transport_private_data: % x", af.TransportPrivateData())
+	}
+}
+
+func (dp *dumper) pes(pes ts.PES) {
+	dp.printf(1, "PES:")
+	dp.printf(2, "stream_id: 0x%02x", pes.StreamID())
+	dp.printf(2, "PES_packet_length: %d", pes.PacketLength())
+	if !pes.HasOptionalHeader() {
+		return
+	}
+	dp.printf(2, "PES_scrambling_control: %d", pes.ScramblingControl())
+	dp.printf(2, "data_alignment_indicator: %d", pes.DataAlignmentIndicator())
+	dp.printf(2, "PTS_DTS_flags: %d", pes.PTSDTSFlags())
+	dp.printf(2, "PES_header_data_length: %d", pes.HeaderDataLength())
+	if pes.HasPTS() {
+		dp.printf(2, "PTS: %d [= %s]", pes.PTS(), clockTime(pes.PTS(), ts.TimestampFrequency))
+	}
+	if pes.HasDTS() {
+		dp.printf(2, "DTS: %d [= %s]", pes.DTS(), clockTime(pes.DTS(), ts.TimestampFrequency))
+	}
+}
+
+func (dp *dumper) section(rx *ts.SectionReceiver) error {
+	b := rx.Bytes()
+	psi := ts.PSI(b)
+	bad := sectionError(b)
+	if psi.TableID() == 0x00 && bad == "" {
+		// follow the PMTs
+		if pat, err := ts.NewPAT(b); err == nil {
+			for _, pid := range pat.ProgramPIDMap() {
+				dp.subscribe(pid)
+			}
+		}
+	}
+	if !dp.selected(rx.PID) || dp.c.tables != nil && !dp.c.tables[psi.TableID()] {
+		return nil
+	}
+
+	dp.separator()
+	dp.printf(0, "Section: PID: %d (0x%04x), table_id: 0x%02x [= %s]", rx.PID, uint16(rx.PID), byte(psi.TableID()), tableName(psi.TableID()))
+	dp.printf(1, "section_syntax_indicator: %d", psi.SectionSyntaxIndicator())
+	dp.printf(1, "section_length: %d", psi.SectionLength())
+	if psi.SectionSyntaxIndicator() == 1 && len(b) >= 12 {
+		dp.printf(1, "table_id_extension: %d (0x%04x)", binary.BigEndian.Uint16(b[3:5]), binary.BigEndian.Uint16(b[3:5]))
+		dp.printf(1, "version_number: %d", b[5]>>1&0x1F)
+		dp.printf(1, "current_next_indicator: %d", b[5]&0x01)
+		dp.printf(1, "section_number: %d", b[6])
+		dp.printf(1, "last_section_number: %d", b[7])
+	}
+	switch {
+	case bad != "":
+		dp.printf(1, "error: %s", bad)
+	case psi.TableID() == 0x00:
+		if pat, err := ts.NewPAT(b); err == nil {
+			dp.pat(pat)
+		}
+	case psi.TableID() == 0x01:
+		if cat, err := ts.NewCAT(b); err == nil {
+			dp.descriptors(1, cat.Descriptors())
+		}
+	case psi.TableID() == 0x02:
+		if pmt, err := ts.NewPMT(b); err == nil {
+			dp.pmt(pmt)
+		}
+	default:
+		dp.printf(1, "data: % x", b[3:])
+	}
+	if psi.SectionSyntaxIndicator() == 1 && len(b) >= 4 {
+		dp.printf(1, "CRC_32: 0x%08x", binary.BigEndian.Uint32(psi.CRC32()))
+	}
+	return nil
+}
+
+// sectionError returns the description of the error of the section with the
+// long header, or "" if none. The sections with the short header are not
+// checked.
+func sectionError(b []byte) string {
+	psi := ts.PSI(b)
+	if psi.SectionSyntaxIndicator() == 0 {
+		return ""
+	}
+	if len(b) < 12 {
+		return "section too short"
+	}
+	if !psi.VerifyCRC32() {
+		return "CRC_32 mismatch"
+	}
+	end := len(b) - 4 // CRC_32
+	switch psi.TableID() {
+	case 0x00:
+		if (end-8)%4 != 0 {
+			return "section_length not in units of the programs"
+		}
+	case 0x02:
+		if len(b) < 16 {
+			return "section too short"
+		}
+		pos := 12 + ts.PMT(b).ProgramInfoLength()
+		if pos > end {
+			return "program_info_length beyond the section"
+		}
+		for pos < end {
+			if pos+5 > end || pos+5+ts.ProgramElementInfo(b[pos:]).ESInfoLength() > end {
+				return "ES_info_length beyond the section"
+			}
+			pos += 5 + ts.ProgramElementInfo(b[pos:]).ESInfoLength()
+		}
+	}
+	return ""
+}
+
+func (dp *dumper) pat(pat ts.PAT) {
+	if pid, err := pat.NetworkPID(); err == nil {
+		dp.printf(1, "program_number: 0 [= network]  network_PID: %d (0x%04x)", pid, uint16(pid))
+	}
+	programs := pat.ProgramPIDMap()
+	numbers := make([]int, 0, len(programs))
+	for number := range programs {
+		numbers = append(numbers, int(number))
+	}
+	sort.Ints(numbers)
+	for _, number := range numbers {
+		pid := programs[ts.ProgramNumber(number)]
+		dp.printf(1, "program_number: %d  program_map_PID: %d (0x%04x)", number, pid, uint16(pid))
+	}
+}
+
+func (dp *dumper) pmt(pmt ts.PMT) {
+	dp.printf(1, "PCR_PID: %d (0x%04x)", pmt.PCRPID(), uint16(pmt.PCRPID()))
+	dp.printf(1, "program_info_length: %d", pmt.ProgramInfoLength())
+	dp.descriptors(1, pmt.Descriptors())
+	for _, info := range pmt.ElementInfo() {
+		dp.printf(1, "stream_type: 0x%02x [= %s]", info.StreamType(), ts.StreamTypeName(info.StreamType()))
+		dp.printf(2, "elementary_PID: %d (0x%04x)", info.ElementaryPID(), uint16(info.ElementaryPID()))
+		dp.printf(2, "ES_info_length: %d", info.ESInfoLength())
+		dp.descriptors(2, info.Descriptors())
+	}
+}
+
+func (dp *dumper) descriptors(indent int, ds []ts.Descriptor) {
+	for _, d := range ds {
+		dp.printf(indent, "descriptor_tag: 0x%02x [= %s]", byte(d.Tag()), d.Tag().Name())
+		dp.printf(indent+1, "descriptor_length: %d", d.Length())
+		data := d.Data()
+		switch {
+		case d.Tag() == ts.TagCA && len(data) >= 4:
+			dp.printf(indent+1, "CA_system_ID: 0x%04x", binary.BigEndian.Uint16(data[0:2]))
+			pid := binary.BigEndian.Uint16(data[2:4]) & 0x1FFF
+			dp.printf(indent+1, "CA_PID: %d (0x%04x)", pid, pid)
+		case d.Tag() == ts.TagISO639Language:
+			for ; len(data) >= 4; data = data[4:] {
+				dp.printf(indent+1, "ISO_639_language_code: %q  audio_type: %d", data[0:3], data[3])
+			}
+		case d.Tag() == ts.TagRegistration && len(data) >= 4:
+			dp.printf(indent+1, "format_identifier: %q", data[0:4])
+		default:
+			dp.printf(indent+1, "data: % x", data)
+		}
+	}
+}
+
+// clockTime formats the clock value in the frequency as seconds.
+func clockTime(v, frequency int64) string {
+	return fmt.Sprintf("%d.%06d s", v/frequency, v%frequency*1000000/frequency)
+}
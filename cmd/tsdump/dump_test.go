@@ -0,0 +1,185 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/drillbits/go-ts/ts"
+)
+
+func makeTestStream() []byte {
+	pat := ts.BuildPAT(0x0001, 0, map[ts.ProgramNumber]ts.PID{1: 0x1000})
+	pmt := ts.BuildPMT(1, 0, 0x0100, nil,
+		ts.BuildProgramElementInfo(ts.StreamTypeH264, 0x0100),
+		ts.BuildProgramElementInfo(ts.StreamTypeAAC, 0x0101, ts.BuildDescriptor(ts.TagISO639Language, []byte("jpn\x00"))),
+	)
+	var b []byte
+	for _, p := range ts.NewPacketizer(ts.PidPAT).Section(pat) {
+		b = append(b, p...)
+	}
+	for _, p := range ts.NewPacketizer(0x1000).Section(pmt) {
+		b = append(b, p...)
+	}
+	pes := ts.BuildPES(0xE0, 90000, 87000, make([]byte, 100))
+	for _, p := range ts.NewPacketizer(0x0100).PES(pes, 27000000, true) {
+		b = append(b, p...)
+	}
+	return b
+}
+
+func TestDump(t *testing.T) {
+	for i, tc := range []struct {
+		c       config
+		want    []string
+		notWant []string
+	}{
+		{
+			config{packets: true, sections: true},
+			[]string{
+				"TS-Packet: 00000000   PID: 0 (0x0000), Length: 188",
+				"payload_unit_start_indicator: 1",
+				"Section: PID: 0 (0x0000), table_id: 0x00 [= program_association_section]",
+				"program_number: 1  program_map_PID: 4096 (0x1000)",
+				"Section: PID: 4096 (0x1000), table_id: 0x02 [= TS_program_map_section]",
+				"stream_type: 0x1b [= H.264 video]",
+				"ISO_639_language_code: \"jpn\"  audio_type: 0",
+				"random_access_indicator: 1",
+				"PCR: 27000000 (base 90000, extension 0) [= 1.000000 s]",
+				"PTS: 90000 [= 1.000000 s]",
+				"DTS: 87000 [= 0.966666 s]",
+			},
+			nil,
+		},
+		{
+			config{pids: map[ts.PID]bool{0x1000: true}, packets: false, sections: true},
+			[]string{"table_id: 0x02"},
+			[]string{"TS-Packet", "table_id: 0x00"},
+		},
+		{
+			config{tables: map[ts.TableID]bool{0x00: true}, packets: false, sections: true},
+			[]string{"table_id: 0x00"},
+			[]string{"TS-Packet", "table_id: 0x02"},
+		},
+		{
+			config{packets: true, sections: false, count: 1},
+			[]string{"TS-Packet: 00000000"},
+			[]string{"TS-Packet: 00000001", "Section"},
+		},
+	} {
+		var out bytes.Buffer
+		if err := dump(&out, bytes.NewReader(makeTestStream()), tc.c); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range tc.want {
+			if !strings.Contains(out.String(), s) {
+				t.Errorf("%0d: dump() does not print %q in\n%s", i, s, out.String())
+			}
+		}
+		for _, s := range tc.notWant {
+			if strings.Contains(out.String(), s) {
+				t.Errorf("%0d: dump() prints %q", i, s)
+			}
+		}
+	}
+}
+
+func TestDumpBrokenAdaptationField(t *testing.T) {
+	stream := makeTestStream()
+	// adaptation_field_length of the PES packet beyond the packet
+	p := stream[2*188:]
+	p[3] |= 0x30
+	p[4] = 200
+
+	var out bytes.Buffer
+	if err := dump(&out, bytes.NewReader(stream), config{packets: true}); err != nil {
+		t.Fatal(err)
+	}
+	if s := "adaptation_field: unexpected EOF"; !strings.Contains(out.String(), s) {
+		t.Errorf("dump() does not print %q in\n%s", s, out.String())
+	}
+	if strings.Contains(out.String(), "PES:") {
+		t.Errorf("dump() prints the PES of the broken packet")
+	}
+}
+
+func TestDumpBrokenPSIPacket(t *testing.T) {
+	// broken packet of the PAT before the stream
+	broken := append([]byte{}, makeTestStream()[:188]...)
+	broken[3] |= 0x30
+	broken[4] = 200
+	stream := append(broken, makeTestStream()...)
+
+	var out bytes.Buffer
+	if err := dump(&out, bytes.NewReader(stream), config{sections: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"TS-Packet: 00000000   PID: 0 (0x0000), error: unexpected EOF",
+		"table_id: 0x02 [= TS_program_map_section]",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("dump() does not print %q in\n%s", s, out.String())
+		}
+	}
+}
+
+func TestDumpCorruptSections(t *testing.T) {
+	pat := ts.BuildPAT(0x0001, 0, map[ts.ProgramNumber]ts.PID{0: 0x0010, 1: 0x1000, 2: 0x1001})
+	pmt := ts.BuildPMT(1, 0, 0x0100, nil, ts.BuildProgramElementInfo(ts.StreamTypeH264, 0x0100))
+	pmt[10], pmt[11] = 0xFF, 0xFF // program_info_length beyond the section
+	ts.PSI(pmt).UpdateCRC32()
+	crc := ts.BuildPMT(2, 0, 0x0200, nil, ts.BuildProgramElementInfo(ts.StreamTypeH264, 0x0200))
+	crc[len(crc)-1] ^= 0xFF
+	var b []byte
+	for _, p := range ts.NewPacketizer(ts.PidPAT).Section(pat) {
+		b = append(b, p...)
+	}
+	for _, p := range ts.NewPacketizer(0x1000).Section(pmt) {
+		b = append(b, p...)
+	}
+	for _, p := range ts.NewPacketizer(0x1001).Section(crc) {
+		b = append(b, p...)
+	}
+
+	var out bytes.Buffer
+	if err := dump(&out, bytes.NewReader(b), config{sections: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"program_number: 0 [= network]  network_PID: 16 (0x0010)",
+		"error: program_info_length beyond the section",
+		"error: CRC_32 mismatch",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("dump() does not print %q in\n%s", s, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "PCR_PID") {
+		t.Errorf("dump() prints the corrupt PMTs in\n%s", out.String())
+	}
+}
+
+func TestParseList(t *testing.T) {
+	vs, err := parseList("256, 0x1000", 13)
+	if err != nil || len(vs) != 2 || vs[0] != 256 || vs[1] != 0x1000 {
+		t.Errorf("parseList() => %v, %v, want [256 4096]", vs, err)
+	}
+	if _, err := parseList("0x2000", 13); err == nil {
+		t.Error("parseList() causes no error for 0x2000")
+	}
+}
@@ -0,0 +1,115 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Command tsdump prints the packets and the sections of an MPEG-2 transport
+// stream in a human-readable form.
+//
+// Usage:
+//
+//	tsdump [flags] [file]
+//
+// It reads the standard input if no file is given. The flags are:
+//
+//	-pid list
+//		comma separated PIDs to dump, all by default
+//	-table list
+//		comma separated table_ids of the sections to dump, all by default
+//	-packets
+//		dump the packet headers, the adaptation fields and the PES headers
+//		(default true)
+//	-sections
+//		dump PAT, CAT, PMT and the headers of DVB SI sections (default true)
+//	-n count
+//		stop after the count of packets, 0 for all
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/drillbits/go-ts/ts"
+)
+
+func main() {
+	var (
+		pids     = flag.String("pid", "", "comma separated PIDs to dump")
+		tables   = flag.String("table", "", "comma separated table_ids of the sections to dump")
+		packets  = flag.Bool("packets", true, "dump the packets")
+		sections = flag.Bool("sections", true, "dump the sections")
+		count    = flag.Int64("n", 0, "stop after the count of packets, 0 for all")
+	)
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: tsdump [flags] [file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	c := config{packets: *packets, sections: *sections, count: *count}
+	if *pids != "" {
+		c.pids = make(map[ts.PID]bool)
+		vs, err := parseList(*pids, 13)
+		if err != nil {
+			fatal(fmt.Errorf("invalid -pid: %s", err))
+		}
+		for _, v := range vs {
+			c.pids[ts.PID(v)] = true
+		}
+	}
+	if *tables != "" {
+		c.tables = make(map[ts.TableID]bool)
+		vs, err := parseList(*tables, 8)
+		if err != nil {
+			fatal(fmt.Errorf("invalid -table: %s", err))
+		}
+		for _, v := range vs {
+			c.tables[ts.TableID(v)] = true
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := dump(os.Stdout, r, c); err != nil {
+		fatal(err)
+	}
+}
+
+// parseList parses the comma separated numbers in decimal or in hexadecimal
+// with 0x.
+func parseList(s string, bits int) ([]uint64, error) {
+	var vs []uint64
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.ParseUint(strings.TrimSpace(f), 0, bits)
+		if err != nil {
+			return nil, err
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "tsdump:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,85 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import "github.com/drillbits/go-ts/ts"
+
+func scramblingName(c byte) string {
+	switch c {
+	case 0:
+		return "not scrambled"
+	case 1:
+		return "reserved"
+	case 2:
+		return "scrambled with even key"
+	}
+	return "scrambled with odd key"
+}
+
+func adaptationFieldControlName(c byte) string {
+	switch c {
+	case 1:
+		return "payload only"
+	case 2:
+		return "adaptation_field only"
+	case 3:
+		return "adaptation_field followed by payload"
+	}
+	return "reserved"
+}
+
+func tableName(id ts.TableID) string {
+	switch {
+	case id == 0x00:
+		return "program_association_section"
+	case id == 0x01:
+		return "conditional_access_section"
+	case id == 0x02:
+		return "TS_program_map_section"
+	case id == 0x03:
+		return "TS_description_section"
+	case id == ts.TableIDNITActual:
+		return "network_information_section - actual_network"
+	case id == ts.TableIDNITOther:
+		return "network_information_section - other_network"
+	case id == ts.TableIDSDTActual:
+		return "service_description_section - actual_transport_stream"
+	case id == ts.TableIDSDTOther:
+		return "service_description_section - other_transport_stream"
+	case id == ts.TableIDBAT:
+		return "bouquet_association_section"
+	case id == ts.TableIDEITActual:
+		return "event_information_section - actual_transport_stream, present/following"
+	case id == ts.TableIDEITOther:
+		return "event_information_section - other_transport_stream, present/following"
+	case id >= ts.TableIDEITScheduleActual && id < ts.TableIDEITScheduleOther:
+		return "event_information_section - actual_transport_stream, schedule"
+	case id >= ts.TableIDEITScheduleOther && id <= 0x6F:
+		return "event_information_section - other_transport_stream, schedule"
+	case id == ts.TableIDTDT:
+		return "time_date_section"
+	case id == ts.TableIDRST:
+		return "running_status_section"
+	case id == ts.TableIDST:
+		return "stuffing_section"
+	case id == ts.TableIDTOT:
+		return "time_offset_section"
+	case id == 0xFF:
+		return "forbidden"
+	case id >= 0x40:
+		return "user private"
+	}
+	return "reserved"
+}
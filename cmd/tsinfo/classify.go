@@ -0,0 +1,106 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import "github.com/drillbits/go-ts/ts"
+
+// Tags of the DVB descriptors in ETSI EN 300 468.
+const (
+	tagTeletext   ts.DescriptorTag = 0x56 // teletext_descriptor
+	tagSubtitling ts.DescriptorTag = 0x59 // subtitling_descriptor
+	tagAC3        ts.DescriptorTag = 0x6A // AC-3_descriptor
+	tagEAC3       ts.DescriptorTag = 0x7A // enhanced_AC-3_descriptor
+	tagDTS        ts.DescriptorTag = 0x7B // DTS_descriptor
+	tagAAC        ts.DescriptorTag = 0x7C // AAC_descriptor
+)
+
+// Kinds of the streams.
+const (
+	kindVideo    = "video"
+	kindAudio    = "audio"
+	kindSubtitle = "subtitle"
+	kindData     = "data"
+	kindUnknown  = "unknown"
+)
+
+type codec struct {
+	kind string
+	name string
+}
+
+var streamTypeCodecs = map[byte]codec{
+	ts.StreamTypeMPEG1Video:      {kindVideo, "mpeg1video"},
+	ts.StreamTypeMPEG2Video:      {kindVideo, "mpeg2video"},
+	ts.StreamTypeMPEG4Video:      {kindVideo, "mpeg4"},
+	ts.StreamTypeH264:            {kindVideo, "h264"},
+	ts.StreamTypeHEVC:            {kindVideo, "hevc"},
+	ts.StreamTypeMPEG1Audio:      {kindAudio, "mp2"},
+	ts.StreamTypeMPEG2Audio:      {kindAudio, "mp2"},
+	ts.StreamTypeAAC:             {kindAudio, "aac"},
+	ts.StreamTypeLATMAAC:         {kindAudio, "aac_latm"},
+	ts.StreamTypeAC3:             {kindAudio, "ac3"},
+	ts.StreamTypeEAC3:            {kindAudio, "eac3"},
+	ts.StreamTypePrivateSections: {kindData, "private_sections"},
+	ts.StreamTypeMHEG:            {kindData, "mheg"},
+	ts.StreamTypeDSMCC:           {kindData, "dsmcc"},
+	ts.StreamTypeDSMCCTypeA:      {kindData, "dsmcc"},
+	ts.StreamTypeDSMCCTypeB:      {kindData, "dsmcc"},
+	ts.StreamTypeDSMCCTypeC:      {kindData, "dsmcc"},
+	ts.StreamTypeDSMCCTypeD:      {kindData, "dsmcc"},
+	ts.StreamTypeMetadataPES:     {kindData, "metadata"},
+}
+
+var tagCodecs = map[ts.DescriptorTag]codec{
+	tagTeletext:   {kindSubtitle, "dvb_teletext"},
+	tagSubtitling: {kindSubtitle, "dvb_subtitle"},
+	tagAC3:        {kindAudio, "ac3"},
+	tagEAC3:       {kindAudio, "eac3"},
+	tagDTS:        {kindAudio, "dts"},
+	tagAAC:        {kindAudio, "aac"},
+}
+
+var registrationCodecs = map[string]codec{
+	"AC-3": {kindAudio, "ac3"},
+	"EAC3": {kindAudio, "eac3"},
+	"DTS1": {kindAudio, "dts"},
+	"DTS2": {kindAudio, "dts"},
+	"DTS3": {kindAudio, "dts"},
+	"Opus": {kindAudio, "opus"},
+	"HEVC": {kindVideo, "hevc"},
+	"KLVA": {kindData, "klv"},
+	"ID3 ": {kindData, "id3"},
+}
+
+// classify returns the kind and the codec of the stream by its stream_type,
+// or by its descriptors for PES private data and user private types.
+func classify(st *ts.Stream) (kind, name string) {
+	if c, ok := streamTypeCodecs[st.StreamType]; ok {
+		return c.kind, c.name
+	}
+	for _, d := range st.Info.Descriptors() {
+		if c, ok := tagCodecs[d.Tag()]; ok {
+			return c.kind, c.name
+		}
+		if d.Tag() == ts.TagRegistration && len(d.Data()) >= 4 {
+			if c, ok := registrationCodecs[string(d.Data()[0:4])]; ok {
+				return c.kind, c.name
+			}
+		}
+	}
+	if st.StreamType == ts.StreamTypePrivateData {
+		return kindData, "private_data"
+	}
+	return kindUnknown, "unknown"
+}
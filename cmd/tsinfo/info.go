@@ -0,0 +1,329 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/drillbits/go-ts/ts"
+)
+
+// streamInfo is the summary of the transport stream.
+type streamInfo struct {
+	TransportStreamID uint16        `json:"transport_stream_id"`
+	Packets           int64         `json:"packets"`
+	Errors            int64         `json:"errors"`   // packets skipped by the errors
+	Duration          float64       `json:"duration"` // seconds by the PCRs
+	Bitrate           int64         `json:"bitrate"`  // bits per second
+	Programs          []programInfo `json:"programs"`
+}
+
+// programInfo is the summary of a program.
+type programInfo struct {
+	Number    uint16   `json:"program_number"`
+	PMTPID    uint16   `json:"pmt_pid"`
+	PCRPID    uint16   `json:"pcr_pid"`
+	Bitrate   int64    `json:"bitrate"`
+	Scrambled bool     `json:"scrambled"` // any stream is scrambled
+	CA        bool     `json:"ca"`        // CA_descriptor in the PMT or any ES info
+	Streams   []esInfo `json:"streams"`
+}
+
+// esInfo is the summary of an elementary stream.
+type esInfo struct {
+	PID        uint16  `json:"pid"`
+	StreamType byte    `json:"stream_type"`
+	Kind       string  `json:"kind"`
+	Codec      string  `json:"codec"`
+	Language   string  `json:"language,omitempty"`
+	Packets    int64   `json:"packets"`
+	Bitrate    int64   `json:"bitrate"`
+	Duration   float64 `json:"duration"`  // seconds by the PTSs
+	Scrambled  bool    `json:"scrambled"` // by the scrambling control bits
+	CA         bool    `json:"ca"`        // CA_descriptor in the ES info
+}
+
+// pidStats is the statistics of the packets of a PID.
+type pidStats struct {
+	packets   int64
+	scrambled bool
+	pts       int64 // last PTS
+	first     int64 // first PTS, or -1
+	span      int64 // PTS from first unwrapped
+}
+
+// timestampWrap is the wrap of PTS.
+const timestampWrap = 1 << 33
+
+// pcrMaxGap is the largest gap of the PCRs taken as continuous. The PCRs
+// jumping further, like the ones at the joint of the concatenated files, are
+// discontinuous.
+const pcrMaxGap = ts.SystemClockFrequency
+
+// scanner collects the statistics of the stream.
+type scanner struct {
+	d    *ts.Demuxer
+	tsid ts.TransportStreamID
+	pids map[ts.PID]*pidStats
+	n    int64
+	errs int64 // number of the packets skipped by the errors
+
+	pcrPID         ts.PID
+	lastN, lastPCR int64 // last PCR and its packet
+	pcrSpan        int64 // PCR over the continuous parts
+	pcrPackets     int64 // packets over the continuous parts
+}
+
+// scan reads the stream from r and summarizes it.
+func scan(r io.Reader) (*streamInfo, error) {
+	s := &scanner{
+		d:      ts.NewDemuxer(),
+		pids:   make(map[ts.PID]*pidStats),
+		pcrPID: ts.PidNull,
+	}
+	s.d.HandleSection(ts.PidPAT, func(rx *ts.SectionReceiver) error {
+		if pat, err := ts.NewPAT(rx.Bytes()); err == nil {
+			s.tsid = pat.TransportStreamID()
+		}
+		return nil
+	})
+	pr := ts.NewPacketReader(r)
+	for {
+		p, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.packet(p)
+	}
+	return s.info(), nil
+}
+
+// packet collects the statistics of the packet. The packet with the broken
+// adaptation field or rejected by the Demuxer is counted and skipped.
+func (s *scanner) packet(p ts.Packet) {
+	n := s.n
+	s.n++
+	af, err := p.AdaptationField()
+	if err == nil {
+		err = s.d.WritePacket(p)
+	}
+	if err != nil {
+		s.errs++
+		return
+	}
+	st, ok := s.pids[p.PID()]
+	if !ok {
+		st = &pidStats{first: -1}
+		s.pids[p.PID()] = st
+	}
+	st.packets++
+	if p.TransportScramblingControl() != 0 {
+		st.scrambled = true
+	}
+
+	if len(af) >= 8 && af.HasPCR() {
+		pcr := af.PCR().Value()
+		switch {
+		case s.pcrPID == ts.PidNull:
+			s.pcrPID = p.PID()
+			s.lastN, s.lastPCR = n, pcr
+		case p.PID() == s.pcrPID:
+			const wrap = timestampWrap * 300
+			d := (pcr - s.lastPCR + wrap) % wrap
+			if !af.IsDiscontinuous() && d <= pcrMaxGap {
+				s.pcrSpan += d
+				s.pcrPackets += n - s.lastN
+			}
+			s.lastN, s.lastPCR = n, pcr
+		}
+	}
+
+	if p.IsPayloadUnitStart() && p.Payload().IsPES() {
+		pes, err := ts.NewPES(p.Payload())
+		if err != nil || !pes.HasPTS() {
+			return
+		}
+		if pes.ScramblingControl() != 0 {
+			st.scrambled = true
+		}
+		pts := pes.PTS()
+		if st.first < 0 {
+			st.first, st.pts = pts, pts
+			return
+		}
+		d := (pts - st.pts + timestampWrap) % timestampWrap
+		if d < timestampWrap/2 {
+			st.span += d
+		} else {
+			// reordered before the last PTS
+			st.span -= timestampWrap - d
+		}
+		st.pts = pts
+	}
+}
+
+// duration returns the duration of the stream in seconds, extrapolated from
+// the PCRs to all the packets. The packets between the discontinuous PCRs are
+// timed at the rate of the rest.
+func (s *scanner) duration() float64 {
+	if s.pcrPackets == 0 {
+		return 0
+	}
+	return float64(s.pcrSpan) / ts.SystemClockFrequency * float64(s.n) / float64(s.pcrPackets)
+}
+
+func (s *scanner) bitrate(packets int64, duration float64) int64 {
+	if duration == 0 {
+		return 0
+	}
+	return int64(float64(packets*188*8) / duration)
+}
+
+func (s *scanner) info() *streamInfo {
+	d := s.duration()
+	info := &streamInfo{
+		TransportStreamID: uint16(s.tsid),
+		Packets:           s.n,
+		Errors:            s.errs,
+		Duration:          d,
+		Bitrate:           s.bitrate(s.n, d),
+		Programs:          []programInfo{},
+	}
+	for _, pg := range s.d.Programs() {
+		pi := programInfo{
+			Number:  uint16(pg.Number),
+			PMTPID:  uint16(pg.PID),
+			PCRPID:  uint16(pg.PCRPID()),
+			Streams: []esInfo{},
+		}
+		packets := s.packets(pg.PID)
+		if pg.PMT != nil {
+			for _, d := range pg.PMT.Descriptors() {
+				if d.Tag() == ts.TagCA {
+					pi.CA = true
+				}
+			}
+		}
+		for _, st := range pg.Streams {
+			kind, codec := classify(st)
+			es := esInfo{
+				PID:        uint16(st.PID),
+				StreamType: st.StreamType,
+				Kind:       kind,
+				Codec:      codec,
+				Language:   language(st),
+				Packets:    s.packets(st.PID),
+			}
+			es.Bitrate = s.bitrate(es.Packets, d)
+			if ps, ok := s.pids[st.PID]; ok {
+				es.Duration = float64(ps.span) / ts.TimestampFrequency
+				es.Scrambled = ps.scrambled
+			}
+			for _, d := range st.Info.Descriptors() {
+				if d.Tag() == ts.TagCA {
+					es.CA = true
+				}
+			}
+			pi.Scrambled = pi.Scrambled || es.Scrambled
+			pi.CA = pi.CA || es.CA
+			packets += es.Packets
+			pi.Streams = append(pi.Streams, es)
+		}
+		pi.Bitrate = s.bitrate(packets, d)
+		info.Programs = append(info.Programs, pi)
+	}
+	return info
+}
+
+func (s *scanner) packets(pid ts.PID) int64 {
+	if st, ok := s.pids[pid]; ok {
+		return st.packets
+	}
+	return 0
+}
+
+// print writes the summary as text tables.
+func (info *streamInfo) print(w io.Writer) error {
+	fmt.Fprintf(w, "Transport stream: id %d, %d packets, %.3f s, %d bps\n",
+		info.TransportStreamID, info.Packets, info.Duration, info.Bitrate)
+	if info.Errors > 0 {
+		fmt.Fprintf(w, "Skipped %d packets by the errors\n", info.Errors)
+	}
+	for _, pg := range info.Programs {
+		flags := ""
+		if pg.Scrambled {
+			flags += ", scrambled"
+		}
+		if pg.CA {
+			flags += ", CA"
+		}
+		fmt.Fprintf(w, "\nProgram %d: PMT %s, PCR %s, %d bps%s\n",
+			pg.Number, pidString(ts.PID(pg.PMTPID)), pidString(ts.PID(pg.PCRPID)), pg.Bitrate, flags)
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "  PID\tTYPE\tKIND\tCODEC\tLANGUAGE\tBITRATE\tDURATION\tSCRAMBLED\tCA")
+		for _, es := range pg.Streams {
+			fmt.Fprintf(tw, "  %s\t0x%02X\t%s\t%s\t%s\t%d\t%.3f\t%s\t%s\n",
+				pidString(ts.PID(es.PID)), es.StreamType, es.Kind, es.Codec, orDash(es.Language),
+				es.Bitrate, es.Duration, yesNo(es.Scrambled), yesNo(es.CA))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// language returns the languages of the stream joined by commas.
+func language(st *ts.Stream) string {
+	var langs []string
+	for _, d := range st.Info.Descriptors() {
+		data := d.Data()
+		switch d.Tag() {
+		case ts.TagISO639Language:
+			for ; len(data) >= 4; data = data[4:] {
+				langs = append(langs, string(data[0:3]))
+			}
+		case tagTeletext:
+			for ; len(data) >= 5; data = data[5:] {
+				langs = append(langs, string(data[0:3]))
+			}
+		case tagSubtitling:
+			for ; len(data) >= 8; data = data[8:] {
+				langs = append(langs, string(data[0:3]))
+			}
+		}
+	}
+	return strings.Join(langs, ",")
+}
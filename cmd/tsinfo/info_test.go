@@ -0,0 +1,177 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/drillbits/go-ts/ts"
+)
+
+func makeTestStream() []byte {
+	pat := ts.BuildPAT(0x0005, 0, map[ts.ProgramNumber]ts.PID{1: 0x1000})
+	subtitling := append([]byte("eng"), 0x10, 0x00, 0x01, 0x00, 0x01)
+	pmt := ts.BuildPMT(1, 0, 0x0100, nil,
+		ts.BuildProgramElementInfo(ts.StreamTypeH264, 0x0100),
+		ts.BuildProgramElementInfo(ts.StreamTypeAAC, 0x0101, ts.BuildDescriptor(ts.TagISO639Language, []byte("jpn\x00"))),
+		ts.BuildProgramElementInfo(ts.StreamTypePrivateData, 0x0102, ts.BuildDescriptor(tagSubtitling, subtitling)),
+		ts.BuildProgramElementInfo(0x90, 0x0103, ts.BuildDescriptor(ts.TagRegistration, []byte("AC-3"))),
+	)
+	patpz := ts.NewPacketizer(ts.PidPAT)
+	pmtpz := ts.NewPacketizer(0x1000)
+	vpz := ts.NewPacketizer(0x0100)
+	apz := ts.NewPacketizer(0x0101)
+	var b []byte
+	add := func(ps []ts.Packet) {
+		for _, p := range ps {
+			b = append(b, p...)
+		}
+	}
+	for i := int64(0); i < 10; i++ {
+		add(patpz.Section(pat))
+		add(pmtpz.Section(pmt))
+		pts := 90000 + i*3600
+		add(vpz.PES(ts.BuildPES(0xE0, pts, pts, make([]byte, 1000)), i*3600*300, i == 0))
+		add(apz.PES(ts.BuildPES(0xC0, pts, pts, make([]byte, 100)), -1, false))
+	}
+	return b
+}
+
+func TestScan(t *testing.T) {
+	info, err := scan(bytes.NewReader(makeTestStream()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10 sets of PAT, PMT, 6 video and 1 audio packets
+	if info.TransportStreamID != 5 || info.Packets != 90 {
+		t.Errorf("scan() => id %d, %d packets, want 5, 90", info.TransportStreamID, info.Packets)
+	}
+	// 360 ms by the PCRs from the 3rd packet to the 84th
+	if want := 0.36 * 90 / 81; math.Abs(info.Duration-want) > 1e-9 {
+		t.Errorf("scan().Duration => %f, want %f", info.Duration, want)
+	}
+	if len(info.Programs) != 1 {
+		t.Fatalf("scan().Programs => %+v, want 1", info.Programs)
+	}
+	pg := info.Programs[0]
+	if pg.Number != 1 || pg.PMTPID != 0x1000 || pg.PCRPID != 0x0100 || pg.Scrambled {
+		t.Errorf("program => %+v", pg)
+	}
+	for i, want := range []esInfo{
+		{PID: 0x0100, StreamType: 0x1B, Kind: "video", Codec: "h264", Packets: 60, Duration: 0.36},
+		{PID: 0x0101, StreamType: 0x0F, Kind: "audio", Codec: "aac", Language: "jpn", Packets: 10, Duration: 0.36},
+		{PID: 0x0102, StreamType: 0x06, Kind: "subtitle", Codec: "dvb_subtitle", Language: "eng"},
+		{PID: 0x0103, StreamType: 0x90, Kind: "audio", Codec: "ac3"},
+	} {
+		want.Bitrate = int64(float64(want.Packets*188*8) / info.Duration)
+		if got := pg.Streams[i]; got != want {
+			t.Errorf("%0d: stream => %+v, want %+v", i, got, want)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := info.print(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"Program 1: PMT 0x1000, PCR 0x0100", "0x0101  0x0F  audio     aac           jpn"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("print() does not print %q in\n%s", s, out.String())
+		}
+	}
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded streamInfo
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Programs[0].Streams[1] != pg.Streams[1] {
+		t.Errorf("JSON => %s", b)
+	}
+}
+
+func TestScanBrokenAdaptationField(t *testing.T) {
+	stream := makeTestStream()
+	// adaptation_field_length of a video packet beyond the packet
+	p := stream[20*188:]
+	p[3] |= 0x30
+	p[4] = 200
+
+	info, err := scan(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Packets != 90 || info.Errors != 1 {
+		t.Errorf("scan() => %d packets, %d errors, want 90, 1", info.Packets, info.Errors)
+	}
+	if len(info.Programs) != 1 || len(info.Programs[0].Streams) != 4 {
+		t.Errorf("scan().Programs => %+v", info.Programs)
+	}
+}
+
+func TestScanScrambled(t *testing.T) {
+	pat := ts.BuildPAT(0x0001, 0, map[ts.ProgramNumber]ts.PID{1: 0x1000})
+	ca := ts.BuildDescriptor(ts.TagCA, []byte{0x00, 0x01, 0xE2, 0x00})
+	pmt := ts.BuildPMT(1, 0, 0x0100, nil,
+		ts.BuildProgramElementInfo(ts.StreamTypeH264, 0x0100),
+		ts.BuildProgramElementInfo(ts.StreamTypeAAC, 0x0101, ca),
+	)
+	var b []byte
+	for _, p := range ts.NewPacketizer(ts.PidPAT).Section(pat) {
+		b = append(b, p...)
+	}
+	for _, p := range ts.NewPacketizer(0x1000).Section(pmt) {
+		b = append(b, p...)
+	}
+	for _, p := range ts.NewPacketizer(0x0100).PES(ts.BuildPES(0xE0, 90000, 90000, make([]byte, 100)), 0, true) {
+		p[3] |= 0x80 // transport_scrambling_control
+		b = append(b, p...)
+	}
+	for _, p := range ts.NewPacketizer(0x0101).PES(ts.BuildPES(0xC0, 90000, 90000, make([]byte, 100)), -1, false) {
+		b = append(b, p...)
+	}
+
+	info, err := scan(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg := info.Programs[0]
+	if !pg.Scrambled || !pg.CA {
+		t.Errorf("program => scrambled %t, CA %t, want true, true", pg.Scrambled, pg.CA)
+	}
+	for i, want := range []struct{ scrambled, ca bool }{{true, false}, {false, true}} {
+		if es := pg.Streams[i]; es.Scrambled != want.scrambled || es.CA != want.ca {
+			t.Errorf("%0d: stream => scrambled %t, CA %t, want %t, %t", i, es.Scrambled, es.CA, want.scrambled, want.ca)
+		}
+	}
+}
+
+func TestScanConcatenated(t *testing.T) {
+	// PCRs restarting from 0 at the joint
+	stream := append(makeTestStream(), makeTestStream()...)
+	info, err := scan(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 0.36 * 2 * 180 / 162; math.Abs(info.Duration-want) > 1e-9 {
+		t.Errorf("scan().Duration => %f, want %f", info.Duration, want)
+	}
+}
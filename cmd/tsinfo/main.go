@@ -0,0 +1,78 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+// Command tsinfo prints the summary of the programs and the streams in an
+// MPEG-2 transport stream.
+//
+// Usage:
+//
+//	tsinfo [-json] [file]
+//
+// It reads the standard input if no file is given. The streams are
+// classified by their stream_type and descriptors, and their bitrates are
+// measured over the duration of the stream by the PCRs.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/drillbits/go-ts/ts"
+)
+
+func main() {
+	asJSON := flag.Bool("json", false, "output in JSON")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: tsinfo [-json] [file]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var r io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		r = f
+	}
+	info, err := scan(r)
+	if err != nil {
+		fatal(err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(info)
+	} else {
+		err = info.print(os.Stdout)
+	}
+	if err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "tsinfo:", err)
+	os.Exit(1)
+}
+
+// pidString formats the PID in hexadecimal.
+func pidString(pid ts.PID) string {
+	return fmt.Sprintf("0x%04X", uint16(pid))
+}
@@ -0,0 +1,249 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	bitrateSteps         = 10          // number of the steps a window slides by
+	bitrateDefaultWindow = time.Second // window used for the one too short
+)
+
+// Bitrate is a bitrate measured in bits per second.
+type Bitrate struct {
+	Current int64 // over the last window
+	Min     int64 // minimum of Current over the full windows
+	Max     int64 // maximum of Current over the full windows
+	Average int64 // since the first PCR
+}
+
+// BitrateAnalyzer measures the bitrates of the stream, of the PIDs and of the
+// programs in it over a sliding window.
+//
+// The packets are timed by the PCRs of the first PCR_PID found, that is, at
+// the transport rate derived from the PCRs, and counted from the first PCR.
+// The window slides by a tenth of its duration.
+type BitrateAnalyzer struct {
+	window time.Duration
+	step   time.Duration
+	clock  *streamClock
+	d      *Demuxer
+	framer packetFramer
+	n      int64
+
+	start   time.Duration // start of the current step
+	bucket  *bitrateBucket
+	buckets []*bitrateBucket // last steps in the window
+	total   *bitrateBucket   // since the first PCR
+
+	transport *Bitrate
+	pids      map[PID]*Bitrate
+	programs  map[ProgramNumber]*Bitrate
+}
+
+// bitrateBucket is the number of the packets in a step.
+type bitrateBucket struct {
+	packets int64
+	pids    map[PID]int64
+}
+
+func newBitrateBucket() *bitrateBucket {
+	return &bitrateBucket{pids: make(map[PID]int64)}
+}
+
+// NewBitrateAnalyzer returns a new BitrateAnalyzer over the window. The window
+// too short to slide, that is, less than 10 ns including 0, is replaced by the
+// default of 1 second.
+func NewBitrateAnalyzer(window time.Duration) *BitrateAnalyzer {
+	if window < bitrateSteps {
+		window = bitrateDefaultWindow
+	}
+	return &BitrateAnalyzer{
+		window:    window,
+		step:      window / bitrateSteps,
+		clock:     newStreamClock(0),
+		d:         NewDemuxer(),
+		bucket:    newBitrateBucket(),
+		total:     newBitrateBucket(),
+		transport: &Bitrate{},
+		pids:      make(map[PID]*Bitrate),
+		programs:  make(map[ProgramNumber]*Bitrate),
+	}
+}
+
+// Write analyzes the packets in b. The packet split between the calls is
+// buffered until the rest is written.
+func (a *BitrateAnalyzer) Write(b []byte) (int, error) {
+	return a.framer.write(b, a.WritePacket)
+}
+
+// WritePacket analyzes the packet. The packet which can not be parsed, e.g.
+// by the adaptation_field_length, is counted as well.
+func (a *BitrateAnalyzer) WritePacket(p Packet) error {
+	n := a.n
+	a.n++
+	// the programs are updated by the other packets
+	a.d.WritePacket(p)
+	a.clock.packet(p, n)
+	if a.clock.pid == PidNull {
+		return nil
+	}
+	for now := a.clock.time(n); now >= a.start+a.step; a.start += a.step {
+		a.slide()
+	}
+	pid := p.PID()
+	a.bucket.packets++
+	a.bucket.pids[pid]++
+	a.total.packets++
+	a.total.pids[pid]++
+	return nil
+}
+
+// slide closes the current step and updates the bitrates.
+func (a *BitrateAnalyzer) slide() {
+	a.buckets = append(a.buckets, a.bucket)
+	if len(a.buckets) > bitrateSteps {
+		a.buckets[0] = nil
+		a.buckets = a.buckets[1:]
+	}
+	a.bucket = newBitrateBucket()
+
+	full := len(a.buckets) == bitrateSteps
+	sum := newBitrateBucket()
+	for _, b := range a.buckets {
+		sum.packets += b.packets
+		for pid, n := range b.pids {
+			sum.pids[pid] += n
+		}
+	}
+	d := time.Duration(len(a.buckets)) * a.step
+	elapsed := a.start + a.step
+	updateBitrate(a.transport, sum.packets, a.total.packets, d, elapsed, full)
+	for pid := range a.total.pids {
+		br, ok := a.pids[pid]
+		if !ok {
+			br = &Bitrate{}
+			a.pids[pid] = br
+		}
+		updateBitrate(br, sum.pids[pid], a.total.pids[pid], d, elapsed, full)
+	}
+	for _, pg := range a.d.Programs() {
+		br, ok := a.programs[pg.Number]
+		if !ok {
+			br = &Bitrate{}
+			a.programs[pg.Number] = br
+		}
+		var cur, total int64
+		for _, pid := range programPIDs(pg) {
+			cur += sum.pids[pid]
+			total += a.total.pids[pid]
+		}
+		updateBitrate(br, cur, total, d, elapsed, full)
+	}
+}
+
+// updateBitrate updates the bitrate by the packets in the window of d and in
+// total since the start elapsed.
+func updateBitrate(br *Bitrate, packets, total int64, d, elapsed time.Duration, full bool) {
+	br.Current = packetsToBitrate(packets, d)
+	br.Average = packetsToBitrate(total, elapsed)
+	if !full {
+		return
+	}
+	if br.Min == 0 && br.Max == 0 || br.Current < br.Min {
+		br.Min = br.Current
+	}
+	if br.Current > br.Max {
+		br.Max = br.Current
+	}
+}
+
+func packetsToBitrate(packets int64, d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64(packets*packetDefaultSize*8) / d.Seconds())
+}
+
+// programPIDs returns the PIDs of the program: the PMT, the PCR, the
+// elementary streams and the ECMs.
+func programPIDs(pg *Program) []PID {
+	pids := []PID{pg.PID}
+	seen := map[PID]bool{pg.PID: true}
+	add := func(pid PID) {
+		if !seen[pid] && pid != PidNull {
+			seen[pid] = true
+			pids = append(pids, pid)
+		}
+	}
+	if pg.PMT == nil {
+		return pids
+	}
+	add(pg.PCRPID())
+	for _, pid := range caPIDs(pg.PMT.Descriptors()) {
+		add(pid)
+	}
+	for _, st := range pg.Streams {
+		add(st.PID)
+		for _, pid := range caPIDs(st.Info.Descriptors()) {
+			add(pid)
+		}
+	}
+	return pids
+}
+
+// Transport returns the transport rate of the stream.
+func (a *BitrateAnalyzer) Transport() Bitrate {
+	return *a.transport
+}
+
+// PID returns the bitrate of the PID.
+func (a *BitrateAnalyzer) PID(pid PID) Bitrate {
+	if br, ok := a.pids[pid]; ok {
+		return *br
+	}
+	return Bitrate{}
+}
+
+// PIDs returns the PIDs measured in order.
+func (a *BitrateAnalyzer) PIDs() []PID {
+	pids := make([]PID, 0, len(a.pids))
+	for pid := range a.pids {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool {
+		return pids[i] < pids[j]
+	})
+	return pids
+}
+
+// Program returns the bitrate of the program.
+func (a *BitrateAnalyzer) Program(number ProgramNumber) Bitrate {
+	if br, ok := a.programs[number]; ok {
+		return *br
+	}
+	return Bitrate{}
+}
+
+// NullShare returns the share of the null packets in the last window.
+func (a *BitrateAnalyzer) NullShare() float64 {
+	if a.transport.Current == 0 {
+		return 0
+	}
+	return float64(a.PID(PidNull).Current) / float64(a.transport.Current)
+}
@@ -0,0 +1,105 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBitrateAnalyzer(t *testing.T) {
+	stream := makeTestMonitorStream(3000, true, func(i int, p []byte) []byte {
+		if i%10 == 7 {
+			return nullPacket()
+		}
+		return p
+	})
+	a := NewBitrateAnalyzer(time.Second)
+	if _, err := a.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+
+	within := func(got, want int64) bool {
+		return got >= want-want/100 && got <= want+want/100
+	}
+	for i, tc := range []struct {
+		name string
+		br   Bitrate
+		want int64
+	}{
+		{"transport", a.Transport(), 1504000},
+		{"PAT", a.PID(PidPAT), 15040},
+		{"PMT", a.PID(0x1000), 15040},
+		{"video", a.PID(0x0100), 880 * 1504},
+		{"null", a.PID(PidNull), 150400},
+		{"program", a.Program(1), 890 * 1504},
+	} {
+		if !within(tc.br.Current, tc.want) || !within(tc.br.Min, tc.want) ||
+			!within(tc.br.Max, tc.want) || !within(tc.br.Average, tc.want) {
+			t.Errorf("%0d: %s => %+v, want %d", i, tc.name, tc.br, tc.want)
+		}
+	}
+	if s := a.NullShare(); s < 0.099 || s > 0.101 {
+		t.Errorf("NullShare() => %f, want 0.1", s)
+	}
+	if pids := a.PIDs(); len(pids) != 4 {
+		t.Errorf("PIDs() => %v, want 4 PIDs", pids)
+	}
+	if br := a.Program(2); br != (Bitrate{}) {
+		t.Errorf("Program(2) => %+v, want zero", br)
+	}
+}
+
+func TestBitrateAnalyzerWindow(t *testing.T) {
+	stream := makeTestMonitorStream(300, true, nil)
+	for i, window := range []time.Duration{0, -time.Second, 5, 10 * time.Millisecond} {
+		a := NewBitrateAnalyzer(window)
+		want := window
+		if window < 10 {
+			want = time.Second
+		}
+		if a.window != want {
+			t.Errorf("%0d: NewBitrateAnalyzer(%v) => window %v, want %v", i, window, a.window, want)
+		}
+		if _, err := a.Write(stream); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBitrateAnalyzerBrokenAdaptationField(t *testing.T) {
+	stream := makeTestMonitorStream(300, true, func(i int, p []byte) []byte {
+		if i == 100 {
+			p[3] |= 0x30 // adaptation_field_control
+			p[4] = 200   // adaptation_field_length
+		}
+		return p
+	})
+	a := NewBitrateAnalyzer(time.Second)
+	if n, err := a.Write(stream); n != len(stream) || err != nil {
+		t.Errorf("Write() => %d, %v, want %d, nil", n, err, len(stream))
+	}
+	// as if the packet is not broken
+	want := NewBitrateAnalyzer(time.Second)
+	want.Write(makeTestMonitorStream(300, true, nil))
+	for _, pid := range []PID{PidPAT, 0x0100} {
+		if got, want := a.PID(pid), want.PID(pid); got != want {
+			t.Errorf("PID(0x%04X) => %+v, want %+v", pid, got, want)
+		}
+	}
+	if got, want := a.Transport(), want.Transport(); got != want {
+		t.Errorf("Transport() => %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "time"
+
+// streamClock estimates the time of the packets in the stream by the PCRs of
+// the first PCR_PID found, or by the constant bitrate if it is set. The time
+// is counted from the first PCR, and continues over the discontinuities of
+// the PCRs.
+type streamClock struct {
+	bitrate int64   // bits per second, or 0 to use the PCRs
+	pid     PID     // PID of the PCRs, PidNull before the first PCR
+	first   int64   // index of the packet of the first PCR
+	n       int64   // index of the packet of the last PCR
+	pcr     int64   // last PCR
+	ticks   int64   // time of the last PCR in units of 27 MHz
+	rate    float64 // 27 MHz ticks per packet, 0 until estimated
+}
+
+func newStreamClock(bitrate int64) *streamClock {
+	return &streamClock{bitrate: bitrate, pid: PidNull}
+}
+
+// packet updates the clock by the PCR of the n-th packet.
+func (c *streamClock) packet(p Packet, n int64) {
+	if c.bitrate > 0 {
+		return
+	}
+	af, _ := p.AdaptationField()
+	if len(af) < 8 || !af.HasPCR() {
+		return
+	}
+	pcr := af.PCR().Value()
+	if c.pid == PidNull {
+		c.pid = p.PID()
+		c.first = n
+		c.n = n
+		c.pcr = pcr
+		return
+	}
+	if p.PID() != c.pid || n <= c.n {
+		return
+	}
+	d := (pcr - c.pcr + clockReferenceWrap) % clockReferenceWrap
+	if af.IsDiscontinuous() || d > SystemClockFrequency {
+		// keep the rate over the discontinuity
+		d = int64(float64(n-c.n) * c.rate)
+	} else {
+		c.rate = float64(d) / float64(n-c.n)
+	}
+	c.ticks += d
+	c.n = n
+	c.pcr = pcr
+}
+
+// time returns the time of the n-th packet.
+func (c *streamClock) time(n int64) time.Duration {
+	if c.bitrate > 0 {
+		bits := n * packetDefaultSize * 8
+		return time.Duration(bits/c.bitrate)*time.Second + time.Duration(bits%c.bitrate)*time.Second/time.Duration(c.bitrate)
+	}
+	ticks := c.ticks + int64(float64(n-c.n)*c.rate)
+	if c.pid == PidNull {
+		ticks = 0
+	}
+	return ticksToDuration(ticks)
+}
+
+// timestamp returns the time of the timestamp in units of 90 kHz by the last
+// PCR. It is valid only when the clock uses the PCRs.
+func (c *streamClock) timestamp(ts int64) time.Duration {
+	d := (ts*300 - c.pcr) % clockReferenceWrap
+	if d < 0 {
+		d += clockReferenceWrap
+	}
+	if d >= clockReferenceWrap/2 {
+		d -= clockReferenceWrap
+	}
+	return ticksToDuration(c.ticks + d)
+}
+
+// ticksPerPacket returns the average time of a packet in units of 27 MHz, or
+// 0 if unknown.
+func (c *streamClock) ticksPerPacket() float64 {
+	if c.bitrate > 0 {
+		return float64(packetDefaultSize*8*SystemClockFrequency) / float64(c.bitrate)
+	}
+	if c.pid == PidNull || c.n == c.first {
+		return 0
+	}
+	return float64(c.ticks) / float64(c.n-c.first)
+}
+
+// ticksToDuration returns the duration of the ticks in units of 27 MHz.
+func ticksToDuration(ticks int64) time.Duration {
+	return time.Duration(ticks/SystemClockFrequency)*time.Second +
+		time.Duration(ticks%SystemClockFrequency)*time.Second/SystemClockFrequency
+}
@@ -0,0 +1,52 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStreamClock(t *testing.T) {
+	c := newStreamClock(0)
+	if d := c.time(5); d != 0 {
+		t.Errorf("time(5) before PCR => %v, want 0", d)
+	}
+	for i, tc := range []struct {
+		n      int64
+		pcr    int64
+		flags  byte
+		at     int64
+		expect time.Duration
+	}{
+		{10, 1000000, 0x00, 10, 0},
+		{20, 1027000, 0x00, 25, 1500 * time.Microsecond},
+		{30, 1054000, 0x00, 30, 2 * time.Millisecond},
+		// discontinuity keeps the rate
+		{40, 5000, 0x80, 45, 3500 * time.Microsecond},
+		{50, 32000, 0x00, 50, 4 * time.Millisecond},
+	} {
+		p := Packet(makeTestAFPacket(0x0100, 0, false, tc.flags, tc.pcr, nil))
+		c.packet(p, tc.n)
+		if d := c.time(tc.at); d != tc.expect {
+			t.Errorf("%0d: time(%d) => %v, want %v", i, tc.at, d, tc.expect)
+		}
+	}
+
+	c = newStreamClock(1504000)
+	if d := c.time(1500); d != 1500*time.Millisecond {
+		t.Errorf("time(1500) at 1504 kbps => %v, want 1.5s", d)
+	}
+}
@@ -0,0 +1,63 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "encoding/binary"
+
+// crcTable is the table of CRC-32/MPEG-2, whose polynomial is 0x04C11DB7
+// without reflection.
+var crcTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+func crc32MPEG2(b []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, v := range b {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+// ComputeCRC32 returns the CRC_32 computed from the bytes of the section
+// except CRC_32 itself.
+func (p PSI) ComputeCRC32() uint32 {
+	return crc32MPEG2(p[:len(p)-crc32size])
+}
+
+// VerifyCRC32 reports whether the CRC_32 of the section is correct.
+// The section must be sliced to its section_length.
+func (p PSI) VerifyCRC32() bool {
+	if len(p) < sectionMinSize+crc32size {
+		return false
+	}
+	return crc32MPEG2(p) == 0
+}
+
+// UpdateCRC32 sets the CRC_32 computed to the section.
+func (p PSI) UpdateCRC32() {
+	binary.BigEndian.PutUint32(p.CRC32(), p.ComputeCRC32())
+}
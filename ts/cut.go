@@ -0,0 +1,333 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNoProgram is returned when the program is not found in the stream.
+	ErrNoProgram = errors.New("ts: no program")
+
+	// ErrNoRandomAccessPoint is returned when no random access point precedes
+	// the start time.
+	ErrNoRandomAccessPoint = errors.New("ts: no random access point")
+)
+
+// cutProbePackets is the number of the packets read to detect an IDR picture.
+const cutProbePackets = 8
+
+// Cutter cuts a range of time out of a TS file into a standalone TS.
+//
+// The output starts at the random access point of the video stream preceding
+// the start time with the PAT and the PMT of the program, and contains the
+// elementary streams of the program only. Each elementary stream starts at its
+// first PES packet, and the PCRs before it are kept in the packets of the
+// adaptation field only. The discontinuity_indicator is set on the first
+// packet of each PID.
+type Cutter struct {
+	r       io.ReaderAt
+	size    int64
+	index   *Index
+	program ProgramNumber
+}
+
+// NewCutter returns a new Cutter to read the stream of size bytes from r.
+func NewCutter(r io.ReaderAt, size int64) *Cutter {
+	return &Cutter{r: r, size: size}
+}
+
+// UseIndex sets the Index of the stream built in advance. Otherwise Cut
+// builds it on the first call.
+func (c *Cutter) UseIndex(x *Index) {
+	c.index = x
+}
+
+// Program selects the program to cut. The default 0 selects the one of the
+// lowest program_number.
+func (c *Cutter) Program(number ProgramNumber) {
+	c.program = number
+}
+
+// cutProgram is the program to cut.
+type cutProgram struct {
+	tsid  TransportStreamID
+	pg    *Program
+	video PID  // PID to find the random access points
+	vtype byte // stream_type of video
+}
+
+// Cut writes the packets of the program between start and end to w. The times
+// are relative to the first PTS of the video stream, or of the first stream
+// if the program has no video, and continue over the wrap of the PTS.
+func (c *Cutter) Cut(w io.Writer, start, end time.Duration) error {
+	cp, err := c.findProgram()
+	if err != nil {
+		return err
+	}
+	if c.index == nil {
+		if c.index, err = BuildIndex(c.r, c.size); err != nil {
+			return err
+		}
+	}
+
+	var entries []IndexEntry
+	useRAI := false
+	for _, e := range c.index.Entries {
+		if e.PID == cp.video && e.Flags&IndexPTS != 0 {
+			entries = append(entries, e)
+			useRAI = useRAI || e.IsRandomAccess()
+		}
+	}
+	if len(entries) == 0 {
+		return ErrNoRandomAccessPoint
+	}
+	startPTS, endPTS := durationToPTS(start), durationToPTS(end)
+
+	low, high := int64(-1), c.size
+	for _, e := range entries {
+		pts := relativePTS(e.PTS, entries[0].PTS)
+		if low >= 0 && pts >= endPTS {
+			high = e.Offset
+			break
+		}
+		if pts > startPTS {
+			continue
+		}
+		rap := e.IsRandomAccess()
+		if !useRAI {
+			if rap, err = c.isRandomAccess(e, cp.vtype); err != nil {
+				return err
+			}
+		}
+		if rap {
+			low = e.Offset
+		}
+	}
+	if low < 0 {
+		return ErrNoRandomAccessPoint
+	}
+	return c.copy(w, cp, low, high)
+}
+
+func durationToPTS(d time.Duration) int64 {
+	return int64(d / time.Microsecond * TimestampFrequency / 1000000)
+}
+
+// relativePTS returns the PTS relative to the first one modulo 2^33. The PTS
+// in the half of the wrap before the first one is negative.
+func relativePTS(pts, first int64) int64 {
+	d := ((pts-first)%timestampWrap + timestampWrap) % timestampWrap
+	if d >= timestampWrap/2 {
+		d -= timestampWrap
+	}
+	return d
+}
+
+// findProgram reads the stream until the PMT of the program is found.
+func (c *Cutter) findProgram() (*cutProgram, error) {
+	errFound := errors.New("found")
+	cp := &cutProgram{}
+	d := NewDemuxer()
+	d.HandleSection(PidPAT, func(rx *SectionReceiver) error {
+		if pat, err := NewPAT(rx.Bytes()); err == nil {
+			cp.tsid = pat.TransportStreamID()
+		}
+		return nil
+	})
+	d.HandleProgram(func(pg *Program) error {
+		if c.program != 0 && pg.Number != c.program {
+			return nil
+		}
+		if c.program == 0 {
+			// the lowest program_number
+			if pgs := d.Programs(); len(pgs) > 0 && pgs[0] != pg {
+				return nil
+			}
+		}
+		cp.pg = pg
+		return errFound
+	})
+
+	pr := NewPacketReader(io.NewSectionReader(c.r, 0, c.size))
+	for {
+		p, err := pr.Next()
+		if err == io.EOF {
+			return nil, ErrNoProgram
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := d.WritePacket(p); err == errFound {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, st := range cp.pg.Streams {
+		if isVideo(st.StreamType) {
+			cp.video, cp.vtype = st.PID, st.StreamType
+			break
+		}
+	}
+	if cp.video == 0 && len(cp.pg.Streams) > 0 {
+		cp.video, cp.vtype = cp.pg.Streams[0].PID, cp.pg.Streams[0].StreamType
+	}
+	return cp, nil
+}
+
+// isRandomAccess reads the PES packet starting at the entry and detects the
+// random access point from the elementary stream.
+func (c *Cutter) isRandomAccess(e IndexEntry, streamType byte) (bool, error) {
+	buf := make([]byte, cutProbePackets*packetDefaultSize)
+	n, err := c.r.ReadAt(buf, e.Offset)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	var data []byte
+	for pos := 0; pos+packetDefaultSize <= n; pos += packetDefaultSize {
+		p := Packet(buf[pos : pos+packetDefaultSize])
+		if p.SyncByte() != SyncByte || p.PID() != e.PID {
+			continue
+		}
+		if pos > 0 && p.IsPayloadUnitStart() {
+			break
+		}
+		data = append(data, p.Payload()...)
+	}
+	pes, err := NewPES(data)
+	if err != nil {
+		return false, nil
+	}
+	return isRandomAccess(streamType, pes.Payload()), nil
+}
+
+// copy writes the PAT, the PMT and the packets of the program between the
+// offsets.
+func (c *Cutter) copy(w io.Writer, cp *cutProgram, low, high int64) error {
+	pat := NewPacketizer(PidPAT)
+	patSection := BuildPAT(cp.tsid, 0, map[ProgramNumber]PID{cp.pg.Number: cp.pg.PID})
+	pmt := NewPacketizer(cp.pg.PID)
+
+	pcrPID := cp.pg.PCRPID()
+	pids := map[PID]bool{pcrPID: true}
+	es := make(map[PID]bool)
+	for _, st := range cp.pg.Streams {
+		pids[st.PID] = true
+		es[st.PID] = true
+	}
+	delete(pids, cp.pg.PID)
+
+	write := func(packets ...Packet) error {
+		for _, p := range packets {
+			if _, err := w.Write(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := write(pat.Section(patSection)...); err != nil {
+		return err
+	}
+	if err := write(pmt.Section(cp.pg.PMT)...); err != nil {
+		return err
+	}
+
+	started := make(map[PID]bool)
+	pcrOnly := make(map[PID]uint8) // continuity_counter of the PCR packets before the start
+	shift := make(map[PID]uint8)   // added to the continuity_counter after the start
+	pr := NewPacketReader(io.NewSectionReader(c.r, low, high-low))
+	for {
+		p, err := pr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		pid := p.PID()
+		switch {
+		case pid == PidPAT:
+			// replace the PAT keeping its repetition
+			if p.IsPayloadUnitStart() {
+				err = write(pat.Section(patSection)...)
+			}
+		case pid == cp.pg.PID:
+			// continue the continuity_counter from the PMT written first
+			q := append(Packet{}, p...)
+			if q.HasPayload() {
+				setContinuityCounter(q, pmt.cc)
+				pmt.cc = (pmt.cc + 1) & 0x0F
+			}
+			err = write(q)
+		case pids[pid]:
+			if started[pid] {
+				if k := shift[pid]; k != 0 {
+					q := append(Packet{}, p...)
+					setContinuityCounter(q, q.ContinuityCounter()+k)
+					err = write(q)
+				} else {
+					err = write(p)
+				}
+				break
+			}
+			if es[pid] && !p.IsPayloadUnitStart() {
+				// drop the rest of the PES packet in progress keeping the PCR
+				af, _ := p.AdaptationField()
+				if pid != pcrPID || len(af) < 8 || !af.HasPCR() {
+					break
+				}
+				cc, ok := pcrOnly[pid]
+				var flags byte
+				if !ok {
+					cc, flags = p.ContinuityCounter(), 0x80
+					pcrOnly[pid] = cc
+				}
+				err = write(pcrPacket(pid, cc, flags, af.PCR().Value()))
+				break
+			}
+			started[pid] = true
+			q := append(Packet{}, p...)
+			if cc, ok := pcrOnly[pid]; ok {
+				// continue the continuity_counter from the PCR packets
+				shift[pid] = (cc + 1 - q.ContinuityCounter()) & 0x0F
+				setContinuityCounter(q, cc+1)
+			} else if !setDiscontinuity(q) {
+				err = write(adaptationFieldPacket(pid, q.ContinuityCounter()-1, 0x80))
+			}
+			if err == nil {
+				err = write(q)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// setDiscontinuity sets the discontinuity_indicator of the packet. It reports
+// false if the packet has no adaptation field to set it.
+func setDiscontinuity(p Packet) bool {
+	if p.AdaptationFieldLength() < 1 {
+		return false
+	}
+	p[5] |= 0x80
+	return true
+}
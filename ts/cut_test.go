@@ -0,0 +1,240 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// makeTestProgramStream makes a stream of the program 1 with PAT, PMT, frames
+// of H.264 video on 0x100 with the random access points at every 3 frames and
+// AAC audio on 0x101, and packets of another program on 0x200.
+func makeTestProgramStream(frames int) []byte {
+	pat := BuildPAT(0x7FE5, 0, map[ProgramNumber]PID{1: 0x1000, 2: 0x2000})
+	pmt := buildSection(0x02, 1, 0, []byte{
+		0xE1, 0x00, 0xF0, 0x00, // PCR_PID, program_info_length
+		StreamTypeH264, 0xE1, 0x00, 0xF0, 0x00,
+		StreamTypeAAC, 0xE1, 0x01, 0xF0, 0x00,
+	})
+
+	var stream []byte
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, pat...)))
+	stream = concatPacket(stream, makeTSPacket(0x1000, 0, true, append([]byte{0x00}, pmt...)))
+	for i := 0; i < frames; i++ {
+		var flags byte
+		if i%3 == 0 {
+			flags = 0x40
+		}
+		pts := int64(i)*3003 + 9000
+		stream = concatPacket(stream, makeTestAFPacket(0x100, uint8(i*2), true, flags, pts*300, makeTestPESWithPTS(0xE0, pts, 100)))
+		stream = concatPacket(stream, makeTSPacket(0x100, uint8(i*2+1), false, make([]byte, 184)))
+		stream = concatPacket(stream, makeTSPacket(0x101, uint8(i), true, makeTestPESWithPTS(0xC0, pts, 184)))
+		stream = concatPacket(stream, makeTSPacket(0x200, uint8(i), false, nil))
+	}
+	return stream
+}
+
+func TestCutter(t *testing.T) {
+	stream := makeTestProgramStream(10)
+	frame := 3003 * time.Second / 90000
+
+	var out bytes.Buffer
+	c := NewCutter(bytes.NewReader(stream), int64(len(stream)))
+	if err := c.Cut(&out, 5*frame, 8*frame); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDemuxer()
+	var events []Event
+	d.Observe(func(e Event) {
+		if e.Type != EventDiscontinuity {
+			events = append(events, e)
+		}
+	})
+	var pts []int64
+	d.HandleStreamType(StreamTypeH264, func(pid PID, pes PES) error {
+		pts = append(pts, pes.PTS())
+		return nil
+	})
+	discontinuous := make(map[PID]bool)
+	for _, pid := range []PID{0x100, 0x101, 0x200} {
+		pid := pid
+		d.HandlePacket(pid, func(p Packet) error {
+			if af, _ := p.AdaptationField(); af != nil && af.IsDiscontinuous() {
+				discontinuous[pid] = true
+			}
+			return nil
+		})
+	}
+	if _, err := d.Write(out.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if pgs := d.Programs(); len(pgs) != 1 || pgs[0].Number != 1 {
+		t.Errorf("got programs %v, want program 1 only", pgs)
+	}
+	exp := []int64{3*3003 + 9000, 4*3003 + 9000, 5*3003 + 9000, 6*3003 + 9000, 7*3003 + 9000}
+	if len(pts) != len(exp) {
+		t.Fatalf("got PTS %v, want %v", pts, exp)
+	}
+	for i := range exp {
+		if pts[i] != exp[i] {
+			t.Errorf("%d: got PTS %d, want %d", i, pts[i], exp[i])
+		}
+	}
+	if !discontinuous[0x100] || !discontinuous[0x101] || discontinuous[0x200] {
+		t.Errorf("got discontinuity %v, want 0x100 and 0x101", discontinuous)
+	}
+	if len(events) > 0 {
+		t.Errorf("got events %+v", events)
+	}
+}
+
+func TestCutterPTSWrap(t *testing.T) {
+	stream := makeTestProgramStream(10)
+	// the PTS wraps around at the frame 4
+	d := int64(timestampWrap - 4*3003 - 9000)
+	shiftTestTimestamps(stream, d)
+	frame := 3003 * time.Second / 90000
+
+	var out bytes.Buffer
+	if err := NewCutter(bytes.NewReader(stream), int64(len(stream))).Cut(&out, 5*frame, 8*frame); err != nil {
+		t.Fatal(err)
+	}
+	dmx := NewDemuxer()
+	var pts []int64
+	dmx.HandleStreamType(StreamTypeH264, func(pid PID, pes PES) error {
+		pts = append(pts, pes.PTS())
+		return nil
+	})
+	if _, err := dmx.Write(out.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := dmx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var exp []int64
+	for i := int64(3); i < 8; i++ {
+		exp = append(exp, (i*3003+9000+d)%timestampWrap)
+	}
+	if !reflect.DeepEqual(pts, exp) {
+		t.Errorf("got PTS %v, want %v", pts, exp)
+	}
+}
+
+func TestCutterIDR(t *testing.T) {
+	stream := makeTestProgramStream(10)
+	// clear the random_access_indicator and put an IDR slice at the frame 3
+	for pos := 0; pos < len(stream); pos += packetDefaultSize {
+		p := Packet(stream[pos : pos+packetDefaultSize])
+		if p.PID() != 0x100 || !p.HasAdaptationField() {
+			continue
+		}
+		p[5] &^= 0x40
+		if pes, _ := NewPES(p.Payload()); pes.PTS() == 3*3003+9000 {
+			copy(pes.Payload(), []byte{0x00, 0x00, 0x00, 0x01, 0x65})
+		}
+	}
+
+	var out bytes.Buffer
+	frame := 3003 * time.Second / 90000
+	if err := NewCutter(bytes.NewReader(stream), int64(len(stream))).Cut(&out, 5*frame, 8*frame); err != nil {
+		t.Fatal(err)
+	}
+	// PAT, PMT and the frame 3 follows
+	p := Packet(out.Bytes()[2*packetDefaultSize:])
+	pes, err := NewPES(p.Payload())
+	if err != nil || pes.PTS() != 3*3003+9000 {
+		t.Errorf("Cut() starts at %v, want PTS %d", pes, 3*3003+9000)
+	}
+
+	if err := NewCutter(bytes.NewReader(stream), int64(len(stream))).Cut(&out, 0, frame); err != ErrNoRandomAccessPoint {
+		t.Errorf("Cut() causes %v, want %s", err, ErrNoRandomAccessPoint)
+	}
+}
+
+func TestCutterPESInProgress(t *testing.T) {
+	// the audio on 0x101 carries the PCR, and its PES packets continue over
+	// the random access points of the video
+	pat := BuildPAT(0x7FE5, 0, map[ProgramNumber]PID{1: 0x1000})
+	pmt := buildSection(0x02, 1, 0, []byte{
+		0xE1, 0x01, 0xF0, 0x00, // PCR_PID, program_info_length
+		StreamTypeH264, 0xE1, 0x00, 0xF0, 0x00,
+		StreamTypeAAC, 0xE1, 0x01, 0xF0, 0x00,
+	})
+	var stream []byte
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, pat...)))
+	stream = concatPacket(stream, makeTSPacket(0x1000, 0, true, append([]byte{0x00}, pmt...)))
+	for i := 0; i < 10; i++ {
+		var flags byte
+		if i%3 == 0 {
+			flags = 0x40
+		}
+		pts := int64(i)*3003 + 9000
+		stream = concatPacket(stream, makeTestAFPacket(0x100, uint8(i), true, flags, -1, makeTestPESWithPTS(0xE0, pts, 100)))
+		stream = concatPacket(stream, makeTestAFPacket(0x101, uint8(i*2), false, 0, pts*300, make([]byte, 100)))
+		stream = concatPacket(stream, makeTSPacket(0x101, uint8(i*2+1), true, makeTestPESWithPTS(0xC0, pts, 184)))
+	}
+
+	var out bytes.Buffer
+	frame := 3003 * time.Second / 90000
+	if err := NewCutter(bytes.NewReader(stream), int64(len(stream))).Cut(&out, 5*frame, 8*frame); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDemuxer()
+	var events []Event
+	d.Observe(func(e Event) {
+		if e.Type != EventDiscontinuity {
+			events = append(events, e)
+		}
+	})
+	var first Packet
+	d.HandlePacket(0x101, func(p Packet) error {
+		if first == nil {
+			first = append(Packet{}, p...)
+		}
+		return nil
+	})
+	var pts []int64
+	d.HandlePES(0x101, func(pid PID, pes PES) error {
+		pts = append(pts, pes.PTS())
+		return nil
+	})
+	if _, err := d.Write(out.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	af, _ := first.AdaptationField()
+	if first == nil || first.HasPayload() || len(af) < 8 || !af.HasPCR() || !af.IsDiscontinuous() || af.PCR().Value() != (3*3003+9000)*300 {
+		t.Errorf("got the first packet of 0x%04X %X, want the PCR only", 0x101, first)
+	}
+	exp := []int64{3*3003 + 9000, 4*3003 + 9000, 5*3003 + 9000, 6*3003 + 9000, 7*3003 + 9000}
+	if len(pts) != len(exp) || pts[0] != exp[0] || pts[len(pts)-1] != exp[len(exp)-1] {
+		t.Errorf("got PTS %v, want %v", pts, exp)
+	}
+	if len(events) > 0 {
+		t.Errorf("got events %+v", events)
+	}
+}
@@ -0,0 +1,339 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"sort"
+)
+
+// PacketHandler handles the packet.
+// p is valid only until the handler returns.
+type PacketHandler func(p Packet) error
+
+// SectionHandler handles the section.
+// rx is valid only until the handler returns. It is released by the Demuxer.
+type SectionHandler func(rx *SectionReceiver) error
+
+// PESHandler handles the PES packet of the PID.
+// pes is valid only until the handler returns.
+type PESHandler func(pid PID, pes PES) error
+
+// Demuxer is a push-based demultiplexer. It accepts the stream by Write in
+// arbitrary chunks and calls the handlers registered for the PID.
+//
+// Demuxer follows PAT and PMT by itself. It subscribes the PMT PIDs found in
+// PAT and the elementary PIDs found in PMT, and updates the subscriptions when
+// their versions change.
+type Demuxer struct {
+	framer   packetFramer
+	pids     map[PID]*demuxPID
+	pat      map[byte]PAT // current PAT sections by section_number
+	programs map[ProgramNumber]*Program
+	program  []ProgramHandler
+	typed    map[byte][]PESHandler
+	observer Observer
+	skipTEI  bool
+	n        int64 // number of the packets written
+}
+
+type demuxPID struct {
+	continuity
+	packet  []PacketHandler
+	section []sectionHandler
+	pes     []PESHandler
+	psi     SectionHandler // PAT or PMT handler of the Demuxer itself
+	typed   []PESHandler   // handlers registered by stream_type
+	sec     *sectionBuffer
+	pesbuf  *pesBuffer
+	queue   []*SectionReceiver
+}
+
+// NewDemuxer returns a new Demuxer.
+func NewDemuxer() *Demuxer {
+	d := &Demuxer{
+		pids:     make(map[PID]*demuxPID),
+		pat:      make(map[byte]PAT),
+		programs: make(map[ProgramNumber]*Program),
+		typed:    make(map[byte][]PESHandler),
+	}
+	d.subscribePSI(PidPAT, d.handlePAT)
+	return d
+}
+
+func (d *Demuxer) pid(pid PID) *demuxPID {
+	dp, ok := d.pids[pid]
+	if !ok {
+		dp = &demuxPID{continuity: newContinuity()}
+		d.pids[pid] = dp
+	}
+	return dp
+}
+
+// Observe sets the observer of the events found in the packets of the PIDs
+// which have any handler.
+func (d *Demuxer) Observe(o Observer) {
+	d.observer = o
+}
+
+// SkipTransportErrors sets whether the packets with transport_error_indicator
+// are skipped. They are processed as normal by default.
+func (d *Demuxer) SkipTransportErrors(skip bool) {
+	d.skipTEI = skip
+}
+
+// HandlePacket registers the handler for the packets of the PID.
+func (d *Demuxer) HandlePacket(pid PID, h PacketHandler) {
+	dp := d.pid(pid)
+	dp.packet = append(dp.packet, h)
+}
+
+type sectionHandler struct {
+	h      SectionHandler
+	filter SectionFilter
+}
+
+// HandleSection registers the handler for the sections of the PID.
+// The handler is called only for the sections matched by all of the filters.
+func (d *Demuxer) HandleSection(pid PID, h SectionHandler, filters ...SectionFilter) {
+	dp := d.pid(pid)
+	if dp.sec == nil {
+		dp.sec = newSectionBuffer(pid)
+	}
+	sh := sectionHandler{h: h}
+	if len(filters) > 0 {
+		sh.filter = AllSectionFilters(filters...)
+	}
+	dp.section = append(dp.section, sh)
+}
+
+// HandlePES registers the handler for the PES packets of the PID.
+func (d *Demuxer) HandlePES(pid PID, h PESHandler) {
+	dp := d.pid(pid)
+	if dp.pesbuf == nil {
+		dp.pesbuf = &pesBuffer{}
+	}
+	dp.pes = append(dp.pes, h)
+}
+
+// HandleStreamType registers the handler for the PES packets of the
+// elementary streams whose stream_type is streamType in any program.
+func (d *Demuxer) HandleStreamType(streamType byte, h PESHandler) {
+	d.typed[streamType] = append(d.typed[streamType], h)
+	for _, pg := range d.programs {
+		for _, st := range pg.Streams {
+			if st.StreamType == streamType {
+				d.subscribeStream(st)
+			}
+		}
+	}
+}
+
+// HandleProgram registers the handler called when the PMT of a program is
+// received or updated.
+func (d *Demuxer) HandleProgram(h ProgramHandler) {
+	d.program = append(d.program, h)
+}
+
+// Write demultiplexes the packets in b. The packet split between the calls is
+// buffered until the rest is written.
+//
+// Write returns the first error returned by the handlers, and the number of
+// the bytes processed up to the end of the packet which caused the error.
+func (d *Demuxer) Write(b []byte) (int, error) {
+	return d.framer.write(b, d.WritePacket)
+}
+
+// WritePacket demultiplexes the packet.
+func (d *Demuxer) WritePacket(p Packet) error {
+	n := d.n
+	d.n++
+	dp, ok := d.pids[p.PID()]
+	if !ok {
+		return nil
+	}
+
+	if p.HasTransportError() {
+		d.observer.observe(Event{Type: EventTransportError, PID: p.PID(), Packet: n})
+		if d.skipTEI {
+			return nil
+		}
+	}
+
+	for _, h := range dp.packet {
+		if err := h(p); err != nil {
+			return err
+		}
+	}
+
+	ok, drop, err := inspect(p, n, &dp.continuity, d.observer)
+	if err != nil {
+		return err
+	}
+	if drop {
+		if dp.sec != nil {
+			dp.sec.drop()
+		}
+		if dp.pesbuf != nil {
+			dp.pesbuf.drop()
+		}
+	}
+	if !ok {
+		return nil
+	}
+
+	if dp.sec != nil {
+		if dp.sec.depacketize(p.Payload(), p.IsPayloadUnitStart(), dp.enqueue) {
+			d.observer.observe(Event{Type: EventTruncatedSection, PID: p.PID(), Packet: n})
+		}
+		for len(dp.queue) > 0 {
+			rx := dp.queue[0]
+			dp.queue[0] = nil
+			dp.queue = dp.queue[1:]
+			err := dp.deliver(rx)
+			rx.Release()
+			if err != nil {
+				for _, rx := range dp.queue {
+					rx.Release()
+				}
+				dp.queue = dp.queue[:0]
+				return err
+			}
+		}
+	}
+	if dp.pesbuf != nil {
+		return dp.pesbuf.depacketize(p.Payload(), p.IsPayloadUnitStart(), dp.emitPES(p.PID()))
+	}
+	return nil
+}
+
+func (dp *demuxPID) enqueue(rx *SectionReceiver) {
+	dp.queue = append(dp.queue, rx)
+}
+
+func (dp *demuxPID) deliver(rx *SectionReceiver) error {
+	if dp.psi != nil {
+		if err := dp.psi(rx); err != nil {
+			return err
+		}
+	}
+	for _, sh := range dp.section {
+		if sh.filter != nil && !sh.filter(rx.buf) {
+			continue
+		}
+		if err := sh.h(rx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dp *demuxPID) emitPES(pid PID) func(PES) error {
+	return func(pes PES) error {
+		for _, h := range dp.pes {
+			if err := h(pid, pes); err != nil {
+				return err
+			}
+		}
+		for _, h := range dp.typed {
+			if err := h(pid, pes); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// release drops the buffers no longer used by any handler.
+func (dp *demuxPID) release() {
+	if dp.psi == nil && len(dp.section) == 0 {
+		dp.sec = nil
+	}
+	if len(dp.pes) == 0 && len(dp.typed) == 0 {
+		dp.pesbuf = nil
+	}
+}
+
+// Close delivers the PES packets whose PES_packet_length is unbounded and
+// still in progress in the order of the PIDs. The partial packet written last
+// is discarded.
+func (d *Demuxer) Close() error {
+	d.framer.reset()
+	var pids []PID
+	for pid, dp := range d.pids {
+		if dp.pesbuf != nil {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Slice(pids, func(i, j int) bool {
+		return pids[i] < pids[j]
+	})
+	for _, pid := range pids {
+		dp := d.pids[pid]
+		if err := dp.pesbuf.flush(dp.emitPES(pid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packetFramer splits the byte chunks into the packets.
+type packetFramer struct {
+	buf []byte // partial packet starting with the sync byte
+}
+
+// write calls fn for each packet completed in b. Bytes outside the packets are
+// skipped until the sync byte is found. It returns the number of bytes of b
+// consumed, which ends with the packet causing the error if any.
+func (f *packetFramer) write(b []byte, fn func(Packet) error) (int, error) {
+	n := 0
+	if len(f.buf) > 0 {
+		k := packetDefaultSize - len(f.buf)
+		if k > len(b) {
+			f.buf = append(f.buf, b...)
+			return len(b), nil
+		}
+		f.buf = append(f.buf, b[:k]...)
+		n += k
+		err := fn(Packet(f.buf))
+		f.buf = f.buf[:0]
+		if err != nil {
+			return n, err
+		}
+	}
+	for n < len(b) {
+		if b[n] != SyncByte {
+			i := bytes.IndexByte(b[n:], SyncByte)
+			if i < 0 {
+				return len(b), nil
+			}
+			n += i
+		}
+		if len(b)-n < packetDefaultSize {
+			f.buf = append(f.buf[:0], b[n:]...)
+			return len(b), nil
+		}
+		err := fn(Packet(b[n : n+packetDefaultSize]))
+		n += packetDefaultSize
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (f *packetFramer) reset() {
+	f.buf = f.buf[:0]
+}
@@ -0,0 +1,198 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func makeTestPES(streamID byte, size int) []byte {
+	b := []byte{0x00, 0x00, 0x01, streamID, 0x00, 0x00, 0x80, 0x80, 0x05,
+		0x39, 0x8D, 0x15, 0xCF, 0x13}
+	for len(b) < size {
+		b = append(b, byte(len(b)))
+	}
+	return b
+}
+
+func packetizeTestPES(pid PID, cc uint8, pes []byte) []byte {
+	var stream []byte
+	for start := true; len(pes) > 0; start = false {
+		n := 184
+		if n > len(pes) {
+			n = len(pes)
+		}
+		stream = concatPacket(stream, makeTSPacket(pid, cc, start, pes[:n]))
+		pes = pes[n:]
+		cc++
+	}
+	return stream
+}
+
+func TestDemuxer(t *testing.T) {
+	sec := makeTestSection(0x00, 32)
+	pes1 := makeTestPES(0xE0, 400)
+	pes2 := makeTestPES(0xE0, 100)
+
+	var stream []byte
+	stream = concatPacket(stream, []byte{0x00, 0x01}) // garbage before the sync
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, sec...)))
+	stream = concatPacket(stream, packetizeTestPES(0x100, 0, pes1))
+	stream = concatPacket(stream, makeTSPacket(0x100, 3, true, pes2))
+	stream = concatPacket(stream, makeTSPacket(0x200, 0, false, nil))
+
+	d := NewDemuxer()
+	var packets int
+	d.HandlePacket(0x100, func(p Packet) error {
+		packets++
+		return nil
+	})
+	var sections [][]byte
+	d.HandleSection(PidPAT, func(rx *SectionReceiver) error {
+		sections = append(sections, append([]byte{}, rx.Bytes()...))
+		return nil
+	})
+	var pess [][]byte
+	d.HandlePES(0x100, func(pid PID, pes PES) error {
+		if pid != 0x100 {
+			t.Errorf("PESHandler is called with PID 0x%04X, want 0x%04X", pid, 0x100)
+		}
+		pess = append(pess, append([]byte{}, pes...))
+		return nil
+	})
+
+	// write in chunks which split the packets
+	for len(stream) > 0 {
+		n := 100
+		if n > len(stream) {
+			n = len(stream)
+		}
+		if _, err := d.Write(stream[:n]); err != nil {
+			t.Fatal(err)
+		}
+		stream = stream[n:]
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if packets != 4 {
+		t.Errorf("got %d packets, want %d", packets, 4)
+	}
+	if len(sections) != 1 || !bytes.Equal(sections[0], sec) {
+		t.Errorf("got sections 0x%02X, want 0x%02X", sections, sec)
+	}
+	if len(pess) != 2 {
+		t.Fatalf("got %d PES packets, want %d", len(pess), 2)
+	}
+	// unbounded PES packets are padded by the stuffing bytes of the last packet
+	if !bytes.HasPrefix(pess[0], pes1) || len(pess[0]) != 3*184 {
+		t.Errorf("got PES 0x%02X, want 0x%02X", pess[0], pes1)
+	}
+	if !bytes.HasPrefix(pess[1], pes2) {
+		t.Errorf("got PES 0x%02X, want 0x%02X", pess[1], pes2)
+	}
+}
+
+func TestDemuxerPESLengthSplit(t *testing.T) {
+	// PES_packet_length in the second packet
+	pes := makeTestPES(0xE0, 150)
+	pes[4], pes[5] = 0x00, 150-pesHeaderSize
+	var stream []byte
+	stream = concatPacket(stream, makeTestAFPacket(0x100, 0, true, 0x00, -1, pes[:4]))
+	stream = concatPacket(stream, makeTSPacket(0x100, 1, false, pes[4:]))
+
+	d := NewDemuxer()
+	var pess [][]byte
+	d.HandlePES(0x100, func(pid PID, pes PES) error {
+		pess = append(pess, append([]byte{}, pes...))
+		return nil
+	})
+	if _, err := d.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	// delivered by PES_packet_length without Close
+	if len(pess) != 1 || !bytes.Equal(pess[0], pes) {
+		t.Errorf("got PES 0x%02X, want 0x%02X", pess, pes)
+	}
+}
+
+func TestDemuxerCloseOrder(t *testing.T) {
+	var stream []byte
+	pids := []PID{0x105, 0x101, 0x104, 0x102, 0x103}
+	for _, pid := range pids {
+		stream = concatPacket(stream, makeTSPacket(pid, 0, true, makeTestPES(0xE0, 100)))
+	}
+	for n := 0; n < 10; n++ {
+		d := NewDemuxer()
+		var got []PID
+		for _, pid := range pids {
+			d.HandlePES(pid, func(pid PID, pes PES) error {
+				got = append(got, pid)
+				return nil
+			})
+		}
+		if _, err := d.Write(stream); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for i, pid := range got {
+			if pid != PID(0x101+i) {
+				t.Fatalf("Close() delivers the PES packets of %v, want in order", got)
+			}
+		}
+	}
+}
+
+func TestDemuxerWriteError(t *testing.T) {
+	var stream []byte
+	stream = concatPacket(stream, []byte{0x00, 0x01})
+	for cc := uint8(0); cc < 3; cc++ {
+		stream = concatPacket(stream, makeTSPacket(0x100, cc, false, nil))
+	}
+	errStop := errors.New("stop")
+
+	for i, tc := range []struct {
+		split int // bytes written before
+		fail  int // packet returning the error
+		n     int
+	}{
+		{0, 1, 2 + 2*188},
+		{0, 0, 2 + 188},
+		{100, 0, 2 + 188 - 100},
+		{100, 2, 2 + 3*188 - 100},
+	} {
+		d := NewDemuxer()
+		packets := 0
+		d.HandlePacket(0x100, func(p Packet) error {
+			packets++
+			if packets-1 == tc.fail {
+				return errStop
+			}
+			return nil
+		})
+		if _, err := d.Write(stream[:tc.split]); err != nil {
+			t.Fatal(err)
+		}
+		n, err := d.Write(stream[tc.split:])
+		if n != tc.n || err != errStop {
+			t.Errorf("%0d: Write() => %d, %v, want %d, %s", i, n, err, tc.n, errStop)
+		}
+	}
+}
@@ -82,12 +82,43 @@ func (d Descriptor) Length() int {
 	return int(d[1])
 }
 
+// Data returns the bytes following the descriptor_length.
+func (d Descriptor) Data() []byte {
+	return d[2:]
+}
+
+// caPIDs returns the CA_PIDs of the CA_descriptors in descriptors.
+func caPIDs(descriptors []Descriptor) []PID {
+	var pids []PID
+	for _, d := range descriptors {
+		if d.Tag() == TagCA && d.Length() >= 4 {
+			pids = append(pids, caPID(d))
+		}
+	}
+	return pids
+}
+
+// BuildDescriptor returns a new descriptor of the tag having the data.
+func BuildDescriptor(tag DescriptorTag, data []byte) Descriptor {
+	d := make(Descriptor, 0, 2+len(data))
+	d = append(d, byte(tag), byte(len(data)))
+	return append(d, data...)
+}
+
+// caPID returns the CA_PID of the CA_descriptor.
+func caPID(d Descriptor) PID {
+	return PID(uint16(d[5]) | uint16(d[4]&0x1F)<<8)
+}
+
 // Descriptors returns the descriptors from b.
 func Descriptors(b []byte) []Descriptor {
 	headsize := 2 // size of descriptor_tag .. descriptor_length
 	var descriptors []Descriptor
-	for pos := 0; pos < len(b); {
+	for pos := 0; pos+headsize <= len(b); {
 		size := headsize + Descriptor(b[pos:]).Length()
+		if pos+size > len(b) {
+			break
+		}
 		d := Descriptor(b[pos : pos+size])
 		pos += len(d)
 		descriptors = append(descriptors, d)
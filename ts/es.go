@@ -0,0 +1,593 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ErrInvalidADTS is returned when an ADTS frame does not start with the
+// syncword.
+var ErrInvalidADTS = errors.New("ts: invalid ADTS frame")
+
+var startCode = []byte{0x00, 0x00, 0x01}
+
+// nalMaxSize is the maximum size of a NAL unit read.
+const nalMaxSize = 16 << 20
+
+// adtsSamplingFrequencies is the sampling frequencies by the
+// sampling_frequency_index of ADTS.
+var adtsSamplingFrequencies = []int{
+	96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// isVideo reports whether the stream_type is a video stream.
+func isVideo(streamType byte) bool {
+	switch streamType {
+	case StreamTypeMPEG1Video, StreamTypeMPEG2Video, StreamTypeMPEG4Video,
+		StreamTypeH264, StreamTypeHEVC:
+		return true
+	}
+	return false
+}
+
+// isRandomAccess reports whether the elementary stream data of the
+// stream_type contains a random access point, that is, an IDR picture of
+// H.264, an IRAP picture of HEVC or a sequence header of MPEG video.
+// The data of the other stream types is always a random access point.
+func isRandomAccess(streamType byte, data []byte) bool {
+	if !isVideo(streamType) {
+		return true
+	}
+	for {
+		i := bytes.Index(data, startCode)
+		if i < 0 || i+3 >= len(data) {
+			return false
+		}
+		b := data[i+3]
+		data = data[i+3:]
+		switch streamType {
+		case StreamTypeH264:
+			if b&0x1F == 5 { // coded slice of an IDR picture
+				return true
+			}
+		case StreamTypeHEVC:
+			if t := b >> 1 & 0x3F; 16 <= t && t <= 21 { // BLA, IDR or CRA
+				return true
+			}
+		default:
+			if b == 0xB3 { // sequence_header_code
+				return true
+			}
+		}
+	}
+}
+
+// splitNAL is a bufio.SplitFunc to split the Annex B byte stream into the
+// NAL units without the start codes and the trailing zero bytes.
+func splitNAL(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	i := bytes.Index(data, startCode)
+	if i < 0 {
+		if atEOF {
+			return len(data), nil, nil
+		}
+		return 0, nil, nil
+	}
+	j := bytes.Index(data[i+3:], startCode)
+	if j < 0 {
+		if atEOF {
+			return len(data), bytes.TrimRight(data[i+3:], "\x00"), nil
+		}
+		return i, nil, nil
+	}
+	return i + 3 + j, bytes.TrimRight(data[i+3:i+3+j], "\x00"), nil
+}
+
+// accessUnitReader reads the access units of H.264 or HEVC from the Annex B
+// byte stream.
+type accessUnitReader struct {
+	s          *bufio.Scanner
+	streamType byte
+	au         []byte // access unit in progress
+	vcl        bool   // whether au has a VCL NAL unit
+
+	sps      map[uint]*spsInfo // SPSs by ID
+	pps      map[uint]*ppsInfo // PPSs by ID
+	sequence int64             // number of the coded video sequences
+	poc      int64             // picture order count of the last access unit
+	prevMsb  int64             // PicOrderCntMsb of the previous picture
+	prevLsb  int64             // pic_order_cnt_lsb of the previous picture
+	started  bool              // whether an access unit is ordered
+}
+
+// spsInfo is the fields of an SPS to get the picture order count.
+type spsInfo struct {
+	separateColourPlane bool
+	log2MaxFrameNum     uint // H.264 only
+	pocType             uint // H.264 only
+	log2MaxPOCLsb       uint
+	frameMbsOnly        bool // H.264 only
+}
+
+// ppsInfo is the fields of a PPS to get the picture order count.
+type ppsInfo struct {
+	sps               uint
+	bottomFieldPOC    bool // bottom_field_pic_order_in_frame_present_flag of H.264
+	outputFlagPresent bool // output_flag_present_flag of HEVC
+	extraBits         uint // num_extra_slice_header_bits of HEVC
+}
+
+func newAccessUnitReader(r io.Reader, streamType byte) *accessUnitReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, nalMaxSize)
+	s.Split(splitNAL)
+	return &accessUnitReader{
+		s:          s,
+		streamType: streamType,
+		sps:        make(map[uint]*spsInfo),
+		pps:        make(map[uint]*ppsInfo),
+	}
+}
+
+// next returns the next access unit in the Annex B byte stream format.
+func (ar *accessUnitReader) next() ([]byte, error) {
+	for ar.s.Scan() {
+		nal := ar.s.Bytes()
+		if len(nal) == 0 {
+			continue
+		}
+		vcl, first := ar.classify(nal)
+		var au []byte
+		if ar.vcl && first {
+			au, ar.au, ar.vcl = ar.au, nil, false
+		}
+		ar.au = append(ar.au, 0x00, 0x00, 0x00, 0x01)
+		ar.au = append(ar.au, nal...)
+		ar.vcl = ar.vcl || vcl
+		if au != nil {
+			return au, nil
+		}
+	}
+	if err := ar.s.Err(); err != nil {
+		return nil, err
+	}
+	if len(ar.au) == 0 {
+		return nil, io.EOF
+	}
+	au := ar.au
+	ar.au, ar.vcl = nil, false
+	return au, nil
+}
+
+// classify reports whether the NAL unit is a VCL NAL unit and whether it can
+// be the first NAL unit of an access unit.
+func (ar *accessUnitReader) classify(nal []byte) (vcl, first bool) {
+	if ar.streamType == StreamTypeHEVC {
+		if len(nal) < 3 {
+			return false, false
+		}
+		switch t := nal[0] >> 1 & 0x3F; {
+		case t < 32:
+			return true, nal[2]&0x80 != 0 // first_slice_segment_in_pic_flag
+		case 32 <= t && t <= 35, t == 39, 41 <= t && t <= 44, 48 <= t && t <= 55:
+			return false, true // VPS, SPS, PPS, AUD, prefix SEI and reserved
+		}
+		return false, false
+	}
+	if len(nal) < 2 {
+		return false, false
+	}
+	switch t := nal[0] & 0x1F; {
+	case 1 <= t && t <= 5:
+		return true, nal[1]&0x80 != 0 // first_mb_in_slice is 0
+	case 6 <= t && t <= 9, 14 <= t && t <= 18:
+		return false, true // SEI, SPS, PPS, AUD and reserved
+	}
+	return false, false
+}
+
+// pictureOrder returns the order of the access unit in the output order,
+// which is made of the number of the coded video sequences and the picture
+// order count. The parameter sets in the access unit are kept for the
+// following ones. The access units whose picture order count is not known,
+// e.g. of pic_order_cnt_type 1 or 2 of H.264, are ordered in the decoding
+// order.
+func (ar *accessUnitReader) pictureOrder(au []byte) int64 {
+	var poc int64
+	var first, ok bool
+	if ar.streamType == StreamTypeHEVC {
+		poc, first, ok = ar.hevcPictureOrderCount(nalUnits(au))
+	} else {
+		poc, first, ok = ar.avcPictureOrderCount(nalUnits(au))
+	}
+	if first {
+		ar.sequence++
+	}
+	if !ok {
+		poc = ar.poc + 1
+		if first {
+			poc = 0
+		}
+	}
+	ar.poc, ar.started = poc, true
+	return ar.sequence<<32 + poc
+}
+
+// avcPictureOrderCount returns the picture order count of H.264 in the NAL
+// units of an access unit and whether it starts a coded video sequence.
+func (ar *accessUnitReader) avcPictureOrderCount(nals [][]byte) (poc int64, first, ok bool) {
+	for _, nal := range nals {
+		if len(nal) < 2 {
+			continue
+		}
+		r := &bitReader{b: unescapeRBSP(nal[1:])}
+		switch t := nal[0] & 0x1F; t {
+		case 7: // SPS
+			sps := &spsInfo{}
+			profile := r.bits(8)
+			r.bits(16) // constraint_set flags, level_idc
+			id := r.ue()
+			switch profile {
+			case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+				chroma := r.ue()
+				if chroma == 3 {
+					sps.separateColourPlane = r.bits(1) == 1
+				}
+				r.ue()              // bit_depth_luma_minus8
+				r.ue()              // bit_depth_chroma_minus8
+				r.bits(1)           // qpprime_y_zero_transform_bypass_flag
+				if r.bits(1) == 1 { // seq_scaling_matrix_present_flag
+					n := 8
+					if chroma == 3 {
+						n = 12
+					}
+					for i := 0; i < n; i++ {
+						if r.bits(1) == 0 {
+							continue
+						}
+						if i < 6 {
+							r.scalingList(16)
+						} else {
+							r.scalingList(64)
+						}
+					}
+				}
+			}
+			sps.log2MaxFrameNum = r.ue() + 4
+			sps.pocType = r.ue()
+			switch sps.pocType {
+			case 0:
+				sps.log2MaxPOCLsb = r.ue() + 4
+			case 1:
+				r.bits(1) // delta_pic_order_always_zero_flag
+				r.se()    // offset_for_non_ref_pic
+				r.se()    // offset_for_top_to_bottom_field
+				for n := r.ue(); n > 0 && r.err == nil; n-- {
+					r.se() // offset_for_ref_frame
+				}
+			}
+			r.ue()    // max_num_ref_frames
+			r.bits(1) // gaps_in_frame_num_value_allowed_flag
+			r.ue()    // pic_width_in_mbs_minus1
+			r.ue()    // pic_height_in_map_units_minus1
+			sps.frameMbsOnly = r.bits(1) == 1
+			if r.err == nil {
+				ar.sps[id] = sps
+			}
+		case 8: // PPS
+			id := r.ue()
+			pps := &ppsInfo{sps: r.ue()}
+			r.bits(1) // entropy_coding_mode_flag
+			pps.bottomFieldPOC = r.bits(1) == 1
+			if r.err == nil {
+				ar.pps[id] = pps
+			}
+		case 1, 5:
+			if nal[1]&0x80 == 0 { // first_mb_in_slice is not 0
+				continue
+			}
+			idr := t == 5
+			r.ue() // first_mb_in_slice
+			r.ue() // slice_type
+			pps := ar.pps[r.ue()]
+			if pps == nil || ar.sps[pps.sps] == nil {
+				return 0, idr, false
+			}
+			sps := ar.sps[pps.sps]
+			if sps.separateColourPlane {
+				r.bits(2) // colour_plane_id
+			}
+			r.bits(sps.log2MaxFrameNum)              // frame_num
+			if !sps.frameMbsOnly && r.bits(1) == 1 { // field_pic_flag
+				r.bits(1) // bottom_field_flag
+			}
+			if idr {
+				r.ue() // idr_pic_id
+			}
+			if sps.pocType != 0 {
+				return 0, idr, false
+			}
+			lsb := int64(r.bits(sps.log2MaxPOCLsb))
+			if r.err != nil {
+				return 0, idr, false
+			}
+			if idr {
+				ar.prevMsb, ar.prevLsb = 0, 0
+			}
+			msb := pocMsb(ar.prevMsb, ar.prevLsb, lsb, 1<<sps.log2MaxPOCLsb)
+			if nal[0]&0x60 != 0 { // nal_ref_idc of a reference picture
+				ar.prevMsb, ar.prevLsb = msb, lsb
+			}
+			return msb + lsb, idr, true
+		}
+	}
+	return 0, false, false
+}
+
+// hevcPictureOrderCount returns the picture order count of HEVC in the NAL
+// units of an access unit and whether it starts a coded video sequence.
+func (ar *accessUnitReader) hevcPictureOrderCount(nals [][]byte) (poc int64, first, ok bool) {
+	for _, nal := range nals {
+		if len(nal) < 3 {
+			continue
+		}
+		r := &bitReader{b: unescapeRBSP(nal[2:])}
+		switch t := nal[0] >> 1 & 0x3F; {
+		case t == 33: // SPS
+			r.bits(4) // sps_video_parameter_set_id
+			subLayers := r.bits(3)
+			r.bits(1) // sps_temporal_id_nesting_flag
+			// profile_tier_level
+			r.bits(32)
+			r.bits(32)
+			r.bits(32)
+			present := make([]uint32, subLayers)
+			for i := range present {
+				present[i] = r.bits(2) // sub_layer_profile_present_flag, sub_layer_level_present_flag
+			}
+			if subLayers > 0 {
+				r.bits(2 * (8 - uint(subLayers))) // reserved_zero_2bits
+			}
+			for _, f := range present {
+				if f&0x02 != 0 {
+					r.bits(32)
+					r.bits(32)
+					r.bits(24)
+				}
+				if f&0x01 != 0 {
+					r.bits(8) // sub_layer_level_idc
+				}
+			}
+			id := r.ue()
+			sps := &spsInfo{}
+			if r.ue() == 3 { // chroma_format_idc
+				sps.separateColourPlane = r.bits(1) == 1
+			}
+			r.ue()              // pic_width_in_luma_samples
+			r.ue()              // pic_height_in_luma_samples
+			if r.bits(1) == 1 { // conformance_window_flag
+				r.ue()
+				r.ue()
+				r.ue()
+				r.ue()
+			}
+			r.ue() // bit_depth_luma_minus8
+			r.ue() // bit_depth_chroma_minus8
+			sps.log2MaxPOCLsb = r.ue() + 4
+			if r.err == nil {
+				ar.sps[id] = sps
+			}
+		case t == 34: // PPS
+			id := r.ue()
+			pps := &ppsInfo{sps: r.ue()}
+			r.bits(1) // dependent_slice_segments_enabled_flag
+			pps.outputFlagPresent = r.bits(1) == 1
+			pps.extraBits = uint(r.bits(3))
+			if r.err == nil {
+				ar.pps[id] = pps
+			}
+		case t < 32 && nal[2]&0x80 != 0:
+			irap := 16 <= t && t <= 23
+			idr := t == 19 || t == 20
+			// IDR, BLA, or CRA as the first picture has NoRaslOutputFlag
+			first := idr || 16 <= t && t <= 18 || t == 21 && !ar.started
+			r.bits(1) // first_slice_segment_in_pic_flag
+			if irap {
+				r.bits(1) // no_output_of_prior_pics_flag
+			}
+			pps := ar.pps[r.ue()]
+			if pps == nil || ar.sps[pps.sps] == nil {
+				return 0, first, false
+			}
+			sps := ar.sps[pps.sps]
+			r.bits(pps.extraBits) // slice_reserved_flag
+			r.ue()                // slice_type
+			if pps.outputFlagPresent {
+				r.bits(1) // pic_output_flag
+			}
+			if sps.separateColourPlane {
+				r.bits(2) // colour_plane_id
+			}
+			var lsb int64
+			if !idr {
+				lsb = int64(r.bits(sps.log2MaxPOCLsb)) // slice_pic_order_cnt_lsb
+			}
+			if r.err != nil {
+				return 0, first, false
+			}
+			var msb int64
+			if !first {
+				msb = pocMsb(ar.prevMsb, ar.prevLsb, lsb, 1<<sps.log2MaxPOCLsb)
+			}
+			// prevTid0Pic is not a RADL, RASL or sub-layer non-reference picture
+			if nal[1]&0x07 == 1 && !(6 <= t && t <= 9) && !(t <= 14 && t%2 == 0) {
+				ar.prevMsb, ar.prevLsb = msb, lsb
+			}
+			return msb + lsb, first, true
+		}
+	}
+	return 0, false, false
+}
+
+// pocMsb returns PicOrderCntMsb of the pic_order_cnt_lsb from those of the
+// previous picture.
+func pocMsb(prevMsb, prevLsb, lsb, max int64) int64 {
+	switch {
+	case lsb < prevLsb && prevLsb-lsb >= max/2:
+		return prevMsb + max
+	case lsb > prevLsb && lsb-prevLsb > max/2:
+		return prevMsb - max
+	}
+	return prevMsb
+}
+
+// unescapeRBSP returns the bytes of the NAL unit without the
+// emulation_prevention_three_bytes.
+func unescapeRBSP(b []byte) []byte {
+	if bytes.Index(b, []byte{0x00, 0x00, 0x03}) < 0 {
+		return b
+	}
+	rbsp := make([]byte, 0, len(b))
+	zeros := 0
+	for _, v := range b {
+		if zeros >= 2 && v == 0x03 {
+			zeros = 0
+			continue
+		}
+		if v == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, v)
+	}
+	return rbsp
+}
+
+// bitReader reads the bits of RBSP. The err is set if it runs out of the bits.
+type bitReader struct {
+	b   []byte
+	pos uint // position in bits
+	err error
+}
+
+// bits reads n bits up to 32.
+func (r *bitReader) bits(n uint) uint32 {
+	var v uint32
+	for ; n > 0; n-- {
+		if r.pos >= uint(len(r.b))*8 {
+			r.err = io.ErrUnexpectedEOF
+			return 0
+		}
+		v = v<<1 | uint32(r.b[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+	return v
+}
+
+// ue reads the Exp-Golomb code ue(v).
+func (r *bitReader) ue() uint {
+	zeros := uint(0)
+	for r.bits(1) == 0 {
+		if r.err != nil || zeros >= 32 {
+			r.err = io.ErrUnexpectedEOF
+			return 0
+		}
+		zeros++
+	}
+	return uint(1)<<zeros - 1 + uint(r.bits(zeros))
+}
+
+// se reads the Exp-Golomb code se(v).
+func (r *bitReader) se() int {
+	k := r.ue()
+	if k%2 == 1 {
+		return int(k/2 + 1)
+	}
+	return -int(k / 2)
+}
+
+// scalingList reads the scaling_list of H.264 of the size.
+func (r *bitReader) scalingList(size int) {
+	last, next := 8, 8
+	for i := 0; i < size && r.err == nil; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256 // delta_scale
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// adtsReader reads the ADTS frames of AAC.
+type adtsReader struct {
+	r *bufio.Reader
+}
+
+// next returns the next ADTS frame, the sampling frequency and the number of
+// the samples.
+func (ar *adtsReader) next() (frame []byte, rate, samples int, err error) {
+	h, err := ar.r.Peek(7)
+	if err == io.EOF && len(h) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	size, rate, samples, err := parseADTSHeader(h)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	frame = make([]byte, size)
+	if _, err := io.ReadFull(ar.r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, 0, err
+	}
+	return frame, rate, samples, nil
+}
+
+// parseADTSHeader returns the frame_length, the sampling frequency and the
+// number of the samples of the ADTS frame from the 7 bytes header h.
+func parseADTSHeader(h []byte) (size, rate, samples int, err error) {
+	if h[0] != 0xFF || h[1]&0xF6 != 0xF0 { // syncword, layer
+		return 0, 0, 0, ErrInvalidADTS
+	}
+	size = int(h[3]&0x03)<<11 | int(h[4])<<3 | int(h[5])>>5
+	index := int(h[2] >> 2 & 0x0F)
+	if size < 7 || index >= len(adtsSamplingFrequencies) {
+		return 0, 0, 0, ErrInvalidADTS
+	}
+	samples = (int(h[6]&0x03) + 1) * 1024 // number_of_raw_data_blocks_in_frame
+	return size, adtsSamplingFrequencies[index], samples, nil
+}
+
+// nalUnits returns the NAL units in the Annex B byte stream b.
+func nalUnits(b []byte) [][]byte {
+	var nals [][]byte
+	for len(b) > 0 {
+		n, nal, _ := splitNAL(b, true)
+		if len(nal) > 0 {
+			nals = append(nals, nal)
+		}
+		b = b[n:]
+	}
+	return nals
+}
@@ -0,0 +1,120 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestAccessUnitReader(t *testing.T) {
+	for i, tc := range []struct {
+		streamType byte
+		nals       [][]byte
+		aus        []int // NAL units in the access units
+	}{
+		{
+			StreamTypeH264,
+			[][]byte{
+				{0x09, 0xF0},             // AUD
+				{0x67, 0x64, 0x00, 0x28}, // SPS
+				{0x68, 0xEE},             // PPS
+				{0x65, 0x88, 0x00},       // IDR, first_mb_in_slice 0
+				{0x65, 0x40, 0x00},       // IDR, first_mb_in_slice 1
+				{0x41, 0x9A, 0x00},       // non-IDR
+				{0x06, 0x05},             // SEI
+				{0x41, 0x9A, 0x00},       // non-IDR
+			},
+			[]int{5, 1, 2},
+		},
+		{
+			StreamTypeHEVC,
+			[][]byte{
+				{0x40, 0x01, 0x0C}, // VPS
+				{0x42, 0x01, 0x01}, // SPS
+				{0x44, 0x01, 0xC0}, // PPS
+				{0x26, 0x01, 0xAF}, // IDR_W_RADL, first slice
+				{0x26, 0x01, 0x20}, // IDR_W_RADL
+				{0x02, 0x01, 0xD0}, // TRAIL_R, first slice
+			},
+			[]int{5, 1},
+		},
+	} {
+		var stream []byte
+		for j, nal := range tc.nals {
+			if j%2 == 0 {
+				stream = append(stream, 0x00)
+			}
+			stream = append(stream, 0x00, 0x00, 0x01)
+			stream = append(stream, nal...)
+		}
+		ar := newAccessUnitReader(bytes.NewReader(stream), tc.streamType)
+		var got []int
+		for {
+			au, err := ar.next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, len(nalUnits(au)))
+		}
+		if !reflect.DeepEqual(got, tc.aus) {
+			t.Errorf("%0d: next() => access units of %v NAL units, want %v", i, got, tc.aus)
+		}
+	}
+}
+
+func makeTestADTS(rateIndex byte, size int) []byte {
+	b := make([]byte, size)
+	b[0] = 0xFF
+	b[1] = 0xF1
+	b[2] = 0x40 | rateIndex<<2
+	b[3] = byte(size >> 11 & 0x03)
+	b[4] = byte(size >> 3)
+	b[5] = byte(size<<5) | 0x1F
+	b[6] = 0xFC
+	return b
+}
+
+func TestADTSReader(t *testing.T) {
+	stream := append(makeTestADTS(3, 100), makeTestADTS(3, 20)...)
+	ar := &adtsReader{r: bufio.NewReader(bytes.NewReader(stream))}
+	for i, size := range []int{100, 20} {
+		frame, rate, samples, err := ar.next()
+		if err != nil {
+			t.Fatalf("%0d: next() causes %s", i, err)
+		}
+		if len(frame) != size || rate != 48000 || samples != 1024 {
+			t.Errorf("%0d: next() => %d bytes, %d Hz, %d samples, want %d bytes, 48000 Hz, 1024 samples", i, len(frame), rate, samples, size)
+		}
+	}
+	if _, _, _, err := ar.next(); err != io.EOF {
+		t.Errorf("next() causes %v, want %s", err, io.EOF)
+	}
+
+	ar = &adtsReader{r: bufio.NewReader(bytes.NewReader(make([]byte, 10)))}
+	if _, _, _, err := ar.next(); err != ErrInvalidADTS {
+		t.Errorf("next() causes %v, want %s", err, ErrInvalidADTS)
+	}
+	ar = &adtsReader{r: bufio.NewReader(bytes.NewReader(makeTestADTS(3, 100)[:50]))}
+	if _, _, _, err := ar.next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("next() causes %v, want %s", err, io.ErrUnexpectedEOF)
+	}
+}
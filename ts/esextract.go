@@ -0,0 +1,314 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNoStream is returned when the elementary stream is not found.
+var ErrNoStream = errors.New("ts: no stream")
+
+// ESExtractor extracts an elementary stream from a transport stream. It
+// writes the payloads of the PES packets as they are, that is, the Annex B
+// byte stream of video, the ADTS frames of AAC, the AC-3 frames and so on.
+type ESExtractor struct {
+	pid        PID
+	streamType byte
+	timestamps io.Writer
+}
+
+// NewESExtractor returns a new ESExtractor of the PID.
+func NewESExtractor(pid PID) *ESExtractor {
+	return &ESExtractor{pid: pid}
+}
+
+// NewESExtractorByStreamType returns a new ESExtractor of the first
+// elementary stream of the stream_type found in the PMTs.
+func NewESExtractorByStreamType(streamType byte) *ESExtractor {
+	return &ESExtractor{pid: PidNull, streamType: streamType}
+}
+
+// Timestamps sets the writer of the sidecar timestamps of the access units.
+// A line is written for each access unit with the offset and the size in the
+// elementary stream, the PTS and the DTS separated by tabs. The access units
+// are the ADTS frames of AAC and the access units of H.264 and HEVC, and the
+// payloads of the PES packets of the other streams. An access unit starting in
+// a PES packet with the timestamps has them, and the timestamps of the others
+// are extrapolated by the durations of the audio frames or by the interval of
+// the video frames. The PTS of such video access units is empty, since it
+// depends on the reordering, and so are the timestamps not known yet.
+func (e *ESExtractor) Timestamps(w io.Writer) {
+	e.timestamps = w
+}
+
+// Extract reads the transport stream from r and writes the elementary stream
+// to w. It returns ErrNoStream if no PES packet of the stream is found.
+func (e *ESExtractor) Extract(w io.Writer, r io.Reader) error {
+	pid, streamType := e.pid, e.streamType
+	d := NewDemuxer()
+	d.HandleProgram(func(pg *Program) error {
+		for _, st := range pg.Streams {
+			if pid == PidNull && st.StreamType == e.streamType {
+				pid = st.PID
+			}
+			if st.PID == pid {
+				streamType = st.StreamType
+			}
+		}
+		return nil
+	})
+
+	found := false
+	var t *auTimestamper
+	emit := func(pes PES) error {
+		found = true
+		payload := pes.Payload()
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+		if e.timestamps == nil {
+			return nil
+		}
+		if t == nil {
+			t = newAUTimestamper(e.timestamps, streamType)
+		}
+		return t.write(payload, pes)
+	}
+
+	s := NewPacketScanner(r)
+	c := newContinuity()
+	pb := &pesBuffer{}
+	defer pb.drop()
+	var n int64
+	for s.Scan() {
+		p := Packet(s.Bytes())
+		n++
+		// the stream_type of the PID is needed only for the timestamps
+		if pid == PidNull || e.timestamps != nil && streamType == 0 && p.PID() != pid {
+			if err := d.WritePacket(p); err != nil {
+				return err
+			}
+			continue
+		}
+		if p.PID() != pid {
+			continue
+		}
+		ok, drop, err := inspect(p, n-1, &c, nil)
+		if err != nil {
+			return err
+		}
+		if drop {
+			pb.drop()
+		}
+		if !ok {
+			continue
+		}
+		if err := pb.depacketize(p.Payload(), p.IsPayloadUnitStart(), emit); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if err := pb.flush(emit); err != nil {
+		return err
+	}
+	if !found {
+		return ErrNoStream
+	}
+	if t != nil {
+		return t.flush()
+	}
+	return nil
+}
+
+// pesTimestamps is the timestamps of a PES packet and the range of its
+// payload in the elementary stream.
+type pesTimestamps struct {
+	start, end int64
+	pts, dts   int64
+}
+
+// auTimestamper splits the elementary stream into the access units and writes
+// their timestamps.
+type auTimestamper struct {
+	w          io.Writer
+	streamType byte
+	ar         *accessUnitReader // classifies the NAL units
+	buf        []byte            // elementary stream not split yet
+	base       int64             // offset of buf in the elementary stream
+	start      int               // start of the access unit in progress in buf
+	scan       int               // position in buf to search the start code from
+	vcl        bool              // whether the access unit in progress has a VCL NAL unit
+	pes        []pesTimestamps   // timestamps of the PES packets not used yet
+	pts, dts   int64             // timestamps of the last access unit, or -1
+	step       int64             // DTS interval to the next access unit, or 0
+	stamped    int64             // DTS of the last access unit with the PES timestamps, or -1
+	count      int64             // number of the access units since stamped
+}
+
+func newAUTimestamper(w io.Writer, streamType byte) *auTimestamper {
+	return &auTimestamper{
+		w:          w,
+		streamType: streamType,
+		ar:         &accessUnitReader{streamType: streamType},
+		pts:        -1,
+		dts:        -1,
+		stamped:    -1,
+	}
+}
+
+// write adds the payload of the PES packet to the elementary stream.
+func (t *auTimestamper) write(payload []byte, pes PES) error {
+	off := t.base + int64(len(t.buf))
+	if pes.HasPTS() {
+		t.pes = append(t.pes, pesTimestamps{off, off + int64(len(payload)), pes.PTS(), pes.DTS()})
+	}
+	switch t.streamType {
+	case StreamTypeAAC, StreamTypeH264, StreamTypeHEVC:
+		t.buf = append(t.buf, payload...)
+		return t.split(false)
+	}
+	return t.emit(off, len(payload), 0)
+}
+
+// flush writes the timestamps of the last access unit.
+func (t *auTimestamper) flush() error {
+	switch t.streamType {
+	case StreamTypeAAC, StreamTypeH264, StreamTypeHEVC:
+		return t.split(true)
+	}
+	return nil
+}
+
+// split writes the timestamps of the access units completed in buf. The last
+// one is completed at the end of the stream, except a truncated ADTS frame.
+func (t *auTimestamper) split(eos bool) error {
+	if t.streamType == StreamTypeAAC {
+		for len(t.buf)-t.start >= 7 {
+			size, rate, samples, err := parseADTSHeader(t.buf[t.start:])
+			if err != nil {
+				// resynchronize to the next syncword
+				if i := bytes.IndexByte(t.buf[t.start+1:], 0xFF); i >= 0 {
+					t.start += 1 + i
+				} else {
+					t.start = len(t.buf)
+				}
+				continue
+			}
+			if len(t.buf)-t.start < size {
+				break
+			}
+			if err := t.emit(t.base+int64(t.start), size, int64(samples)*90000/int64(rate)); err != nil {
+				return err
+			}
+			t.start += size
+		}
+	} else {
+		for {
+			i := bytes.Index(t.buf[t.scan:], startCode)
+			if i < 0 {
+				if n := len(t.buf) - len(startCode) + 1; n > t.scan {
+					t.scan = n
+				}
+				break
+			}
+			nal := t.scan + i + len(startCode)
+			if len(t.buf)-nal < 3 && !eos {
+				t.scan += i // wait for the NAL unit header
+				break
+			}
+			vcl, first := t.ar.classify(t.buf[nal:])
+			if t.vcl && first {
+				end := t.scan + i
+				if end > t.start && t.buf[end-1] == 0x00 { // zero_byte
+					end--
+				}
+				if err := t.emit(t.base+int64(t.start), end-t.start, 0); err != nil {
+					return err
+				}
+				t.start, t.vcl = end, false
+			}
+			t.vcl = t.vcl || vcl
+			t.scan = nal
+		}
+		if eos && len(t.buf) > t.start {
+			if err := t.emit(t.base+int64(t.start), len(t.buf)-t.start, 0); err != nil {
+				return err
+			}
+			t.start = len(t.buf)
+		}
+	}
+	n := copy(t.buf, t.buf[t.start:])
+	t.buf = t.buf[:n]
+	t.base += int64(t.start)
+	t.scan -= t.start
+	if t.scan < 0 {
+		t.scan = 0
+	}
+	t.start = 0
+	return nil
+}
+
+// emit writes the timestamps of the access unit at the offset. The duration
+// is that of an audio frame, or 0 if it is not known.
+func (t *auTimestamper) emit(offset int64, size int, duration int64) error {
+	pts, dts := int64(-1), int64(-1)
+	if p, ok := t.take(offset); ok {
+		pts, dts = p.pts, p.dts
+		if duration == 0 && t.stamped >= 0 {
+			t.step = (dts - t.stamped + timestampWrap) % timestampWrap / t.count
+		}
+		t.stamped, t.count = dts, 0
+	} else if t.dts >= 0 && t.step > 0 {
+		dts = (t.dts + t.step) % timestampWrap
+		if duration > 0 {
+			pts = dts
+		}
+	}
+	t.pts, t.dts = pts, dts
+	t.count++
+	if duration > 0 {
+		t.step = duration
+	}
+
+	var ptsField, dtsField string
+	if pts >= 0 {
+		ptsField = fmt.Sprint(pts)
+	}
+	if dts >= 0 {
+		dtsField = fmt.Sprint(dts)
+	}
+	_, err := fmt.Fprintf(t.w, "%d\t%d\t%s\t%s\n", offset, size, ptsField, dtsField)
+	return err
+}
+
+// take returns the timestamps of the PES packet in which the access unit at
+// the offset starts, if they are not used by another access unit.
+func (t *auTimestamper) take(offset int64) (pesTimestamps, bool) {
+	for len(t.pes) > 0 && t.pes[0].end <= offset {
+		t.pes = t.pes[1:]
+	}
+	if len(t.pes) == 0 || t.pes[0].start > offset {
+		return pesTimestamps{}, false
+	}
+	p := t.pes[0]
+	t.pes = t.pes[1:]
+	return p, true
+}
@@ -0,0 +1,123 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestESExtractor(t *testing.T) {
+	var video []byte
+	for i := 0; i < 3; i++ {
+		video = append(video, 0x00, 0x00, 0x00, 0x01, 0x65, 0x88)
+		video = append(video, bytes.Repeat([]byte{byte(i + 1)}, 400)...)
+	}
+	var audio []byte
+	for i := 0; i < 4; i++ {
+		audio = append(audio, makeTestADTS(3, 200)...)
+	}
+	m := NewESMuxer(0x0001, 1)
+	if _, err := m.AddVideo(bytes.NewReader(video), StreamTypeH264, 25, 1); err != nil {
+		t.Fatal(err)
+	}
+	apid := m.AddAudio(bytes.NewReader(audio), "")
+	var stream bytes.Buffer
+	if err := m.Mux(&stream); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		e          *ESExtractor
+		want       []byte
+		timestamps string
+	}{
+		{
+			NewESExtractorByStreamType(StreamTypeH264), video,
+			"0\t406\t45000\t45000\n406\t406\t48600\t48600\n812\t406\t52200\t52200\n",
+		},
+		{
+			NewESExtractor(apid), audio,
+			"0\t200\t45000\t45000\n200\t200\t46920\t46920\n400\t200\t48840\t48840\n600\t200\t50760\t50760\n",
+		},
+	} {
+		var out bytes.Buffer
+		var ts strings.Builder
+		tc.e.Timestamps(&ts)
+		if err := tc.e.Extract(&out, bytes.NewReader(stream.Bytes())); err != nil {
+			t.Fatalf("%0d: Extract() causes %s", i, err)
+		}
+		if !bytes.Equal(out.Bytes(), tc.want) {
+			t.Errorf("%0d: Extract() outputs %d bytes, want %d bytes", i, out.Len(), len(tc.want))
+		}
+		if ts.String() != tc.timestamps {
+			t.Errorf("%0d: timestamps => %q, want %q", i, ts.String(), tc.timestamps)
+		}
+	}
+
+	for i, e := range []*ESExtractor{NewESExtractorByStreamType(StreamTypeHEVC), NewESExtractor(0x0200)} {
+		if err := e.Extract(&bytes.Buffer{}, bytes.NewReader(stream.Bytes())); err != ErrNoStream {
+			t.Errorf("%0d: Extract() causes %v, want %s", i, err, ErrNoStream)
+		}
+	}
+}
+
+func TestESExtractorAccessUnits(t *testing.T) {
+	au := func(nal ...byte) []byte {
+		return append(append([]byte{0x00, 0x00, 0x00, 0x01}, nal...), make([]byte, 50)...)
+	}
+	frame := makeTestADTS(3, 100)
+
+	pat := BuildPAT(0x7FE5, 0, map[ProgramNumber]PID{1: 0x1000})
+	pmt := buildSection(0x02, 1, 0, []byte{
+		0xE1, 0x00, 0xF0, 0x00, // PCR_PID, program_info_length
+		StreamTypeH264, 0xE1, 0x00, 0xF0, 0x00,
+		StreamTypeAAC, 0xE1, 0x01, 0xF0, 0x00,
+	})
+	var stream []byte
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, pat...)))
+	stream = concatPacket(stream, makeTSPacket(0x1000, 0, true, append([]byte{0x00}, pmt...)))
+	// two access units in a PES packet, and one in a PES packet without the timestamps
+	stream = concatPacket(stream, makeTSPacket(0x100, 0, true, BuildPES(0xE0, 9000, 6000, append(au(0x65, 0x88), au(0x41, 0x9A)...))))
+	stream = concatPacket(stream, makeTSPacket(0x100, 1, true, BuildPES(0xE0, 18000, 12000, au(0x41, 0x9A))))
+	stream = concatPacket(stream, makeTSPacket(0x100, 2, true, BuildPES(0xE0, -1, -1, au(0x41, 0x9A))))
+	// two ADTS frames in a PES packet
+	stream = concatPacket(stream, packetizeTestPES(0x101, 0, BuildPES(0xC0, 9000, 9000, append(frame, frame...))))
+	stream = concatPacket(stream, makeTSPacket(0x101, 2, true, BuildPES(0xC0, 20000, 20000, frame)))
+
+	for i, tc := range []struct {
+		e    *ESExtractor
+		want string
+	}{
+		{
+			NewESExtractor(0x100),
+			"0\t56\t9000\t6000\n56\t56\t\t\n112\t56\t18000\t12000\n168\t56\t\t15000\n",
+		},
+		{
+			NewESExtractorByStreamType(StreamTypeAAC),
+			"0\t100\t9000\t9000\n100\t100\t10920\t10920\n200\t100\t20000\t20000\n",
+		},
+	} {
+		var ts strings.Builder
+		tc.e.Timestamps(&ts)
+		if err := tc.e.Extract(&bytes.Buffer{}, bytes.NewReader(stream)); err != nil {
+			t.Fatalf("%0d: Extract() causes %s", i, err)
+		}
+		if ts.String() != tc.want {
+			t.Errorf("%0d: timestamps => %q, want %q", i, ts.String(), tc.want)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+var (
+	// ErrUnsupportedStreamType is returned when the stream_type is not
+	// supported.
+	ErrUnsupportedStreamType = errors.New("ts: unsupported stream_type")
+
+	// ErrInvalidFrameRate is returned when the frame rate is not positive.
+	ErrInvalidFrameRate = errors.New("ts: invalid frame rate")
+
+	// ErrReorderDepth is returned when the pictures of a video stream are
+	// reordered deeper than in the first access units.
+	ErrReorderDepth = errors.New("ts: reorder depth exceeded")
+)
+
+const (
+	esMuxPMTPID      = 0x1000
+	esMuxFirstPID    = 0x0100
+	esMuxDelay       = TimestampFrequency / 2  // PTS - PCR at the first access unit
+	esMuxPSIInterval = TimestampFrequency / 10 // interval of the PAT and the PMT
+	esMuxPCRInterval = TimestampFrequency / 25 // maximum interval of the PCRs
+	esMuxReorder     = 16                      // access units to look ahead for the output order
+)
+
+// ESMuxer builds a single program transport stream from the raw elementary
+// streams: H.264 or HEVC in the Annex B byte stream format and AAC in ADTS.
+//
+// The program has the program_map_PID of 0x1000 and the elementary streams
+// have the PIDs from 0x0100 in order added. The PCR is carried by the first
+// video stream, or by the first stream if no video is added, and the packets
+// of the adaptation field only are inserted to send it every 40 ms at least.
+//
+// The access units are sent in order of the DTS, each in a PES packet. The
+// timestamps are generated from the frame rate for the video and from the
+// number of the samples for the audio. The pictures are presented in order of
+// the picture order count, and the DTS of the video is earlier than the PTS
+// by the reorder depth measured in the first 16 access units. The PTSs of
+// the other streams are delayed as much to keep them synchronized, and Mux
+// returns ErrReorderDepth if the pictures are reordered deeper later.
+type ESMuxer struct {
+	tsid    TransportStreamID
+	number  ProgramNumber
+	streams []*esStream
+}
+
+// NewESMuxer returns a new ESMuxer of the program.
+func NewESMuxer(tsid TransportStreamID, number ProgramNumber) *ESMuxer {
+	return &ESMuxer{tsid: tsid, number: number}
+}
+
+// esStream is an elementary stream of the ESMuxer.
+type esStream struct {
+	pid        PID
+	streamType byte
+	streamID   byte
+	pz         *Packetizer
+	language   string
+
+	video   *accessUnitReader
+	num     int64 // frame rate num/den
+	den     int64
+	frames  int64
+	ahead   []esAccessUnit // access units read ahead in the decoding order
+	sent    []int64        // output orders of the last access units
+	depth   int64          // reorder depth in frames, or -1 if not measured
+	eos     bool           // whether video reaches the end
+	audio   *adtsReader
+	samples int64
+
+	au    []byte // next access unit, nil at the end
+	dts   int64  // DTS of au
+	pts   int64  // PTS of au
+	delay int64  // PTS - DTS by the reorder depth
+}
+
+// esAccessUnit is an access unit of video and its output order.
+type esAccessUnit struct {
+	au    []byte
+	order int64
+}
+
+// AddVideo adds the video stream of the stream_type, StreamTypeH264 or
+// StreamTypeHEVC, whose frame rate is num/den, e.g. 30000/1001.
+func (m *ESMuxer) AddVideo(r io.Reader, streamType byte, num, den int) (PID, error) {
+	if streamType != StreamTypeH264 && streamType != StreamTypeHEVC {
+		return 0, ErrUnsupportedStreamType
+	}
+	if num <= 0 || den <= 0 {
+		return 0, ErrInvalidFrameRate
+	}
+	st := m.add(streamType, 0xE0+byte(m.count(true)))
+	st.video = newAccessUnitReader(r, streamType)
+	st.num, st.den = int64(num), int64(den)
+	st.depth = -1
+	return st.pid, nil
+}
+
+// AddAudio adds the AAC audio stream in ADTS. The language is the ISO 639-2
+// code of the ISO_639_language_descriptor, or empty to omit it.
+func (m *ESMuxer) AddAudio(r io.Reader, language string) PID {
+	st := m.add(StreamTypeAAC, 0xC0+byte(m.count(false)))
+	st.audio = &adtsReader{r: bufio.NewReader(r)}
+	st.language = language
+	return st.pid
+}
+
+func (m *ESMuxer) add(streamType, streamID byte) *esStream {
+	pid := esMuxFirstPID + PID(len(m.streams))
+	st := &esStream{pid: pid, streamType: streamType, streamID: streamID, pz: NewPacketizer(pid)}
+	m.streams = append(m.streams, st)
+	return st
+}
+
+// count returns the number of the video or audio streams.
+func (m *ESMuxer) count(video bool) int {
+	n := 0
+	for _, st := range m.streams {
+		if (st.video != nil) == video {
+			n++
+		}
+	}
+	return n
+}
+
+// Mux writes the transport stream to w until all the elementary streams end.
+func (m *ESMuxer) Mux(w io.Writer) error {
+	if len(m.streams) == 0 {
+		return nil
+	}
+	pcr := m.streams[0]
+	shift := int64(0) // delay of the PTSs by the reorder depth
+	for _, st := range m.streams {
+		if err := st.read(); err != nil {
+			return err
+		}
+		if st.video != nil && pcr.video == nil {
+			pcr = st
+		}
+		if st.delay > shift {
+			shift = st.delay
+		}
+	}
+
+	var elements []ProgramElementInfo
+	for _, st := range m.streams {
+		elements = append(elements, BuildProgramElementInfo(st.streamType, st.pid, st.descriptors()...))
+	}
+	pat := BuildPAT(m.tsid, 0, map[ProgramNumber]PID{m.number: esMuxPMTPID})
+	pmt := BuildPMT(m.number, 0, pcr.pid, nil, elements...)
+	patpz := NewPacketizer(PidPAT)
+	pmtpz := NewPacketizer(esMuxPMTPID)
+
+	next := int64(0)  // DTS to send the PSI
+	last := int64(-1) // DTS of the last PCR
+	for {
+		var st *esStream
+		for _, s := range m.streams {
+			if s.au != nil && (st == nil || s.dts < st.dts) {
+				st = s
+			}
+		}
+		if st == nil {
+			return nil
+		}
+
+		var packets []Packet
+		if st.dts >= next {
+			packets = append(packets, patpz.Section(pat)...)
+			packets = append(packets, pmtpz.Section(pmt)...)
+			for st.dts >= next {
+				next += esMuxPSIInterval
+			}
+		}
+		// the PCRs in the interval before the access unit
+		if last < 0 && st != pcr {
+			last = st.dts
+			packets = append(packets, pcrPacket(pcr.pid, pcr.pz.cc-1, 0, last*300))
+		}
+		for last >= 0 && st.dts-last > esMuxPCRInterval {
+			last += esMuxPCRInterval
+			packets = append(packets, pcrPacket(pcr.pid, pcr.pz.cc-1, 0, last*300))
+		}
+		clock := int64(-1)
+		if st == pcr {
+			clock = st.dts * 300
+			last = st.dts
+		}
+		pts := st.pts - st.delay + shift + esMuxDelay
+		dts := st.dts + esMuxDelay
+		if st.video == nil {
+			dts = pts
+		}
+		pes := BuildPES(st.streamID, pts, dts, st.au)
+		packets = append(packets, st.pz.PES(pes, clock, isRandomAccess(st.streamType, st.au))...)
+		for _, p := range packets {
+			if _, err := w.Write(p); err != nil {
+				return err
+			}
+		}
+		if err := st.read(); err != nil {
+			return err
+		}
+	}
+}
+
+// read reads the next access unit and its timestamps.
+func (st *esStream) read() error {
+	if st.video != nil {
+		for !st.eos && len(st.ahead) <= esMuxReorder {
+			au, err := st.video.next()
+			if err == io.EOF {
+				st.eos = true
+				break
+			}
+			if err != nil {
+				return err
+			}
+			st.ahead = append(st.ahead, esAccessUnit{au, st.video.pictureOrder(au)})
+		}
+		if len(st.ahead) == 0 {
+			st.au = nil
+			return nil
+		}
+		if st.depth < 0 {
+			st.depth = 0
+			for i, a := range st.ahead {
+				var n int64
+				for _, b := range st.ahead[:i] {
+					if b.order > a.order {
+						n++
+					}
+				}
+				if n > st.depth {
+					st.depth = n
+				}
+			}
+			st.delay = st.depth * TimestampFrequency * st.den / st.num
+		}
+
+		a := st.ahead[0]
+		st.ahead = st.ahead[1:]
+		// index in the output order
+		output := st.frames
+		for _, order := range st.sent {
+			if order > a.order {
+				output--
+			}
+		}
+		for _, b := range st.ahead {
+			if b.order < a.order {
+				output++
+			}
+		}
+		if output+st.depth < st.frames {
+			return ErrReorderDepth
+		}
+		st.sent = append(st.sent, a.order)
+		if len(st.sent) > esMuxReorder {
+			st.sent = st.sent[1:]
+		}
+		st.au = a.au
+		st.dts = st.frames * TimestampFrequency * st.den / st.num
+		st.pts = (output + st.depth) * TimestampFrequency * st.den / st.num
+		st.frames++
+		return nil
+	}
+
+	frame, rate, samples, err := st.audio.next()
+	if err == io.EOF {
+		st.au = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	st.au = frame
+	st.dts = st.samples * TimestampFrequency / int64(rate)
+	st.pts = st.dts
+	st.samples += int64(samples)
+	return nil
+}
+
+// descriptors returns the descriptors of the elementary stream. The AVC video
+// descriptor is made of the SPS in the first access unit.
+func (st *esStream) descriptors() []Descriptor {
+	var ds []Descriptor
+	switch st.streamType {
+	case StreamTypeH264:
+		for _, nal := range nalUnits(st.au) {
+			if len(nal) >= 4 && nal[0]&0x1F == 7 { // SPS
+				// profile_idc, constraint_set flags, level_idc,
+				// AVC_still_present, AVC_24_hour_picture_flag,
+				// frame_packing_SEI_not_present_flag
+				ds = append(ds, BuildDescriptor(TagAVCVideo, []byte{nal[1], nal[2], nal[3], 0x3F}))
+				break
+			}
+		}
+	case StreamTypeHEVC:
+		ds = append(ds, BuildDescriptor(TagRegistration, []byte("HEVC")))
+	}
+	if len(st.language) == 3 {
+		ds = append(ds, BuildDescriptor(TagISO639Language, append([]byte(st.language), 0x00)))
+	}
+	return ds
+}
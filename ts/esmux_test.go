@@ -0,0 +1,283 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestESMuxer(t *testing.T) {
+	var video []byte
+	for i := 0; i < 6; i++ {
+		if i == 0 {
+			video = append(video, 0x00, 0x00, 0x00, 0x01, 0x67, 0x64, 0x00, 0x28)
+			video = append(video, 0x00, 0x00, 0x00, 0x01, 0x68, 0xEE)
+			video = append(video, 0x00, 0x00, 0x00, 0x01, 0x65, 0x88)
+		} else {
+			video = append(video, 0x00, 0x00, 0x00, 0x01, 0x41, 0x9A)
+		}
+		video = append(video, bytes.Repeat([]byte{0x11}, 500)...)
+	}
+	var audio []byte
+	for i := 0; i < 10; i++ {
+		audio = append(audio, makeTestADTS(3, 300)...)
+	}
+
+	m := NewESMuxer(0x0001, 1)
+	if _, err := m.AddVideo(bytes.NewReader(video), StreamTypeMPEG2Video, 25, 1); err != ErrUnsupportedStreamType {
+		t.Errorf("AddVideo() causes %v, want %s", err, ErrUnsupportedStreamType)
+	}
+	vpid, err := m.AddVideo(bytes.NewReader(video), StreamTypeH264, 25, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apid := m.AddAudio(bytes.NewReader(audio), "jpn")
+	if vpid != 0x0100 || apid != 0x0101 {
+		t.Errorf("PIDs => 0x%04X, 0x%04X, want 0x0100, 0x0101", vpid, apid)
+	}
+	var out bytes.Buffer
+	if err := m.Mux(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDemuxer()
+	var events []Event
+	d.Observe(func(e Event) {
+		events = append(events, e)
+	})
+	pts := make(map[PID][]int64)
+	sizes := make(map[PID][]int)
+	for _, pid := range []PID{vpid, apid} {
+		d.HandlePES(pid, func(pid PID, pes PES) error {
+			pts[pid] = append(pts[pid], pes.PTS())
+			sizes[pid] = append(sizes[pid], len(pes.Payload()))
+			return nil
+		})
+	}
+	var pcrs []int64
+	var rai int
+	d.HandlePacket(vpid, func(p Packet) error {
+		if af, _ := p.AdaptationField(); af != nil {
+			if af.HasPCR() {
+				pcrs = append(pcrs, af.PCR().Value())
+			}
+			if af.RandomAccessIndicator() == 1 {
+				rai++
+			}
+		}
+		return nil
+	})
+	if _, err := d.Write(out.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Mux() causes events %v", events)
+	}
+
+	pg, ok := d.Program(1)
+	if !ok || pg.PMT == nil {
+		t.Fatal("Mux() outputs no program 1")
+	}
+	if pg.PID != 0x1000 || pg.PCRPID() != vpid {
+		t.Errorf("program => PMT 0x%04X, PCR 0x%04X, want 0x1000, 0x%04X", pg.PID, pg.PCRPID(), vpid)
+	}
+	for i, tc := range []struct {
+		streamType byte
+		tag        DescriptorTag
+	}{
+		{StreamTypeH264, TagAVCVideo},
+		{StreamTypeAAC, TagISO639Language},
+	} {
+		st := pg.Streams[i]
+		ds := st.Info.Descriptors()
+		if st.StreamType != tc.streamType || len(ds) != 1 || ds[0].Tag() != tc.tag {
+			t.Errorf("%0d: stream => 0x%02X %v, want 0x%02X with tag 0x%02X", i, st.StreamType, ds, tc.streamType, tc.tag)
+		}
+	}
+
+	var vpts, apts []int64
+	for i := int64(0); i < 6; i++ {
+		vpts = append(vpts, 45000+i*3600)
+	}
+	for i := int64(0); i < 10; i++ {
+		apts = append(apts, 45000+i*1920)
+	}
+	if !reflect.DeepEqual(pts[vpid], vpts) {
+		t.Errorf("video PTS => %v, want %v", pts[vpid], vpts)
+	}
+	if !reflect.DeepEqual(pts[apid], apts) {
+		t.Errorf("audio PTS => %v, want %v", pts[apid], apts)
+	}
+	if want := []int{520, 506, 506, 506, 506, 506}; !reflect.DeepEqual(sizes[vpid], want) {
+		t.Errorf("video access units => %v bytes, want %v", sizes[vpid], want)
+	}
+	if len(pcrs) != 6 || pcrs[1] != 3600*300 {
+		t.Errorf("PCRs => %v, want 6 from 0 by %d", pcrs, 3600*300)
+	}
+	if rai != 1 {
+		t.Errorf("random_access_indicators => %d, want 1", rai)
+	}
+}
+
+func TestESMuxerPCRInterval(t *testing.T) {
+	var video []byte
+	for i := 0; i < 5; i++ {
+		video = append(video, 0x00, 0x00, 0x00, 0x01, 0x41, 0x9A)
+		video = append(video, bytes.Repeat([]byte{0x11}, 300)...)
+	}
+	m := NewESMuxer(0x0001, 1)
+	vpid, err := m.AddVideo(bytes.NewReader(video), StreamTypeH264, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := m.Mux(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDemuxer()
+	var events []Event
+	d.Observe(func(e Event) {
+		events = append(events, e)
+	})
+	var pcrs []int64
+	d.HandlePacket(vpid, func(p Packet) error {
+		if af, _ := p.AdaptationField(); af != nil && af.HasPCR() {
+			pcrs = append(pcrs, af.PCR().Value())
+		}
+		return nil
+	})
+	if _, err := d.Write(out.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Mux() causes events %v", events)
+	}
+	if len(pcrs) != 13 {
+		t.Errorf("PCRs => %v, want 13", pcrs)
+	}
+	for i := 1; i < len(pcrs); i++ {
+		if d := pcrs[i] - pcrs[i-1]; d <= 0 || d > 3600*300 {
+			t.Errorf("%0d: PCR increases by %d, want up to %d", i, d, 3600*300)
+		}
+	}
+}
+
+// makeTestNAL returns the NAL unit with the start code of the header and the
+// RBSP of the bits written in '0' and '1'.
+func makeTestNAL(header []byte, bits string) []byte {
+	bits += "1" // rbsp_stop_one_bit
+	rbsp := make([]byte, (len(bits)+7)/8)
+	for i, c := range bits {
+		if c == '1' {
+			rbsp[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	nal := append([]byte{0x00, 0x00, 0x00, 0x01}, header...)
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 0x03 {
+			nal = append(nal, 0x03) // emulation_prevention_three_byte
+			zeros = 0
+		}
+		if b == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		nal = append(nal, b)
+	}
+	return nal
+}
+
+func TestESMuxerBFrames(t *testing.T) {
+	// IDR picture and the pictures of the POC 6, 2, 4, 12, 8, 10 in 4 bits
+	lsbs := []string{"0110", "0010", "0100", "1100", "1000", "1010"}
+	// profile_idc 66, sps_id 0, log2_max_frame_num 4, pic_order_cnt_type 0,
+	// log2_max_pic_order_cnt_lsb 4, max_num_ref_frames 1, frame_mbs_only_flag
+	h264 := makeTestNAL([]byte{0x67, 0x42, 0x00, 0x1E}, "1111010011")
+	h264 = append(h264, makeTestNAL([]byte{0x68}, "1100")...)
+	h264 = append(h264, makeTestNAL([]byte{0x65}, "1"+"0001000"+"1"+"0000"+"1"+"0000")...)
+	for i, lsb := range lsbs {
+		header, sliceType := []byte{0x41}, "00110" // P slice
+		if i%3 != 0 {
+			header, sliceType = []byte{0x01}, "00111" // B slice of a non-reference picture
+		}
+		h264 = append(h264, makeTestNAL(header, "1"+sliceType+"1"+"0001"+lsb)...)
+	}
+	// sps_max_sub_layers_minus1 0, general profile, tier and level of 0,
+	// chroma_format_idc 1, log2_max_pic_order_cnt_lsb 4
+	hevc := makeTestNAL([]byte{0x42, 0x01}, "00000001"+strings.Repeat("0", 96)+"1"+"010"+"11"+"0"+"11"+"1")
+	hevc = append(hevc, makeTestNAL([]byte{0x44, 0x01}, "11"+"0"+"0"+"000")...)
+	hevc = append(hevc, makeTestNAL([]byte{0x26, 0x01}, "1"+"0"+"1"+"011")...) // IDR_W_RADL
+	for i, lsb := range lsbs {
+		sliceType := "010" // P slice
+		if i%3 != 0 {
+			sliceType = "1" // B slice
+		}
+		hevc = append(hevc, makeTestNAL([]byte{0x02, 0x01}, "1"+"1"+sliceType+lsb)...) // TRAIL_R
+	}
+
+	// presented in order of I, B, B, P, B, B, P by a frame after decoded
+	var want [][2]int64
+	for _, output := range []int64{0, 3, 1, 2, 6, 4, 5} {
+		want = append(want, [2]int64{45000 + (output+1)*3600, 45000 + int64(len(want))*3600})
+	}
+	for i, tc := range []struct {
+		streamType byte
+		video      []byte
+	}{
+		{StreamTypeH264, h264},
+		{StreamTypeHEVC, hevc},
+	} {
+		m := NewESMuxer(0x0001, 1)
+		vpid, err := m.AddVideo(bytes.NewReader(tc.video), tc.streamType, 25, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := m.Mux(&out); err != nil {
+			t.Fatalf("%0d: Mux() causes %s", i, err)
+		}
+		d := NewDemuxer()
+		var got [][2]int64
+		d.HandlePES(vpid, func(pid PID, pes PES) error {
+			got = append(got, [2]int64{pes.PTS(), pes.DTS()})
+			return nil
+		})
+		if _, err := d.Write(out.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%0d: timestamps => %v, want %v", i, got, want)
+		}
+	}
+
+	m := NewESMuxer(0x0001, 1)
+	for i, rate := range [][2]int{{0, 1}, {25, 0}, {-25, 1}} {
+		if _, err := m.AddVideo(&bytes.Buffer{}, StreamTypeH264, rate[0], rate[1]); err != ErrInvalidFrameRate {
+			t.Errorf("%0d: AddVideo() causes %v, want %s", i, err, ErrInvalidFrameRate)
+		}
+	}
+}
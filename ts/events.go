@@ -0,0 +1,108 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "time"
+
+// EventType is a type of the Event.
+type EventType int
+
+// Types of the Event.
+const (
+	EventContinuityError      EventType = iota + 1 // continuity_counter is not the expected one
+	EventDuplicatePacket                           // packet is sent twice
+	EventTransportError                            // transport_error_indicator is set
+	EventDiscontinuity                             // discontinuity_indicator is set
+	EventTruncatedSection                          // section ends before section_length
+	EventAdaptationFieldError                      // adaptation_field_length exceeds the packet
+
+	// ETSI TR 101 290 priority 1 errors found by the Monitor
+	EventSyncLoss      // TS_sync_loss
+	EventSyncByteError // Sync_byte_error
+	EventPATError      // PAT_error
+	EventPMTError      // PMT_error
+	EventPIDError      // PID_error
+
+	// ETSI TR 101 290 priority 2 and 3 errors found by the Monitor, and
+	// EventTransportError for Transport_error
+	EventCRCError              // CRC_error
+	EventPCRRepetitionError    // PCR_repetition_error
+	EventPCRDiscontinuityError // PCR_discontinuity_indicator_error
+	EventPCRAccuracyError      // PCR_accuracy_error
+	EventPTSError              // PTS_error
+	EventCATError              // CAT_error
+	EventNITError              // NIT_actual_error
+	EventSDTError              // SDT_actual_error
+	EventEITError              // EIT_actual_error
+	EventTDTError              // TDT_error
+
+	// T-STD errors found by the TSTD
+	EventTSTDOverflow  // buffer overflows
+	EventTSTDUnderflow // access unit is not in the buffer at its DTS
+)
+
+// Event is an irregularity found in the stream.
+type Event struct {
+	Type     EventType
+	PID      PID
+	Packet   int64 // index of the packet in the stream
+	Expected int   // expected continuity_counter for EventContinuityError
+	Actual   int   // continuity_counter for EventContinuityError and EventDuplicatePacket
+
+	Time   time.Duration // time of the packet in the stream by the Monitor and the TSTD
+	Detail string        // description of the error by the Monitor, or the buffer by the TSTD
+}
+
+// Observer observes the events.
+type Observer func(e Event)
+
+// inspect checks the adaptation field and the continuity_counter of the
+// packet, and emits the events to o. It reports whether the payload of the
+// packet should be processed, and whether the data buffered from the previous
+// packets should be dropped.
+func inspect(p Packet, n int64, c *continuity, o Observer) (ok, drop bool, err error) {
+	pid := p.PID()
+
+	// reset cc by discontinuity indicator
+	af, err := p.AdaptationField()
+	if err != nil {
+		return false, false, err
+	}
+	if af != nil && af.IsDiscontinuous() {
+		c.cc = -1
+		o.observe(Event{Type: EventDiscontinuity, PID: pid, Packet: n})
+	}
+
+	// continuity_counter is not incremented without payload
+	if !p.HasPayload() {
+		return false, false, nil
+	}
+
+	cc := int(p.ContinuityCounter())
+	st, drop, exp := c.check(pid, cc)
+	switch st {
+	case ccDuplicate:
+		o.observe(Event{Type: EventDuplicatePacket, PID: pid, Packet: n, Actual: cc})
+	case ccError:
+		o.observe(Event{Type: EventContinuityError, PID: pid, Packet: n, Expected: exp, Actual: cc})
+	}
+	return st == ccOK, drop, nil
+}
+
+func (o Observer) observe(e Event) {
+	if o != nil {
+		o(e)
+	}
+}
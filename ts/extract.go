@@ -0,0 +1,208 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "io"
+
+// Extractor extracts a program of a multi program transport stream(MPTS)
+// into a single program transport stream(SPTS).
+//
+// The output contains the packets of the PMT, the elementary streams, the PCR
+// and the ECMs of the program as they are, and the PAT rewritten to have the
+// program only. The version_number of the PAT is incremented from the
+// original one so that the receivers notice the change.
+type Extractor struct {
+	number ProgramNumber
+	si     bool
+}
+
+// NewExtractor returns a new Extractor of the program.
+func NewExtractor(number ProgramNumber) *Extractor {
+	return &Extractor{number: number}
+}
+
+// KeepSI sets whether to keep the DVB SI. If keep is true, the NIT and the
+// TDT/TOT are kept as they are, and the SDT and the EIT of the actual
+// transport stream are filtered to the service of the program.
+func (e *Extractor) KeepSI(keep bool) {
+	e.si = keep
+}
+
+// extraction is the state of an extraction.
+type extraction struct {
+	w      io.Writer
+	d      *Demuxer
+	number ProgramNumber
+	si     bool
+	found  bool
+
+	pmtPID PID
+	pmts   map[PID]bool // PMT PIDs subscribed
+	pass   map[PID]bool // PIDs output as they are
+	nitPID PID
+
+	pat *Packetizer
+	pmt *Packetizer
+	sdt *Packetizer
+	eit *Packetizer
+}
+
+// Extract reads the stream from r and writes the program to w. It returns
+// ErrNoProgram if the program is not found in the stream.
+func (e *Extractor) Extract(w io.Writer, r io.Reader) error {
+	x := &extraction{
+		w:      w,
+		d:      NewDemuxer(),
+		number: e.number,
+		si:     e.si,
+		pmtPID: PidNull,
+		pmts:   make(map[PID]bool),
+		pass:   make(map[PID]bool),
+		nitPID: PidNIT,
+		pat:    NewPacketizer(PidPAT),
+		sdt:    NewPacketizer(PidSDT),
+		eit:    NewPacketizer(PidEIT),
+	}
+	x.d.HandleSection(PidPAT, x.handlePAT, TableIDFilter(0x00), CurrentOnlyFilter())
+	x.d.HandleProgram(x.handleProgram)
+	if x.si {
+		x.d.HandleSection(PidSDT, x.handleSDT, TableIDFilter(TableIDSDTActual))
+		x.d.HandleSection(PidEIT, x.handleEIT,
+			TableIDExtensionFilter(uint16(x.number)),
+			func(section []byte) bool {
+				id := PSI(section).TableID()
+				return id == TableIDEITActual ||
+					id >= TableIDEITScheduleActual && id <= TableIDEITScheduleActual+0x0F
+			})
+	}
+
+	pr := NewPacketReader(r)
+	for {
+		p, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if x.passes(p.PID()) {
+			if _, err := w.Write(p); err != nil {
+				return err
+			}
+		}
+		if err := x.d.WritePacket(p); err != nil {
+			return err
+		}
+	}
+	if !x.found {
+		return ErrNoProgram
+	}
+	return nil
+}
+
+// passes reports whether the packets of the PID are output as they are.
+func (x *extraction) passes(pid PID) bool {
+	if x.pass[pid] {
+		return true
+	}
+	return x.si && (pid == x.nitPID || pid == PidTDT)
+}
+
+func (x *extraction) handlePAT(rx *SectionReceiver) error {
+	pat, err := NewPAT(rx.Bytes())
+	if err != nil || pat.SectionNumber() != 0 {
+		return nil
+	}
+	pg, ok := x.d.Program(x.number)
+	if !ok {
+		return nil
+	}
+	x.found = true
+	if pg.PID != x.pmtPID {
+		x.pmtPID = pg.PID
+		x.pmt = NewPacketizer(pg.PID)
+		x.pass = make(map[PID]bool)
+		if !x.pmts[pg.PID] {
+			x.pmts[pg.PID] = true
+			x.d.HandleSection(pg.PID, x.handlePMT, TableIDFilter(0x02),
+				TableIDExtensionFilter(uint16(x.number)))
+		}
+	}
+
+	programs := map[ProgramNumber]PID{x.number: pg.PID}
+	if x.si {
+		for _, a := range pat.associations() {
+			if a.number() == 0 {
+				x.nitPID = a.pid()
+				programs[0] = a.pid()
+			}
+		}
+	}
+	version := (pat.VersionNumber() + 1) & 0x1F
+	return x.write(x.pat, BuildPAT(pat.TransportStreamID(), version, programs))
+}
+
+func (x *extraction) handlePMT(rx *SectionReceiver) error {
+	if rx.PID != x.pmtPID {
+		return nil
+	}
+	return x.write(x.pmt, rx.Bytes())
+}
+
+func (x *extraction) handleProgram(pg *Program) error {
+	if pg.Number != x.number {
+		return nil
+	}
+	pass := make(map[PID]bool)
+	if pcr := pg.PCRPID(); pcr != PidNull {
+		pass[pcr] = true
+	}
+	for _, pid := range caPIDs(pg.PMT.Descriptors()) {
+		pass[pid] = true
+	}
+	for _, st := range pg.Streams {
+		pass[st.PID] = true
+		for _, pid := range caPIDs(st.Info.Descriptors()) {
+			pass[pid] = true
+		}
+	}
+	x.pass = pass
+	return nil
+}
+
+func (x *extraction) handleSDT(rx *SectionReceiver) error {
+	sdt, err := NewSDT(rx.Bytes())
+	if err != nil {
+		return nil
+	}
+	if b := sdt.filterServices(x.number); b != nil {
+		return x.write(x.sdt, b)
+	}
+	return nil
+}
+
+func (x *extraction) handleEIT(rx *SectionReceiver) error {
+	return x.write(x.eit, rx.Bytes())
+}
+
+// write writes the section in the packets of the Packetizer.
+func (x *extraction) write(pz *Packetizer, section []byte) error {
+	for _, p := range pz.Section(section) {
+		if _, err := x.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
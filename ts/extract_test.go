@@ -0,0 +1,184 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func makeTestMPTS() []byte {
+	pat := BuildPAT(0x7FE5, 3, map[ProgramNumber]PID{0: PidNIT, 1: 0x1000, 2: 0x1001})
+	pmt1 := buildSection(0x02, 1, 0, []byte{
+		0xE1, 0x00, 0xF0, 0x06, // PCR_PID, program_info_length
+		0x09, 0x04, 0x00, 0x05, 0xE3, 0x00, // CA_descriptor, ECM PID 0x300
+		StreamTypeH264, 0xE1, 0x00, 0xF0, 0x00,
+		StreamTypeAAC, 0xE1, 0x01, 0xF0, 0x06,
+		0x09, 0x04, 0x00, 0x05, 0xE3, 0x01, // CA_descriptor, ECM PID 0x301
+	})
+	pmt2 := buildSection(0x02, 2, 0, []byte{
+		0xE2, 0x00, 0xF0, 0x00,
+		StreamTypeH264, 0xE2, 0x00, 0xF0, 0x00,
+	})
+	sdt := buildSection(TableIDSDTActual, 0x7FE5, 0, []byte{
+		0x00, 0x01, 0xFF, // original_network_id, reserved_future_use
+		0x00, 0x01, 0xFF, 0x80, 0x00, // service_id 1
+		0x00, 0x02, 0xFF, 0x80, 0x03, 0x48, 0x01, 0x01, // service_id 2
+	})
+	eit := func(sid uint16) []byte {
+		return buildSection(TableIDEITActual, sid, 0, []byte{0x7F, 0xE5, 0x00, 0x01, 0x00, 0x4E})
+	}
+
+	var stream []byte
+	section := func(pid PID, cc uint8, b []byte) {
+		stream = concatPacket(stream, makeTSPacket(pid, cc, true, append([]byte{0x00}, b...)))
+	}
+	for i := 0; i < 2; i++ {
+		cc := uint8(i)
+		section(PidPAT, cc, pat)
+		section(0x1000, cc, pmt1)
+		section(0x1001, cc, pmt2)
+		section(PidSDT, cc*3, sdt)
+		section(PidSDT, cc*3+1, buildSection(TableIDSDTOther, 0x0001, 0, []byte{0x00, 0x01, 0xFF}))
+		section(PidSDT, cc*3+2, buildSection(TableIDBAT, 0x0001, 0, []byte{0xF0, 0x00, 0xF0, 0x00}))
+		section(PidEIT, cc*2, eit(1))
+		section(PidEIT, cc*2+1, eit(2))
+		for _, pid := range []PID{0x100, 0x101, 0x200, 0x300, 0x301, 0x400, PidNIT, PidTDT} {
+			stream = concatPacket(stream, makeTSPacket(pid, cc, false, make([]byte, 184)))
+		}
+	}
+	return stream
+}
+
+func TestExtractor(t *testing.T) {
+	stream := makeTestMPTS()
+
+	for i, tc := range []struct {
+		si   bool
+		pids []PID
+	}{
+		{false, []PID{PidPAT, 0x100, 0x101, 0x300, 0x301, 0x1000}},
+		{true, []PID{PidPAT, PidNIT, PidSDT, PidEIT, PidTDT, 0x100, 0x101, 0x300, 0x301, 0x1000}},
+	} {
+		var out bytes.Buffer
+		e := NewExtractor(1)
+		e.KeepSI(tc.si)
+		if err := e.Extract(&out, bytes.NewReader(stream)); err != nil {
+			t.Fatalf("%0d: Extract() causes %s", i, err)
+		}
+
+		d := NewDemuxer()
+		var events []Event
+		d.Observe(func(e Event) {
+			events = append(events, e)
+		})
+		seen := make(map[PID]bool)
+		var pats []PAT
+		var sdts []SDT
+		var eits [][]byte
+		for pid := PID(0); pid < PidNull; pid++ {
+			pid := pid
+			d.HandlePacket(pid, func(p Packet) error {
+				seen[pid] = true
+				return nil
+			})
+		}
+		d.HandleSection(PidPAT, func(rx *SectionReceiver) error {
+			pat, _ := NewPAT(rx.Bytes())
+			pats = append(pats, append(PAT{}, pat...))
+			return nil
+		})
+		d.HandleSection(PidSDT, func(rx *SectionReceiver) error {
+			sdt, _ := NewSDT(rx.Bytes())
+			sdts = append(sdts, append(SDT{}, sdt...))
+			return nil
+		})
+		d.HandleSection(PidEIT, func(rx *SectionReceiver) error {
+			eits = append(eits, append([]byte{}, rx.Bytes()...))
+			return nil
+		})
+		if _, err := d.Write(out.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+
+		var pids []PID
+		for pid := range seen {
+			pids = append(pids, pid)
+		}
+		sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+		if !reflect.DeepEqual(pids, tc.pids) {
+			t.Errorf("%0d: Extract() outputs PIDs %v, want %v", i, pids, tc.pids)
+		}
+		if len(events) != 0 {
+			t.Errorf("%0d: Extract() causes events %v", i, events)
+		}
+
+		if len(pats) != 2 {
+			t.Fatalf("%0d: Extract() outputs %d PATs, want 2", i, len(pats))
+		}
+		want := map[ProgramNumber]PID{1: 0x1000}
+		if tc.si {
+			want[0] = PidNIT
+		}
+		for _, pat := range pats {
+			if !PSI(pat).VerifyCRC32() {
+				t.Errorf("%0d: PAT CRC_32 is invalid", i)
+			}
+			if v := pat.VersionNumber(); v != 4 {
+				t.Errorf("%0d: PAT.VersionNumber() => %d, want 4", i, v)
+			}
+			if tsid := pat.TransportStreamID(); tsid != 0x7FE5 {
+				t.Errorf("%0d: PAT.TransportStreamID() => 0x%04X, want 0x7FE5", i, tsid)
+			}
+			programs := make(map[ProgramNumber]PID)
+			for _, a := range pat.associations() {
+				programs[a.number()] = a.pid()
+			}
+			if !reflect.DeepEqual(programs, want) {
+				t.Errorf("%0d: PAT programs => %v, want %v", i, programs, want)
+			}
+		}
+
+		if !tc.si {
+			continue
+		}
+		if len(sdts) != 2 {
+			t.Fatalf("%0d: Extract() outputs %d SDTs, want 2", i, len(sdts))
+		}
+		for _, sdt := range sdts {
+			if !PSI(sdt).VerifyCRC32() {
+				t.Errorf("%0d: SDT CRC_32 is invalid", i)
+			}
+			services := sdt.Services()
+			if len(services) != 1 || services[0].ServiceID() != 1 {
+				t.Errorf("%0d: SDT.Services() => %v, want service 1 only", i, services)
+			}
+		}
+		if len(eits) != 2 {
+			t.Fatalf("%0d: Extract() outputs %d EITs, want 2", i, len(eits))
+		}
+		for _, eit := range eits {
+			if sid := uint16(eit[3])<<8 | uint16(eit[4]); sid != 1 {
+				t.Errorf("%0d: EIT service_id => %d, want 1", i, sid)
+			}
+		}
+	}
+
+	if err := NewExtractor(3).Extract(&bytes.Buffer{}, bytes.NewReader(stream)); err != ErrNoProgram {
+		t.Errorf("Extract() causes %v, want %s", err, ErrNoProgram)
+	}
+}
@@ -0,0 +1,145 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+// SectionFilter is the signature of the filter function used to filter the
+// section before it is delivered.
+type SectionFilter func(section []byte) bool
+
+// MaskFilter returns a SectionFilter which matches the section header with
+// value, mask and mode like the section filter of the Linux DVB demux.
+//
+// The filter bytes correspond to the section bytes except section_length,
+// that is, the index 0 is table_id and the index i(i >= 1) is the byte i+2 of
+// the section.
+//
+// The bits set in mask are compared. The bits not set in mode match
+// positively, that is, all of them must equal to value. The bits set in mode
+// match negatively, that is, at least one of them must differ from value.
+// mode may be nil for the positive match only.
+func MaskFilter(value, mask, mode []byte) SectionFilter {
+	n := len(mask)
+	pos := make([]byte, n) // mask & ^mode
+	neg := make([]byte, n) // mask & mode
+	val := make([]byte, n)
+	doneg := false
+	for i := 0; i < n; i++ {
+		var m byte
+		if i < len(mode) {
+			m = mode[i]
+		}
+		if i < len(value) {
+			val[i] = value[i]
+		}
+		pos[i] = mask[i] &^ m
+		neg[i] = mask[i] & m
+		if neg[i] != 0 {
+			doneg = true
+		}
+	}
+	return func(section []byte) bool {
+		var neq byte
+		for i := 0; i < n; i++ {
+			if pos[i] == 0 && neg[i] == 0 {
+				continue
+			}
+			j := i
+			if i > 0 {
+				j += 2 // skip section_length
+			}
+			if j >= len(section) {
+				return false
+			}
+			xor := val[i] ^ section[j]
+			if pos[i]&xor != 0 {
+				return false
+			}
+			neq |= neg[i] & xor
+		}
+		return !doneg || neq != 0
+	}
+}
+
+// TableIDFilter returns a SectionFilter which matches the sections of any of
+// the table_ids.
+func TableIDFilter(ids ...TableID) SectionFilter {
+	return func(section []byte) bool {
+		if len(section) < 1 {
+			return false
+		}
+		for _, id := range ids {
+			if PSI(section).TableID() == id {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// TableIDRangeFilter returns a SectionFilter which matches the sections whose
+// table_id is between low and high inclusive.
+func TableIDRangeFilter(low, high TableID) SectionFilter {
+	return func(section []byte) bool {
+		if len(section) < 1 {
+			return false
+		}
+		id := PSI(section).TableID()
+		return low <= id && id <= high
+	}
+}
+
+// TableIDExtensionFilter returns a SectionFilter which matches the sections
+// whose table_id_extension is ext, such as transport_stream_id of PAT and
+// program_number of PMT.
+func TableIDExtensionFilter(ext uint16) SectionFilter {
+	return MaskFilter(
+		[]byte{0x00, byte(ext >> 8), byte(ext)},
+		[]byte{0x00, 0xFF, 0xFF},
+		nil,
+	)
+}
+
+// VersionNotEqualFilter returns a SectionFilter which matches the sections
+// whose version_number is not version.
+func VersionNotEqualFilter(version int) SectionFilter {
+	return MaskFilter(
+		[]byte{0x00, 0x00, 0x00, byte(version<<1) & 0x3E},
+		[]byte{0x00, 0x00, 0x00, 0x3E},
+		[]byte{0x00, 0x00, 0x00, 0x3E},
+	)
+}
+
+// CurrentOnlyFilter returns a SectionFilter which matches the sections whose
+// current_next_indicator is 1.
+func CurrentOnlyFilter() SectionFilter {
+	return MaskFilter(
+		[]byte{0x00, 0x00, 0x00, 0x01},
+		[]byte{0x00, 0x00, 0x00, 0x01},
+		nil,
+	)
+}
+
+// AllSectionFilters returns a SectionFilter which matches the sections matched
+// by all of the filters.
+func AllSectionFilters(filters ...SectionFilter) SectionFilter {
+	return func(section []byte) bool {
+		for _, f := range filters {
+			if !f(section) {
+				return false
+			}
+		}
+		return true
+	}
+}
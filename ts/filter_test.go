@@ -0,0 +1,71 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "testing"
+
+func TestSectionFilter(t *testing.T) {
+	// table_id: 0x02, program_number: 0x05A8, version_number: 22, current_next_indicator: 1
+	sec := testPMT
+	next := append([]byte{}, testPMT...)
+	next[5] &^= 0x01
+
+	for i, tc := range []struct {
+		f   SectionFilter
+		sec []byte
+		exp bool
+	}{
+		{MaskFilter([]byte{0x02}, []byte{0xFF}, nil), sec, true},
+		{MaskFilter([]byte{0x00}, []byte{0xFF}, nil), sec, false},
+		{MaskFilter([]byte{0x00}, []byte{0xFF}, []byte{0xFF}), sec, true},
+		{MaskFilter([]byte{0x02}, []byte{0xFF}, []byte{0xFF}), sec, false},
+		{MaskFilter([]byte{0x02, 0x05, 0xA8}, []byte{0xFF, 0xFF, 0xFF}, nil), sec, true},
+		{MaskFilter([]byte{0x02, 0x05, 0xA8}, []byte{0xFF, 0xFF, 0xFF}, nil), []byte{0x02}, false},
+		{TableIDFilter(0x00, 0x02), sec, true},
+		{TableIDFilter(0x00, 0x01), sec, false},
+		{TableIDRangeFilter(0x02, 0x03), sec, true},
+		{TableIDRangeFilter(0x40, 0x7F), sec, false},
+		{TableIDExtensionFilter(0x05A8), sec, true},
+		{TableIDExtensionFilter(0x05A9), sec, false},
+		{VersionNotEqualFilter(21), sec, true},
+		{VersionNotEqualFilter(22), sec, false},
+		{CurrentOnlyFilter(), sec, true},
+		{CurrentOnlyFilter(), next, false},
+		{AllSectionFilters(TableIDFilter(0x02), CurrentOnlyFilter()), sec, true},
+		{AllSectionFilters(TableIDFilter(0x02), CurrentOnlyFilter()), next, false},
+	} {
+		if got := tc.f(tc.sec); got != tc.exp {
+			t.Errorf("%0d: SectionFilter(0x%02X) => %t, want %t", i, tc.sec[:6], got, tc.exp)
+		}
+	}
+}
+
+func TestDemuxerHandleSectionFilter(t *testing.T) {
+	d := NewDemuxer()
+	n := 0
+	d.HandleSection(0x10, func(rx *SectionReceiver) error {
+		n++
+		return nil
+	}, TableIDFilter(0x40))
+	var stream []byte
+	stream = concatPacket(stream, makeTSPacket(0x10, 0, true, append([]byte{0x00}, makeTestSection(0x40, 20)...)))
+	stream = concatPacket(stream, makeTSPacket(0x10, 1, true, append([]byte{0x00}, makeTestSection(0x41, 20)...)))
+	if _, err := d.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d sections, want %d", n, 1)
+	}
+}
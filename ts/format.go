@@ -0,0 +1,484 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The types of the packets, the tables and the descriptors implement
+// fmt.Formatter. They are formatted by String for %s and %v, in detail for
+// %+v, and as their underlying integers or bytes for the other verbs and %#v.
+
+// formatValue formats v by String for %s and %v, by verbose for %+v, and raw
+// for the others.
+func formatValue(f fmt.State, verb rune, v fmt.Stringer, verbose func() string, raw interface{}) {
+	switch {
+	case verb == 'v' && f.Flag('+'):
+		io.WriteString(f, verbose())
+	case verb == 's' || verb == 'v' && !f.Flag('#'):
+		formatFallback(f, 's', v.String())
+	default:
+		formatFallback(f, verb, raw)
+	}
+}
+
+// formatFallback formats v by the verb with the flags, the width and the
+// precision of f.
+func formatFallback(f fmt.State, verb rune, v interface{}) {
+	format := []byte{'%'}
+	for _, c := range "+-# 0" {
+		if f.Flag(int(c)) {
+			format = append(format, byte(c))
+		}
+	}
+	if w, ok := f.Width(); ok {
+		format = strconv.AppendInt(format, int64(w), 10)
+	}
+	if p, ok := f.Precision(); ok {
+		format = append(format, '.')
+		format = strconv.AppendInt(format, int64(p), 10)
+	}
+	format = append(format, string(verb)...)
+	fmt.Fprintf(f, string(format), v)
+}
+
+var pidNames = map[PID]string{
+	PidPAT:  "PAT",
+	PidCAT:  "CAT",
+	PidTSDT: "TSDT",
+	PidIPMP: "IPMP",
+	PidNIT:  "NIT",
+	PidSDT:  "SDT",
+	PidEIT:  "EIT",
+	PidRST:  "RST",
+	PidTDT:  "TDT",
+	PidNull: "null",
+}
+
+// String returns the PID in hexadecimal with the name of the well-known PID.
+func (pid PID) String() string {
+	if name, ok := pidNames[pid]; ok {
+		return fmt.Sprintf("0x%04X (%s)", uint16(pid), name)
+	}
+	return fmt.Sprintf("0x%04X", uint16(pid))
+}
+
+// Format implements fmt.Formatter.
+func (pid PID) Format(f fmt.State, verb rune) {
+	formatValue(f, verb, pid, pid.String, uint16(pid))
+}
+
+var tagNames = map[DescriptorTag]string{
+	TagVideoStream:                  "video_stream_descriptor",
+	TagAudioStream:                  "audio_stream_descriptor",
+	TagHierarchy:                    "hierarchy_descriptor",
+	TagRegistration:                 "registration_descriptor",
+	TagDataStreamAlignment:          "data_stream_alignment_descriptor",
+	TagTargetBackgroundGrid:         "target_background_grid_descriptor",
+	TagVideoWindow:                  "video_window_descriptor",
+	TagCA:                           "CA_descriptor",
+	TagISO639Language:               "ISO_639_language_descriptor",
+	TagSystemClock:                  "system_clock_descriptor",
+	TagMultiplexBufferUtilization:   "multiplex_buffer_utilization_descriptor",
+	TagCopyright:                    "copyright_descriptor",
+	TagMaximumBitrate:               "maximum_bitrate_descriptor",
+	TagPrivateDataIndicator:         "private_data_indicator_descriptor",
+	TagSmoothingBuffer:              "smoothing_buffer_descriptor",
+	TagSTD:                          "STD_descriptor",
+	TagIBP:                          "IBP_descriptor",
+	TagMPEG4Video:                   "MPEG-4_video_descriptor",
+	TagMPEG4Audio:                   "MPEG-4_audio_descriptor",
+	TagIOD:                          "IOD_descriptor",
+	TagSL:                           "SL_descriptor",
+	TagFMC:                          "FMC_descriptor",
+	TagExternalESID:                 "external_ES_ID_descriptor",
+	TagMuxCode:                      "MuxCode_descriptor",
+	TagFmxBufferSize:                "FmxBufferSize_descriptor",
+	TagMultiplexbuffer:              "multiplexbuffer_descriptor",
+	TagContentLabeling:              "content_labeling_descriptor",
+	TagMetadataPointer:              "metadata_pointer_descriptor",
+	TagMetadata:                     "metadata_descriptor",
+	TagMetadataSTD:                  "metadata_STD_descriptor",
+	TagAVCVideo:                     "AVC_video_descriptor",
+	TagIPMP:                         "IPMP_descriptor",
+	TagAVCTimingAndHRD:              "AVC_timing_and_HRD_descriptor",
+	TagMPEG2AACAudio:                "MPEG-2_AAC_audio_descriptor",
+	TagFlexMuxTiming:                "FlexMuxTiming_descriptor",
+	TagMPEG4Text:                    "MPEG-4_text_descriptor",
+	TagMPEG4AudioExtension:          "MPEG-4_audio_extension_descriptor",
+	TagAuxiliaryVideoStream:         "auxiliary_video_stream_descriptor",
+	TagSVCExtension:                 "SVC_extension_descriptor",
+	TagMVCExtension:                 "MVC_extension_descriptor",
+	TagJ2KVideo:                     "J2K_video_descriptor",
+	TagMVCOperationPoint:            "MVC_operation_point_descriptor",
+	TagMPEG2StereoscopicVideoFormat: "MPEG2_stereoscopic_video_format_descriptor",
+	TagStereoscopicProgramInfo:      "Stereoscopic_program_info_descriptor",
+	TagStereoscopicVideoInfo:        "Stereoscopic_video_info_descriptor",
+	TagService:                      "service_descriptor",
+}
+
+// Name returns the name of the tag, or its range such as "user private" for
+// the unknown tag.
+func (tag DescriptorTag) Name() string {
+	if name, ok := tagNames[tag]; ok {
+		return name
+	}
+	switch {
+	case tag >= 0x40:
+		return "user private"
+	case tag >= 0x13 && tag <= 0x1A:
+		return "ISO/IEC 13818-6"
+	}
+	return "reserved"
+}
+
+// String returns the tag in hexadecimal with its name.
+func (tag DescriptorTag) String() string {
+	return fmt.Sprintf("0x%02X (%s)", byte(tag), tag.Name())
+}
+
+// Format implements fmt.Formatter.
+func (tag DescriptorTag) Format(f fmt.State, verb rune) {
+	formatValue(f, verb, tag, tag.String, byte(tag))
+}
+
+var streamTypeNames = map[byte]string{
+	StreamTypeMPEG1Video:      "MPEG-1 video",
+	StreamTypeMPEG2Video:      "MPEG-2 video",
+	StreamTypeMPEG1Audio:      "MPEG-1 audio",
+	StreamTypeMPEG2Audio:      "MPEG-2 audio",
+	StreamTypePrivateSections: "private sections",
+	StreamTypePrivateData:     "PES private data",
+	StreamTypeMHEG:            "MHEG",
+	StreamTypeDSMCC:           "DSM-CC",
+	StreamTypeDSMCCTypeA:      "DSM-CC type A",
+	StreamTypeDSMCCTypeB:      "DSM-CC type B",
+	StreamTypeDSMCCTypeC:      "DSM-CC type C",
+	StreamTypeDSMCCTypeD:      "DSM-CC type D",
+	StreamTypeAAC:             "AAC audio",
+	StreamTypeMPEG4Video:      "MPEG-4 video",
+	StreamTypeLATMAAC:         "AAC audio in LATM",
+	StreamTypeMetadataPES:     "metadata",
+	StreamTypeH264:            "H.264 video",
+	StreamTypeHEVC:            "H.265 video",
+	StreamTypeAC3:             "AC-3 audio",
+	StreamTypeEAC3:            "E-AC-3 audio",
+}
+
+// StreamTypeName returns the name of the stream_type, or its range such as
+// "user private" for the unknown type.
+func StreamTypeName(t byte) string {
+	if name, ok := streamTypeNames[t]; ok {
+		return name
+	}
+	if t >= 0x80 {
+		return "user private"
+	}
+	return "reserved"
+}
+
+// streamTypeString returns the stream_type in hexadecimal with its name.
+func streamTypeString(t byte) string {
+	return fmt.Sprintf("0x%02X (%s)", t, StreamTypeName(t))
+}
+
+// String returns the tag and the data in hexadecimal.
+func (d Descriptor) String() string {
+	if len(d) < 2 {
+		return fmt.Sprintf("invalid descriptor %x", []byte(d))
+	}
+	return fmt.Sprintf("%s %x", d.Tag(), d.Data())
+}
+
+func (d Descriptor) verbose() string {
+	if len(d) < 2 {
+		return d.String()
+	}
+	return fmt.Sprintf("descriptor_tag=%s descriptor_length=%d data=%x", d.Tag(), d.Length(), d.Data())
+}
+
+// Format implements fmt.Formatter.
+func (d Descriptor) Format(f fmt.State, verb rune) {
+	formatValue(f, verb, d, d.verbose, []byte(d))
+}
+
+// clockString returns the clock reference in units of 27 MHz with seconds.
+func clockString(v int64) string {
+	return fmt.Sprintf("%d (%d.%06ds)", v, v/SystemClockFrequency, v%SystemClockFrequency/27)
+}
+
+// String returns the PCR in units of 27 MHz with seconds.
+func (pcr PCR) String() string {
+	if len(pcr) < 6 {
+		return fmt.Sprintf("invalid PCR %x", []byte(pcr))
+	}
+	return clockString(pcr.Value())
+}
+
+func (pcr PCR) verbose() string {
+	if len(pcr) < 6 {
+		return pcr.String()
+	}
+	return fmt.Sprintf("base=%d extension=%d value=%s", pcr.Base(), pcr.Extension(), clockString(pcr.Value()))
+}
+
+// Format implements fmt.Formatter.
+func (pcr PCR) Format(f fmt.State, verb rune) {
+	formatValue(f, verb, pcr, pcr.verbose, []byte(pcr))
+}
+
+// String returns the flags and the fields set in the adaptation field.
+func (af AdaptationField) String() string {
+	if len(af) < 1 {
+		return "invalid adaptation field"
+	}
+	s := []string{fmt.Sprintf("length=%d", af.Length())}
+	if af.Length() == 0 || len(af) < 2 {
+		return strings.Join(s, " ")
+	}
+	if af.IsDiscontinuous() {
+		s = append(s, "discontinuity")
+	}
+	if af.RandomAccessIndicator() == 1 {
+		s = append(s, "random_access")
+	}
+	if af.ElementaryStreamPriorityIndicator() == 1 {
+		s = append(s, "priority")
+	}
+	if af.HasPCR() && len(af) >= 8 {
+		s = append(s, "PCR="+af.PCR().String())
+	}
+	if af.HasOPCR() && len(af) >= 14 {
+		s = append(s, "OPCR="+clockString(af.OPCR().Value()))
+	}
+	return strings.Join(s, " ")
+}
+
+func (af AdaptationField) verbose() string {
+	if len(af) < 2 || af.Length() == 0 {
+		return af.String()
+	}
+	s := fmt.Sprintf("adaptation_field_length=%d discontinuity_indicator=%d random_access_indicator=%d elementary_stream_priority_indicator=%d",
+		af.Length(), af.DiscontinuityIndicator(), af.RandomAccessIndicator(), af.ElementaryStreamPriorityIndicator())
+	if af.HasPCR() && len(af) >= 8 {
+		s += " PCR=" + af.PCR().verbose()
+	}
+	if af.HasOPCR() && len(af) >= 14 {
+		s += " OPCR=" + clockString(af.OPCR().Value())
+	}
+	if af.HasSpliceCountdown() {
+		s += fmt.Sprintf(" splice_countdown=%d", af.SpliceCountdown())
+	}
+	if af.HasTransportPrivateData() {
+		s += fmt.Sprintf(" transport_private_data=%x", af.TransportPrivateData())
+	}
+	if af.HasExtension() {
+		s += fmt.Sprintf(" adaptation_field_extension_length=%d", af.AdaptationExtensionLength())
+	}
+	return s
+}
+
+// Format implements fmt.Formatter.
+func (af AdaptationField) Format(f fmt.State, verb rune) {
+	formatValue(f, verb, af, af.verbose, []byte(af))
+}
+
+// String returns the PID, the flags, the continuity_counter, the adaptation
+// field and the size of the payload of the packet.
+func (p Packet) String() string {
+	if len(p) < 4 {
+		return fmt.Sprintf("invalid packet %x", []byte(p))
+	}
+	s := []string{"PID " + p.PID().String()}
+	if p.HasTransportError() {
+		s = append(s, "TEI")
+	}
+	if p.IsPayloadUnitStart() {
+		s = append(s, "PUSI")
+	}
+	if c := p.TransportScramblingControl(); c != 0 {
+		s = append(s, fmt.Sprintf("scrambled=%d", c))
+	}
+	s = append(s, fmt.Sprintf("CC=%d", p.ContinuityCounter()))
+	af, err := p.AdaptationField()
+	if err != nil {
+		// the payload is unknown without the length of the adaptation field
+		s = append(s, "AF("+err.Error()+")")
+		return strings.Join(s, " ")
+	}
+	if af != nil {
+		s = append(s, "AF("+af.String()+")")
+	}
+	if p.HasPayload() {
+		s = append(s, fmt.Sprintf("payload=%d", len(p.Payload())))
+	}
+	return strings.Join(s, " ")
+}
+
+func (p Packet) verbose() string {
+	if len(p) < 4 {
+		return p.String()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "sync_byte=0x%02X transport_error_indicator=%d payload_unit_start_indicator=%d transport_priority=%d PID=%s transport_scrambling_control=%d adaptation_field_control=%d continuity_counter=%d",
+		p.SyncByte(), p.TransportErrorIndicator(), p.PayloadUnitStartIndicator(), p.TransportPriority(),
+		p.PID(), p.TransportScramblingControl(), p.AdaptationFieldControl(), p.ContinuityCounter())
+	if af, err := p.AdaptationField(); err != nil {
+		fmt.Fprintf(&b, "\n  adaptation_field: %s", err)
+		return b.String()
+	} else if af != nil {
+		fmt.Fprintf(&b, "\n  adaptation_field: %s", af.verbose())
+	}
+	if p.HasPayload() {
+		fmt.Fprintf(&b, "\n  payload: %x", []byte(p.Payload()))
+	}
+	return b.String()
+}
+
+// Format implements fmt.Formatter.
+func (p Packet) Format(f fmt.State, verb rune) {
+	formatValue(f, verb, p, p.verbose, []byte(p))
+}
+
+// sectionHeader returns the common fields of the long section header.
+func sectionHeader(b []byte) string {
+	return fmt.Sprintf("version=%d current_next=%d section=%d/%d",
+		VersionNumber(b), CurrentNextIndicator(b), SectionNumber(b), LastSectionNumber(b))
+}
+
+// String returns the transport_stream_id, the version and the programs.
+func (t PAT) String() string {
+	if len(t) < 12 {
+		return fmt.Sprintf("invalid PAT %x", []byte(t))
+	}
+	s := []string{fmt.Sprintf("PAT transport_stream_id=%d version=%d", t.TransportStreamID(), t.VersionNumber())}
+	for _, a := range t.associations() {
+		s = append(s, fmt.Sprintf("%d:%s", a.number(), a.pid()))
+	}
+	return strings.Join(s, " ")
+}
+
+func (t PAT) verbose() string {
+	if len(t) < 12 {
+		return t.String()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "PAT transport_stream_id=%d %s CRC_32=%x", t.TransportStreamID(), sectionHeader(t), []byte(PSI(t).CRC32()))
+	for _, a := range t.associations() {
+		if a.number() == 0 {
+			fmt.Fprintf(&b, "\n  network_PID=%s", a.pid())
+			continue
+		}
+		fmt.Fprintf(&b, "\n  program_number=%d program_map_PID=%s", a.number(), a.pid())
+	}
+	return b.String()
+}
+
+// Format implements fmt.Formatter.
+func (t PAT) Format(f fmt.State, verb rune) {
+	formatValue(f, verb, t, t.verbose, []byte(t))
+}
+
+// String returns the version and the descriptors.
+func (t CAT) String() string {
+	if len(t) < 12 {
+		return fmt.Sprintf("invalid CAT %x", []byte(t))
+	}
+	return fmt.Sprintf("CAT version=%d %v", t.VersionNumber(), t.Descriptors())
+}
+
+func (t CAT) verbose() string {
+	if len(t) < 12 {
+		return t.String()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "CAT %s CRC_32=%x", sectionHeader(t), []byte(PSI(t).CRC32()))
+	for _, d := range t.Descriptors() {
+		fmt.Fprintf(&b, "\n  %s", d.verbose())
+	}
+	return b.String()
+}
+
+// Format implements fmt.Formatter.
+func (t CAT) Format(f fmt.State, verb rune) {
+	formatValue(f, verb, t, t.verbose, []byte(t))
+}
+
+// String returns the program_number, the version, the PCR_PID and the
+// program elements.
+func (t PMT) String() string {
+	if len(t) < 16 {
+		return fmt.Sprintf("invalid PMT %x", []byte(t))
+	}
+	s := []string{fmt.Sprintf("PMT program_number=%d version=%d PCR_PID=%s", t.ProgramNumber(), t.VersionNumber(), t.PCRPID())}
+	for _, info := range t.ElementInfo() {
+		s = append(s, "["+info.String()+"]")
+	}
+	return strings.Join(s, " ")
+}
+
+func (t PMT) verbose() string {
+	if len(t) < 16 {
+		return t.String()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "PMT program_number=%d %s PCR_PID=%s CRC_32=%x", t.ProgramNumber(), sectionHeader(t), t.PCRPID(), []byte(PSI(t).CRC32()))
+	for _, d := range t.Descriptors() {
+		fmt.Fprintf(&b, "\n  %s", d.verbose())
+	}
+	for _, info := range t.ElementInfo() {
+		fmt.Fprintf(&b, "\n  %s", strings.ReplaceAll(info.verbose(), "\n", "\n  "))
+	}
+	return b.String()
+}
+
+// Format implements fmt.Formatter.
+func (t PMT) Format(f fmt.State, verb rune) {
+	formatValue(f, verb, t, t.verbose, []byte(t))
+}
+
+// String returns the stream_type, the elementary_PID and the tags of the
+// descriptors.
+func (i ProgramElementInfo) String() string {
+	if len(i) < 5 {
+		return fmt.Sprintf("invalid program element %x", []byte(i))
+	}
+	s := []string{streamTypeString(i.StreamType()), "PID " + i.ElementaryPID().String()}
+	for _, d := range i.Descriptors() {
+		s = append(s, d.Tag().String())
+	}
+	return strings.Join(s, " ")
+}
+
+func (i ProgramElementInfo) verbose() string {
+	if len(i) < 5 {
+		return i.String()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "stream_type=%s elementary_PID=%s ES_info_length=%d", streamTypeString(i.StreamType()), i.ElementaryPID(), i.ESInfoLength())
+	for _, d := range i.Descriptors() {
+		fmt.Fprintf(&b, "\n  %s", d.verbose())
+	}
+	return b.String()
+}
+
+// Format implements fmt.Formatter.
+func (i ProgramElementInfo) Format(f fmt.State, verb rune) {
+	formatValue(f, verb, i, i.verbose, []byte(i))
+}
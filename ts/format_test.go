@@ -0,0 +1,110 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	pmt := BuildPMT(1, 2, 0x0100, nil,
+		BuildProgramElementInfo(StreamTypeH264, 0x0100),
+		BuildProgramElementInfo(StreamTypeAAC, 0x0101, BuildDescriptor(TagISO639Language, []byte("jpn\x00"))))
+	af := AdaptationField(makeTestAFPacket(0x0100, 0, true, 0x40, 27000000, make([]byte, 100))[4:])
+	// adaptation_field_length beyond the packet
+	broken := Packet(makeTSPacket(0x0100, 3, false, nil))
+	broken[3] |= 0x30
+	broken[4] = 200
+	for i, tc := range []struct {
+		format string
+		v      interface{}
+		want   string
+	}{
+		{"%v", PID(0x0000), "0x0000 (PAT)"},
+		{"%s", PID(0x1FFF), "0x1FFF (null)"},
+		{"%v", PID(0x0100), "0x0100"},
+		{"%-8v|", PID(0x0100), "0x0100  |"},
+		{"0x%04X", PID(0x0100), "0x0100"},
+		{"%d", PID(0x0100), "256"},
+		{"%#v", PID(0x0100), "0x100"},
+		{"%v", TagISO639Language, "0x0A (ISO_639_language_descriptor)"},
+		{"%v", DescriptorTag(0x37), "0x37 (reserved)"},
+		{"%v", DescriptorTag(0x80), "0x80 (user private)"},
+		{"%d", TagCA, "9"},
+		{"%v", BuildDescriptor(TagRegistration, []byte("HEVC")), "0x05 (registration_descriptor) 48455643"},
+		{"%+v", BuildDescriptor(TagRegistration, []byte("HEVC")), "descriptor_tag=0x05 (registration_descriptor) descriptor_length=4 data=48455643"},
+		{"%02X", BuildDescriptor(TagRegistration, []byte("HEVC")), "050448455643"},
+		{"%v", PCR(encodeTestPCR(27000001)), "27000001 (1.000000s)"},
+		{"%+v", PCR(encodeTestPCR(27000001)), "base=90000 extension=1 value=27000001 (1.000000s)"},
+		{"%v", af, "length=83 random_access PCR=27000000 (1.000000s)"},
+		{"%v", Packet(makeTSPacket(0x0100, 3, true, nil)), "PID 0x0100 PUSI CC=3 payload=184"},
+		{"%v", broken, "PID 0x0100 CC=3 AF(unexpected EOF)"},
+		{"%08b", Packet{0x47}, "[01000111]"},
+		{"%v", BuildPAT(0x0001, 0, map[ProgramNumber]PID{0: 0x0010, 1: 0x1000}), "PAT transport_stream_id=1 version=0 0:0x0010 (NIT) 1:0x1000"},
+		{"%v", pmt, "PMT program_number=1 version=2 PCR_PID=0x0100 [0x1B (H.264 video) PID 0x0100] [0x0F (AAC audio) PID 0x0101 0x0A (ISO_639_language_descriptor)]"},
+		{"%v", CAT(buildSection(0x01, 0xFFFF, 0, BuildDescriptor(TagCA, []byte{0x00, 0x05, 0xE3, 0x00}))), "CAT version=0 [0x09 (CA_descriptor) 0005e300]"},
+	} {
+		if got := fmt.Sprintf(tc.format, tc.v); got != tc.want {
+			t.Errorf("%0d: Sprintf(%q) => %q, want %q", i, tc.format, got, tc.want)
+		}
+	}
+
+	for i, tc := range []struct {
+		v    interface{}
+		want []string
+	}{
+		{
+			Packet(makeTestAFPacket(0x0100, 0, true, 0x40, 27000000, []byte{0xAB})),
+			[]string{"payload_unit_start_indicator=1", "PID=0x0100", "adaptation_field_control=3", "\n  adaptation_field: adaptation_field_length=182", "PCR=base=90000", "\n  payload: ab"},
+		},
+		{broken, []string{"PID=0x0100", "\n  adaptation_field: unexpected EOF"}},
+		{
+			pmt,
+			[]string{"PMT program_number=1 version=2 current_next=1 section=0/0 PCR_PID=0x0100", "\n  stream_type=0x0F (AAC audio) elementary_PID=0x0101 ES_info_length=6", "\n    descriptor_tag=0x0A"},
+		},
+		{
+			BuildPAT(0x0001, 0, map[ProgramNumber]PID{0: 0x0010, 1: 0x1000}),
+			[]string{"\n  network_PID=0x0010 (NIT)", "\n  program_number=1 program_map_PID=0x1000"},
+		},
+	} {
+		got := fmt.Sprintf("%+v", tc.v)
+		for _, s := range tc.want {
+			if !strings.Contains(got, s) {
+				t.Errorf("%0d: Sprintf(%%+v) => %q, want %q in it", i, got, s)
+			}
+		}
+	}
+}
+
+func TestNames(t *testing.T) {
+	for i, tc := range []struct {
+		got  string
+		want string
+	}{
+		{TagCA.Name(), "CA_descriptor"},
+		{DescriptorTag(0x13).Name(), "ISO/IEC 13818-6"},
+		{DescriptorTag(0x37).Name(), "reserved"},
+		{DescriptorTag(0x80).Name(), "user private"},
+		{StreamTypeName(StreamTypeH264), "H.264 video"},
+		{StreamTypeName(0x7F), "reserved"},
+		{StreamTypeName(0x80), "user private"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%0d: Name() => %q, want %q", i, tc.got, tc.want)
+		}
+	}
+}
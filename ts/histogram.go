@@ -0,0 +1,54 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "time"
+
+// Histogram counts the durations in the bins of the same width.
+type Histogram struct {
+	Min    time.Duration // lower bound of the first bin
+	Width  time.Duration // width of a bin
+	Counts []int64       // counts of the bins
+	Under  int64         // count of the durations less than Min
+	Over   int64         // count of the durations out of the last bin
+}
+
+// NewHistogram returns a new Histogram of the bins from min.
+func NewHistogram(min, width time.Duration, bins int) *Histogram {
+	return &Histogram{Min: min, Width: width, Counts: make([]int64, bins)}
+}
+
+// Add counts the duration.
+func (h *Histogram) Add(d time.Duration) {
+	if d < h.Min {
+		h.Under++
+		return
+	}
+	i := int((d - h.Min) / h.Width)
+	if i >= len(h.Counts) {
+		h.Over++
+		return
+	}
+	h.Counts[i]++
+}
+
+// Total returns the count of all the durations.
+func (h *Histogram) Total() int64 {
+	total := h.Under + h.Over
+	for _, n := range h.Counts {
+		total += n
+	}
+	return total
+}
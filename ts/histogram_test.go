@@ -0,0 +1,36 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(-time.Microsecond, 500*time.Nanosecond, 4)
+	for _, d := range []time.Duration{-2 * time.Microsecond, -time.Microsecond, 0, 499 * time.Nanosecond, time.Microsecond - 1, time.Microsecond} {
+		h.Add(d)
+	}
+	want := []int64{1, 0, 2, 1}
+	for i := range want {
+		if h.Counts[i] != want[i] {
+			t.Errorf("Counts[%d] => %d, want %d", i, h.Counts[i], want[i])
+		}
+	}
+	if h.Under != 1 || h.Over != 1 || h.Total() != 6 {
+		t.Errorf("Under, Over, Total() => %d, %d, %d, want 1, 1, 6", h.Under, h.Over, h.Total())
+	}
+}
@@ -0,0 +1,291 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// ErrInvalidIndex is returned when the bytes are not an Index.
+var ErrInvalidIndex = errors.New("ts: invalid index")
+
+const (
+	indexMagic   = "TSIX"
+	indexVersion = 1
+)
+
+// IndexFlag describes an IndexEntry.
+type IndexFlag uint8
+
+// Flags of the IndexEntry.
+const (
+	IndexPCR          IndexFlag = 1 << iota // the packet has the PCR
+	IndexPTS                                // a PES packet with the PTS starts in the packet
+	IndexRandomAccess                       // random_access_indicator of the packet is set
+)
+
+// IndexEntry is an entry of the Index for a packet.
+type IndexEntry struct {
+	Offset int64 // byte offset of the packet
+	PID    PID
+	Flags  IndexFlag
+	PCR    int64 // in units of 27 MHz, if Flags has IndexPCR
+	PTS    int64 // in units of 90 kHz, if Flags has IndexPTS
+}
+
+// IsRandomAccess reports whether the entry is a random access point.
+func (e IndexEntry) IsRandomAccess() bool {
+	return e.Flags&IndexRandomAccess != 0
+}
+
+// Index is an index over a TS file, which maps the byte offsets of the
+// packets to their PCR and PTS, and marks the random access points.
+//
+// The seek methods build their lookup tables on the first call, so Entries
+// must not be modified after that and the calls must not be concurrent.
+type Index struct {
+	Entries []IndexEntry // in the order of Offset
+
+	pcrs map[PID]*indexSeries // entries with PCR by PID
+	raps map[PID]*indexSeries // random access points with PTS by PID
+}
+
+// indexSeries is the entries of a PID with their values unwrapped from the
+// first one.
+type indexSeries struct {
+	entries []int   // indices of the entries
+	values  []int64 // values unwrapped
+	wrap    int64
+}
+
+func (s *indexSeries) add(i int, v int64) {
+	if n := len(s.values); n > 0 {
+		prev := s.values[n-1]
+		v = prev + ((v-prev)%s.wrap+s.wrap)%s.wrap
+	}
+	s.entries = append(s.entries, i)
+	s.values = append(s.values, v)
+}
+
+// search returns the index of the last entry whose value is not after v.
+// The value less than the first one is taken as wrapped around if it falls
+// in the series.
+func (s *indexSeries) search(v int64) (int, bool) {
+	if s == nil || len(s.values) == 0 {
+		return 0, false
+	}
+	if v < s.values[0] && v+s.wrap <= s.values[len(s.values)-1] {
+		v += s.wrap
+	}
+	i := sort.Search(len(s.values), func(i int) bool {
+		return s.values[i] > v
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return s.entries[i-1], true
+}
+
+// BuildIndex builds the Index of the stream of size bytes read from r.
+func BuildIndex(r io.ReaderAt, size int64) (*Index, error) {
+	x := &Index{}
+	pr := NewPacketReader(io.NewSectionReader(r, 0, size))
+	for {
+		p, err := pr.Next()
+		if err == io.EOF {
+			return x, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		e := IndexEntry{Offset: pr.Offset(), PID: p.PID()}
+		af, err := p.AdaptationField()
+		if err != nil {
+			// broken packet
+			continue
+		}
+		if af != nil {
+			if af.HasPCR() && len(af) >= 8 {
+				e.Flags |= IndexPCR
+				e.PCR = af.PCR().Value()
+			}
+			if af.RandomAccessIndicator() == 1 {
+				e.Flags |= IndexRandomAccess
+			}
+		}
+		if p.IsPayloadUnitStart() && p.IsPES() {
+			if pes, err := NewPES(p.Payload()); err == nil && pes.HasPTS() {
+				e.Flags |= IndexPTS
+				e.PTS = pes.PTS()
+			}
+		}
+		if e.Flags != 0 {
+			x.Entries = append(x.Entries, e)
+		}
+	}
+}
+
+// SeekPCR returns the last entry of the PID with PCR not after pcr in units of
+// 27 MHz. The PCRs are unwrapped from the first one of the PID, so pcr may
+// be the value wrapped around or the value unwrapped beyond the wrap.
+func (x *Index) SeekPCR(pid PID, pcr int64) (IndexEntry, bool) {
+	if x.pcrs == nil {
+		x.pcrs = make(map[PID]*indexSeries)
+		for i, e := range x.Entries {
+			if e.Flags&IndexPCR != 0 {
+				indexSeriesOf(x.pcrs, e.PID, clockReferenceWrap).add(i, e.PCR)
+			}
+		}
+	}
+	i, ok := x.pcrs[pid].search(pcr)
+	if !ok {
+		return IndexEntry{}, false
+	}
+	return x.Entries[i], true
+}
+
+// SeekPTS returns the last random access point of the PID whose PTS is not
+// after pts in units of 90 kHz. The PTSs are unwrapped as the PCRs of
+// SeekPCR.
+func (x *Index) SeekPTS(pid PID, pts int64) (IndexEntry, bool) {
+	if x.raps == nil {
+		x.raps = make(map[PID]*indexSeries)
+		for i, e := range x.Entries {
+			if e.Flags&IndexPTS != 0 && e.IsRandomAccess() {
+				indexSeriesOf(x.raps, e.PID, timestampWrap).add(i, e.PTS)
+			}
+		}
+	}
+	i, ok := x.raps[pid].search(pts)
+	if !ok {
+		return IndexEntry{}, false
+	}
+	return x.Entries[i], true
+}
+
+func indexSeriesOf(m map[PID]*indexSeries, pid PID, wrap int64) *indexSeries {
+	s, ok := m[pid]
+	if !ok {
+		s = &indexSeries{wrap: wrap}
+		m[pid] = s
+	}
+	return s
+}
+
+// WriteTo writes the Index in the compact binary format to w.
+//
+// The format is the magic "TSIX", the version, the number of the entries and
+// the entries. Each entry is the offset from the previous entry, the PID, the
+// flags, and the PCR and the PTS relative to those of the previous entry
+// having them, all in varint encoding.
+func (x *Index) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var n int64
+	buf := make([]byte, binary.MaxVarintLen64)
+	put := func(b []byte) {
+		m, _ := bw.Write(b)
+		n += int64(m)
+	}
+	putUvarint := func(v uint64) {
+		put(buf[:binary.PutUvarint(buf, v)])
+	}
+	putVarint := func(v int64) {
+		put(buf[:binary.PutVarint(buf, v)])
+	}
+
+	put([]byte(indexMagic))
+	put([]byte{indexVersion})
+	putUvarint(uint64(len(x.Entries)))
+	var off, pcr, pts int64
+	for _, e := range x.Entries {
+		putUvarint(uint64(e.Offset - off))
+		putUvarint(uint64(e.PID))
+		put([]byte{byte(e.Flags)})
+		if e.Flags&IndexPCR != 0 {
+			putVarint(e.PCR - pcr)
+			pcr = e.PCR
+		}
+		if e.Flags&IndexPTS != 0 {
+			putVarint(e.PTS - pts)
+			pts = e.PTS
+		}
+		off = e.Offset
+	}
+	return n, bw.Flush()
+}
+
+// ReadIndex reads the Index written by WriteTo from r.
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(indexMagic)+1)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, err
+	}
+	if string(head[:len(indexMagic)]) != indexMagic || head[len(indexMagic)] != indexVersion {
+		return nil, ErrInvalidIndex
+	}
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &Index{}
+	var off, pcr, pts int64
+	for i := uint64(0); i < count; i++ {
+		d, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		pid, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		flags, err := br.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		off += int64(d)
+		e := IndexEntry{Offset: off, PID: PID(pid), Flags: IndexFlag(flags)}
+		if e.Flags&IndexPCR != 0 {
+			d, err := binary.ReadVarint(br)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			pcr += d
+			e.PCR = pcr
+		}
+		if e.Flags&IndexPTS != 0 {
+			d, err := binary.ReadVarint(br)
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			pts += d
+			e.PTS = pts
+		}
+		x.Entries = append(x.Entries, e)
+	}
+	return x, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
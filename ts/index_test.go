@@ -0,0 +1,172 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func encodeTestPCR(pcr int64) []byte {
+	base, ext := pcr/300, pcr%300
+	return []byte{byte(base >> 25), byte(base >> 17), byte(base >> 9), byte(base >> 1),
+		byte(base&1)<<7 | 0x7E | byte(ext>>8), byte(ext)}
+}
+
+func encodeTestPTS(pts int64) []byte {
+	return []byte{0x21 | byte(pts>>29)&0x0E, byte(pts >> 22), byte(pts>>14) | 0x01, byte(pts >> 7), byte(pts<<1) | 0x01}
+}
+
+// makeTestAFPacket makes a packet with the adaptation field having the flags
+// and the PCR if pcr >= 0, followed by payload.
+func makeTestAFPacket(pid PID, cc uint8, pusi bool, flags byte, pcr int64, payload []byte) []byte {
+	af := []byte{0x00, flags}
+	if pcr >= 0 {
+		af[1] |= 0x10
+		af = append(af, encodeTestPCR(pcr)...)
+	}
+	for len(af)+len(payload) < 184 {
+		af = append(af, 0xFF)
+	}
+	af[0] = byte(len(af) - 1)
+	p := makeTSPacket(pid, cc, pusi, append(af, payload...))
+	p[3] |= 0x20
+	return p
+}
+
+func makeTestPESWithPTS(streamID byte, pts int64, size int) []byte {
+	b := []byte{0x00, 0x00, 0x01, streamID, 0x00, 0x00, 0x80, 0x80, 0x05}
+	b = append(b, encodeTestPTS(pts)...)
+	for len(b) < size {
+		b = append(b, 0x00)
+	}
+	return b
+}
+
+func TestIndex(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 10; i++ {
+		// video PES with PCR, random access at every 3 PES
+		var flags byte
+		if i%3 == 0 {
+			flags = 0x40
+		}
+		pcr := int64(i) * 3003 * 300
+		pes := makeTestPESWithPTS(0xE0, int64(i)*3003+9000, 100)
+		stream = concatPacket(stream, makeTestAFPacket(0x100, uint8(i), true, flags, pcr, pes))
+		stream = concatPacket(stream, makeTSPacket(0x101, uint8(i), true, makeTestPESWithPTS(0xC0, int64(i)*3003+9000, 184)))
+	}
+
+	x, err := BuildIndex(bytes.NewReader(stream), int64(len(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(x.Entries) != 20 {
+		t.Fatalf("got %d entries, want %d", len(x.Entries), 20)
+	}
+	e := x.Entries[6]
+	exp := IndexEntry{Offset: 6 * 188, PID: 0x100, Flags: IndexPCR | IndexPTS | IndexRandomAccess, PCR: 3 * 3003 * 300, PTS: 3*3003 + 9000}
+	if e != exp {
+		t.Errorf("Entries[6] => %+v, want %+v", e, exp)
+	}
+
+	var buf bytes.Buffer
+	if _, err := x.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	y, err := ReadIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(y.Entries) != len(x.Entries) {
+		t.Fatalf("ReadIndex() => %d entries, want %d", len(y.Entries), len(x.Entries))
+	}
+	for i := range x.Entries {
+		if x.Entries[i] != y.Entries[i] {
+			t.Errorf("%d: ReadIndex() => %+v, want %+v", i, y.Entries[i], x.Entries[i])
+		}
+	}
+
+	for i, tc := range []struct {
+		pts    int64
+		ok     bool
+		offset int64
+	}{
+		{0, false, 0},
+		{9000, true, 0},
+		{5*3003 + 9000, true, 6 * 188},
+		{100000, true, 18 * 188},
+	} {
+		e, ok := y.SeekPTS(0x100, tc.pts)
+		if ok != tc.ok || e.Offset != tc.offset {
+			t.Errorf("%d: SeekPTS(%d) => %d, %t, want %d, %t", i, tc.pts, e.Offset, ok, tc.offset, tc.ok)
+		}
+	}
+	if e, ok := y.SeekPCR(0x100, 4*3003*300); !ok || e.Offset != 8*188 {
+		t.Errorf("SeekPCR() => %d, %t, want %d, %t", e.Offset, ok, 8*188, true)
+	}
+	if _, ok := y.SeekPCR(0x101, 4*3003*300); ok {
+		t.Errorf("SeekPCR(0x%04X) => %t, want %t", 0x101, ok, false)
+	}
+}
+
+func TestIndexSeekWrap(t *testing.T) {
+	// PCR and PTS wrap around between the 2nd and the 3rd packets
+	pcr0 := int64(clockReferenceWrap - 2*3003*300)
+	pts0 := int64(timestampWrap - 2*3003)
+	var stream []byte
+	for i := 0; i < 5; i++ {
+		pcr := (pcr0 + int64(i)*3003*300) % clockReferenceWrap
+		pts := (pts0 + int64(i)*3003) % timestampWrap
+		pes := makeTestPESWithPTS(0xE0, pts, 100)
+		stream = concatPacket(stream, makeTestAFPacket(0x100, uint8(i), true, 0x40, pcr, pes))
+	}
+	x, err := BuildIndex(bytes.NewReader(stream), int64(len(stream)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		pcr    int64
+		ok     bool
+		offset int64
+	}{
+		{0, true, 2 * 188},
+		{pcr0 - 1, false, 0},
+		{pcr0 + 3003*300, true, 1 * 188},
+		{3003 * 300, true, 3 * 188},
+		{clockReferenceWrap + 3003*300, true, 3 * 188},
+		{clockReferenceWrap + 10*3003*300, true, 4 * 188},
+	} {
+		e, ok := x.SeekPCR(0x100, tc.pcr)
+		if ok != tc.ok || e.Offset != tc.offset {
+			t.Errorf("%d: SeekPCR(%d) => %d, %t, want %d, %t", i, tc.pcr, e.Offset, ok, tc.offset, tc.ok)
+		}
+	}
+	for i, tc := range []struct {
+		pts    int64
+		ok     bool
+		offset int64
+	}{
+		{0, true, 2 * 188},
+		{pts0 - 1, false, 0},
+		{2 * 3003, true, 4 * 188},
+	} {
+		e, ok := x.SeekPTS(0x100, tc.pts)
+		if ok != tc.ok || e.Offset != tc.offset {
+			t.Errorf("%d: SeekPTS(%d) => %d, %t, want %d, %t", i, tc.pts, e.Offset, ok, tc.offset, tc.ok)
+		}
+	}
+}
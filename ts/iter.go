@@ -0,0 +1,105 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"errors"
+	"io"
+	"iter"
+)
+
+// errStopIteration is used to stop the iteration from inside the callbacks.
+var errStopIteration = errors.New("ts: stop iteration")
+
+// Packets returns an iterator over the packets read from r.
+// Each packet is a copy, so it can be retained after the iteration.
+func Packets(r io.Reader) iter.Seq2[Packet, error] {
+	return func(yield func(Packet, error) bool) {
+		s := NewPacketScanner(r)
+		for s.Scan() {
+			if !yield(s.Packet(), nil) {
+				return
+			}
+		}
+		if err := s.Err(); err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// Sections returns an iterator over the sections read from r.
+// filter selects the PIDs, and nil means NoopFilter.
+func Sections(r io.Reader, filter FilterFunc) iter.Seq2[*SectionReceiver, error] {
+	return func(yield func(*SectionReceiver, error) bool) {
+		sr := NewSectionReader(r)
+		if filter != nil {
+			sr.Filter(filter)
+		}
+		for {
+			rx, err := sr.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(rx, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// PESPackets returns an iterator over the PES packets of the PID read from r.
+// Each PES packet is valid only until the iteration continues, since its
+// buffer is reused.
+func PESPackets(r io.Reader, pid PID) iter.Seq2[PES, error] {
+	return func(yield func(PES, error) bool) {
+		emit := func(pes PES) error {
+			if !yield(pes, nil) {
+				return errStopIteration
+			}
+			return nil
+		}
+
+		s := NewPacketScanner(r)
+		c := newContinuity()
+		pb := &pesBuffer{}
+		var n int64
+		for s.Scan() {
+			p := Packet(s.Bytes())
+			n++
+			if p.PID() != pid {
+				continue
+			}
+			ok, drop, err := inspect(p, n-1, &c, nil)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if drop {
+				pb.drop()
+			}
+			if !ok {
+				continue
+			}
+			if err := pb.depacketize(p.Payload(), p.IsPayloadUnitStart(), emit); err != nil {
+				return
+			}
+		}
+		if err := s.Err(); err != nil {
+			yield(nil, err)
+			return
+		}
+		pb.flush(emit)
+	}
+}
@@ -0,0 +1,80 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPackets(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 3; i++ {
+		stream = concatPacket(stream, makeTSPacket(PID(i), 0, false, nil))
+	}
+
+	var pids []PID
+	for p, err := range Packets(bytes.NewReader(stream)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		pids = append(pids, p.PID())
+		if len(pids) == 2 {
+			break
+		}
+	}
+	if len(pids) != 2 || pids[0] != 0 || pids[1] != 1 {
+		t.Errorf("got PIDs %v, want [0 1]", pids)
+	}
+}
+
+func TestSections(t *testing.T) {
+	var stream []byte
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, testPAT...)))
+	stream = concatPacket(stream, makeTSPacket(0x1FC8, 0, true, append([]byte{0x00}, testPMT...)))
+
+	var got [][]byte
+	for rx, err := range Sections(bytes.NewReader(stream), func(pid PID) bool { return pid != PidPAT }) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, rx.Bytes())
+	}
+	if len(got) != 1 || !bytes.Equal(got[0], testPMT) {
+		t.Errorf("got sections 0x%02X, want 0x%02X", got, testPMT)
+	}
+}
+
+func TestPESPackets(t *testing.T) {
+	pes := makeTestPES(0xE0, 400)
+	var stream []byte
+	stream = concatPacket(stream, packetizeTestPES(0x100, 0, pes))
+	stream = concatPacket(stream, packetizeTestPES(0x101, 0, pes))
+	stream = concatPacket(stream, packetizeTestPES(0x100, 3, pes))
+
+	n := 0
+	for got, err := range PESPackets(bytes.NewReader(stream), 0x100) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(got, pes) {
+			t.Errorf("got PES 0x%02X, want 0x%02X", got, pes)
+		}
+		n++
+	}
+	if n != 2 {
+		t.Errorf("got %d PES packets, want %d", n, 2)
+	}
+}
@@ -0,0 +1,537 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// ErrInvalidPacketSize is returned when the packet decoded from JSON is not of
+// 188 bytes.
+var ErrInvalidPacketSize = errors.New("ts: invalid packet size")
+
+// RawJSON wraps a Packet, a PAT, a CAT, a PMT, a ProgramElementInfo or a
+// Descriptor to be encoded in JSON with its raw bytes, and the ones of the
+// tables and the descriptors in it. The raw bytes take precedence over the
+// other fields in decoding. The other values are encoded as they are.
+type RawJSON struct {
+	V interface{}
+}
+
+// MarshalJSON returns V in JSON with the raw bytes.
+func (r RawJSON) MarshalJSON() ([]byte, error) {
+	jv, ok := r.V.(jsonValuer)
+	if !ok {
+		return json.Marshal(r.V)
+	}
+	v, err := jv.jsonValue(true)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// jsonValuer returns the value encoded in JSON for the MarshalJSON, with the
+// raw bytes if raw is set.
+type jsonValuer interface {
+	jsonValue(raw bool) (interface{}, error)
+}
+
+// hexBytes is bytes in hexadecimal in JSON.
+type hexBytes []byte
+
+func (b hexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+func (b *hexBytes) UnmarshalText(text []byte) error {
+	v, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*b = v
+	return nil
+}
+
+// checkJSONSize reports whether b is encoded in JSON. It returns false for nil
+// to be null in JSON, and ErrTooShort for b shorter than minsize.
+func checkJSONSize(b []byte, minsize int) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+	if len(b) < minsize {
+		return false, ErrTooShort
+	}
+	return true, nil
+}
+
+// rawBytes returns b if raw is set.
+func rawBytes(b []byte, raw bool) hexBytes {
+	if !raw {
+		return nil
+	}
+	return b
+}
+
+type packetJSON struct {
+	TransportErrorIndicator    byte                 `json:"transport_error_indicator"`
+	PayloadUnitStartIndicator  byte                 `json:"payload_unit_start_indicator"`
+	TransportPriority          byte                 `json:"transport_priority"`
+	PID                        PID                  `json:"pid"`
+	TransportScramblingControl byte                 `json:"transport_scrambling_control"`
+	AdaptationFieldControl     byte                 `json:"adaptation_field_control"`
+	ContinuityCounter          uint8                `json:"continuity_counter"`
+	AdaptationField            *adaptationFieldJSON `json:"adaptation_field,omitempty"`
+	Payload                    hexBytes             `json:"payload,omitempty"`
+	Raw                        hexBytes             `json:"raw,omitempty"`
+}
+
+type adaptationFieldJSON struct {
+	Length                            int       `json:"adaptation_field_length"`
+	DiscontinuityIndicator            byte      `json:"discontinuity_indicator"`
+	RandomAccessIndicator             byte      `json:"random_access_indicator"`
+	ElementaryStreamPriorityIndicator byte      `json:"elementary_stream_priority_indicator"`
+	PCR                               *int64    `json:"pcr,omitempty"`
+	OPCR                              *int64    `json:"opcr,omitempty"`
+	SpliceCountdown                   *int8     `json:"splice_countdown,omitempty"`
+	TransportPrivateData              *hexBytes `json:"transport_private_data,omitempty"`
+	Extension                         *hexBytes `json:"adaptation_field_extension,omitempty"` // following adaptation_field_extension_length
+}
+
+// MarshalJSON returns the packet in JSON with the fields of the header, the
+// adaptation field and the payload in hexadecimal.
+func (p Packet) MarshalJSON() ([]byte, error) {
+	return marshalJSON(p)
+}
+
+func (p Packet) jsonValue(raw bool) (interface{}, error) {
+	if ok, err := checkJSONSize(p, 4); !ok {
+		return nil, err
+	}
+	v := packetJSON{
+		TransportErrorIndicator:    p.TransportErrorIndicator(),
+		PayloadUnitStartIndicator:  p.PayloadUnitStartIndicator(),
+		TransportPriority:          p.TransportPriority(),
+		PID:                        p.PID(),
+		TransportScramblingControl: p.TransportScramblingControl(),
+		AdaptationFieldControl:     p.AdaptationFieldControl(),
+		ContinuityCounter:          p.ContinuityCounter(),
+		Raw:                        rawBytes(p, raw),
+	}
+	af, err := p.AdaptationField()
+	if err != nil {
+		return nil, err
+	}
+	if af != nil {
+		v.AdaptationField = newAdaptationFieldJSON(af)
+	}
+	if p.HasPayload() {
+		v.Payload = hexBytes(p.Payload())
+	}
+	return v, nil
+}
+
+func newAdaptationFieldJSON(af AdaptationField) *adaptationFieldJSON {
+	v := &adaptationFieldJSON{Length: af.Length()}
+	if v.Length == 0 {
+		return v
+	}
+	v.DiscontinuityIndicator = af.DiscontinuityIndicator()
+	v.RandomAccessIndicator = af.RandomAccessIndicator()
+	v.ElementaryStreamPriorityIndicator = af.ElementaryStreamPriorityIndicator()
+	if af.HasPCR() {
+		pcr := af.PCR().Value()
+		v.PCR = &pcr
+	}
+	if af.HasOPCR() {
+		opcr := af.OPCR().Value()
+		v.OPCR = &opcr
+	}
+	if af.HasSpliceCountdown() {
+		c := af.SpliceCountdown()
+		v.SpliceCountdown = &c
+	}
+	if af.HasTransportPrivateData() {
+		data := hexBytes(af.TransportPrivateData())
+		v.TransportPrivateData = &data
+	}
+	if af.HasExtension() {
+		if ext, err := af.AdaptationExtension(); err == nil {
+			data := hexBytes(ext)
+			v.Extension = &data
+		}
+	}
+	return v
+}
+
+// bytes returns the adaptation field stuffed to its length.
+func (v *adaptationFieldJSON) bytes() []byte {
+	b := []byte{0, v.DiscontinuityIndicator<<7 | v.RandomAccessIndicator&0x01<<6 | v.ElementaryStreamPriorityIndicator&0x01<<5}
+	if v.PCR != nil {
+		b[1] |= 0x10
+		b = append(b, make([]byte, 6)...)
+		putClockReference(b[len(b)-6:], *v.PCR)
+	}
+	if v.OPCR != nil {
+		b[1] |= 0x08
+		b = append(b, make([]byte, 6)...)
+		putClockReference(b[len(b)-6:], *v.OPCR)
+	}
+	if v.SpliceCountdown != nil {
+		b[1] |= 0x04
+		b = append(b, byte(*v.SpliceCountdown))
+	}
+	if v.TransportPrivateData != nil {
+		b[1] |= 0x02
+		b = append(b, byte(len(*v.TransportPrivateData)))
+		b = append(b, *v.TransportPrivateData...)
+	}
+	if v.Extension != nil {
+		b[1] |= 0x01
+		b = append(b, byte(len(*v.Extension)))
+		b = append(b, *v.Extension...)
+	}
+	if v.Length == 0 && len(b) == 2 && b[1] == 0 {
+		return b[:1]
+	}
+	for len(b) < v.Length+1 {
+		b = append(b, 0xFF)
+	}
+	b[0] = byte(len(b) - 1)
+	return b
+}
+
+// UnmarshalJSON sets the packet encoded from the JSON by MarshalJSON.
+func (p *Packet) UnmarshalJSON(b []byte) error {
+	var v packetJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v.Raw != nil {
+		if len(v.Raw) != packetDefaultSize {
+			return ErrInvalidPacketSize
+		}
+		*p = Packet(v.Raw)
+		return nil
+	}
+	buf := make([]byte, 4, packetDefaultSize)
+	buf[0] = SyncByte
+	buf[1] = v.TransportErrorIndicator<<7 | v.PayloadUnitStartIndicator&0x01<<6 | v.TransportPriority&0x01<<5
+	putPID(buf[1:3], v.PID)
+	buf[3] = v.TransportScramblingControl<<6 | v.AdaptationFieldControl&0x03<<4 | v.ContinuityCounter&0x0F
+	if v.AdaptationField != nil {
+		buf = append(buf, v.AdaptationField.bytes()...)
+	}
+	buf = append(buf, v.Payload...)
+	if len(buf) != packetDefaultSize {
+		return ErrInvalidPacketSize
+	}
+	*p = buf
+	return nil
+}
+
+// sectionJSON is the common fields of the sections with the long header.
+type sectionJSON struct {
+	TableID              TableID  `json:"table_id"`
+	SectionLength        int      `json:"section_length"`
+	VersionNumber        int      `json:"version_number"`
+	CurrentNextIndicator *byte    `json:"current_next_indicator,omitempty"` // 1 if omitted
+	SectionNumber        byte     `json:"section_number"`
+	LastSectionNumber    byte     `json:"last_section_number"`
+	CRC32                hexBytes `json:"crc_32,omitempty"`
+	Raw                  hexBytes `json:"raw,omitempty"`
+}
+
+func newSectionJSON(b []byte, raw bool) sectionJSON {
+	cni := CurrentNextIndicator(b)
+	return sectionJSON{
+		TableID:              PSI(b).TableID(),
+		SectionLength:        PSI(b).SectionLength(),
+		VersionNumber:        VersionNumber(b),
+		CurrentNextIndicator: &cni,
+		SectionNumber:        SectionNumber(b),
+		LastSectionNumber:    LastSectionNumber(b),
+		CRC32:                hexBytes(PSI(b).CRC32()),
+		Raw:                  rawBytes(b, raw),
+	}
+}
+
+// build returns the section of the table_id with the body. The
+// section_length and the CRC_32 are computed.
+func (v sectionJSON) build(tableID TableID, ext uint16, body []byte) []byte {
+	if v.Raw != nil {
+		return v.Raw
+	}
+	b := buildSection(tableID, ext, v.VersionNumber, body)
+	if v.CurrentNextIndicator != nil && *v.CurrentNextIndicator == 0 {
+		b[5] &^= 0x01
+	}
+	b[6] = v.SectionNumber
+	b[7] = v.LastSectionNumber
+	PSI(b).UpdateCRC32()
+	return b
+}
+
+type patJSON struct {
+	sectionJSON
+	TransportStreamID TransportStreamID `json:"transport_stream_id"`
+	Programs          []patProgramJSON  `json:"programs"`
+}
+
+type patProgramJSON struct {
+	ProgramNumber ProgramNumber `json:"program_number"`
+	PID           PID           `json:"pid"` // network_PID or program_map_PID
+}
+
+// MarshalJSON returns the PAT in JSON with the programs in order.
+func (t PAT) MarshalJSON() ([]byte, error) {
+	return marshalJSON(t)
+}
+
+func (t PAT) jsonValue(raw bool) (interface{}, error) {
+	if ok, err := checkJSONSize(t, 12); !ok {
+		return nil, err
+	}
+	v := patJSON{
+		sectionJSON:       newSectionJSON(t, raw),
+		TransportStreamID: t.TransportStreamID(),
+		Programs:          []patProgramJSON{},
+	}
+	for _, a := range t.associations() {
+		v.Programs = append(v.Programs, patProgramJSON{a.number(), a.pid()})
+	}
+	return v, nil
+}
+
+// UnmarshalJSON sets the PAT encoded from the JSON by MarshalJSON. The
+// section_length and the CRC_32 are computed.
+func (t *PAT) UnmarshalJSON(b []byte) error {
+	var v patJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var body []byte
+	for _, pg := range v.Programs {
+		body = append(body, byte(pg.ProgramNumber>>8), byte(pg.ProgramNumber), 0xE0|byte(pg.PID>>8&0x1F), byte(pg.PID))
+	}
+	*t = PAT(v.build(0x00, uint16(v.TransportStreamID), body))
+	return nil
+}
+
+type catJSON struct {
+	sectionJSON
+	Descriptors []descriptorJSON `json:"descriptors"`
+}
+
+// MarshalJSON returns the CAT in JSON.
+func (t CAT) MarshalJSON() ([]byte, error) {
+	return marshalJSON(t)
+}
+
+func (t CAT) jsonValue(raw bool) (interface{}, error) {
+	if ok, err := checkJSONSize(t, 12); !ok {
+		return nil, err
+	}
+	return catJSON{
+		sectionJSON: newSectionJSON(t, raw),
+		Descriptors: newDescriptorsJSON(t.Descriptors(), raw),
+	}, nil
+}
+
+// UnmarshalJSON sets the CAT encoded from the JSON by MarshalJSON. The
+// section_length and the CRC_32 are computed.
+func (t *CAT) UnmarshalJSON(b []byte) error {
+	var v catJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var body []byte
+	for _, d := range v.Descriptors {
+		body = append(body, d.descriptor()...)
+	}
+	*t = CAT(v.build(0x01, 0xFFFF, body))
+	return nil
+}
+
+type pmtJSON struct {
+	sectionJSON
+	ProgramNumber ProgramNumber            `json:"program_number"`
+	PCRPID        PID                      `json:"pcr_pid"`
+	Descriptors   []descriptorJSON         `json:"descriptors"`
+	Streams       []programElementInfoJSON `json:"streams"`
+}
+
+// MarshalJSON returns the PMT in JSON.
+func (t PMT) MarshalJSON() ([]byte, error) {
+	return marshalJSON(t)
+}
+
+func (t PMT) jsonValue(raw bool) (interface{}, error) {
+	if ok, err := checkJSONSize(t, 16); !ok {
+		return nil, err
+	}
+	v := pmtJSON{
+		sectionJSON:   newSectionJSON(t, raw),
+		ProgramNumber: t.ProgramNumber(),
+		PCRPID:        t.PCRPID(),
+		Descriptors:   newDescriptorsJSON(t.Descriptors(), raw),
+		Streams:       []programElementInfoJSON{},
+	}
+	for _, i := range t.ElementInfo() {
+		v.Streams = append(v.Streams, newProgramElementInfoJSON(i, raw))
+	}
+	return v, nil
+}
+
+// UnmarshalJSON sets the PMT encoded from the JSON by MarshalJSON. The
+// section_length and the CRC_32 are computed.
+func (t *PMT) UnmarshalJSON(b []byte) error {
+	var v pmtJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	var streams []ProgramElementInfo
+	for _, i := range v.Streams {
+		streams = append(streams, i.info())
+	}
+	pmt := BuildPMT(v.ProgramNumber, v.VersionNumber, v.PCRPID, descriptors(v.Descriptors), streams...)
+	*t = PMT(v.build(0x02, uint16(v.ProgramNumber), pmt[8:len(pmt)-crc32size]))
+	return nil
+}
+
+type programElementInfoJSON struct {
+	StreamType    byte             `json:"stream_type"`
+	ElementaryPID PID              `json:"elementary_pid"`
+	ESInfoLength  int              `json:"es_info_length"`
+	Descriptors   []descriptorJSON `json:"descriptors"`
+	Raw           hexBytes         `json:"raw,omitempty"`
+}
+
+func newProgramElementInfoJSON(i ProgramElementInfo, raw bool) programElementInfoJSON {
+	return programElementInfoJSON{
+		StreamType:    i.StreamType(),
+		ElementaryPID: i.ElementaryPID(),
+		ESInfoLength:  i.ESInfoLength(),
+		Descriptors:   newDescriptorsJSON(i.Descriptors(), raw),
+		Raw:           rawBytes(i, raw),
+	}
+}
+
+// info returns the program element of v. The raw bytes take precedence.
+func (v programElementInfoJSON) info() ProgramElementInfo {
+	if v.Raw != nil {
+		return ProgramElementInfo(v.Raw)
+	}
+	return BuildProgramElementInfo(v.StreamType, v.ElementaryPID, descriptors(v.Descriptors)...)
+}
+
+// MarshalJSON returns the program element in JSON.
+func (i ProgramElementInfo) MarshalJSON() ([]byte, error) {
+	return marshalJSON(i)
+}
+
+func (i ProgramElementInfo) jsonValue(raw bool) (interface{}, error) {
+	if ok, err := checkJSONSize(i, 5); !ok {
+		return nil, err
+	}
+	return newProgramElementInfoJSON(i, raw), nil
+}
+
+// UnmarshalJSON sets the program element encoded from the JSON by
+// MarshalJSON. The ES_info_length is computed.
+func (i *ProgramElementInfo) UnmarshalJSON(b []byte) error {
+	var v programElementInfoJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*i = v.info()
+	return nil
+}
+
+type descriptorJSON struct {
+	Tag    DescriptorTag `json:"tag"`
+	Length int           `json:"length"`
+	Data   hexBytes      `json:"data"`
+	Raw    hexBytes      `json:"raw,omitempty"`
+}
+
+func newDescriptorJSON(d Descriptor, raw bool) descriptorJSON {
+	return descriptorJSON{
+		Tag:    d.Tag(),
+		Length: d.Length(),
+		Data:   d.Data(),
+		Raw:    rawBytes(d, raw),
+	}
+}
+
+// descriptor returns the descriptor of v. The raw bytes take precedence.
+func (v descriptorJSON) descriptor() Descriptor {
+	if v.Raw != nil {
+		return Descriptor(v.Raw)
+	}
+	return BuildDescriptor(v.Tag, v.Data)
+}
+
+// MarshalJSON returns the descriptor in JSON with the data in hexadecimal.
+func (d Descriptor) MarshalJSON() ([]byte, error) {
+	return marshalJSON(d)
+}
+
+func (d Descriptor) jsonValue(raw bool) (interface{}, error) {
+	if ok, err := checkJSONSize(d, 2); !ok {
+		return nil, err
+	}
+	return newDescriptorJSON(d, raw), nil
+}
+
+// UnmarshalJSON sets the descriptor encoded from the JSON by MarshalJSON.
+// The descriptor_length is computed.
+func (d *Descriptor) UnmarshalJSON(b []byte) error {
+	var v descriptorJSON
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*d = v.descriptor()
+	return nil
+}
+
+// newDescriptorsJSON returns the descriptors in JSON, which is [] for none.
+func newDescriptorsJSON(ds []Descriptor, raw bool) []descriptorJSON {
+	vs := []descriptorJSON{}
+	for _, d := range ds {
+		vs = append(vs, newDescriptorJSON(d, raw))
+	}
+	return vs
+}
+
+// descriptors returns the descriptors of vs.
+func descriptors(vs []descriptorJSON) []Descriptor {
+	var ds []Descriptor
+	for _, v := range vs {
+		ds = append(ds, v.descriptor())
+	}
+	return ds
+}
+
+// marshalJSON returns v in JSON without the raw bytes.
+func marshalJSON(v jsonValuer) ([]byte, error) {
+	jv, err := v.jsonValue(false)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jv)
+}
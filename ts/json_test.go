@@ -0,0 +1,200 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPacketJSON(t *testing.T) {
+	spliced := makeTestAFPacket(0x0100, 3, false, 0x06, -1, make([]byte, 100))
+	spliced[6], spliced[7], spliced[8], spliced[9] = 0xFE, 0x02, 0xAB, 0xCD // splice_countdown, transport_private_data
+	for i, tc := range []struct {
+		p    []byte
+		want []string
+	}{
+		{
+			makeTSPacket(PidPAT, 5, true, []byte{0x00, 0x01}),
+			[]string{`"pid":0`, `"payload_unit_start_indicator":1`, `"continuity_counter":5`, `"payload":"0001ffff`},
+		},
+		{
+			makeTestAFPacket(0x0100, 0, true, 0x40, 27000000, make([]byte, 100)),
+			[]string{`"pid":256`, `"random_access_indicator":1`, `"pcr":27000000`, `"adaptation_field_length":83`},
+		},
+		{
+			spliced,
+			[]string{`"splice_countdown":-2`, `"transport_private_data":"abcd"`},
+		},
+	} {
+		b, err := json.Marshal(Packet(tc.p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range tc.want {
+			if !strings.Contains(string(b), s) {
+				t.Errorf("%0d: MarshalJSON() => %s, want %s in it", i, b, s)
+			}
+		}
+		if strings.Contains(string(b), `"raw"`) {
+			t.Errorf("%0d: MarshalJSON() => %s, want no raw", i, b)
+		}
+		var p Packet
+		if err := json.Unmarshal(b, &p); err != nil {
+			t.Fatalf("%0d: UnmarshalJSON() causes %s", i, err)
+		}
+		if !bytes.Equal(p, tc.p) {
+			t.Errorf("%0d: UnmarshalJSON() => %X, want %X", i, p, tc.p)
+		}
+	}
+
+	var p Packet
+	if err := json.Unmarshal([]byte(`{"pid":256,"adaptation_field_control":1,"payload":"00"}`), &p); err != ErrInvalidPacketSize {
+		t.Errorf("UnmarshalJSON() causes %v, want %s", err, ErrInvalidPacketSize)
+	}
+}
+
+func TestPSIJSON(t *testing.T) {
+	ca := BuildDescriptor(TagCA, []byte{0x00, 0x05, 0xE3, 0x00})
+	cat := buildSection(0x01, 0xFFFF, 3, ca)
+	for i, tc := range []struct {
+		v    interface{}
+		want string
+	}{
+		{
+			BuildPAT(0x0001, 2, map[ProgramNumber]PID{0: 0x0010, 1: 0x1000}),
+			`"programs":[{"program_number":0,"pid":16},{"program_number":1,"pid":4096}]`,
+		},
+		{
+			BuildPMT(1, 0, 0x0100, []Descriptor{ca},
+				BuildProgramElementInfo(StreamTypeH264, 0x0100),
+				BuildProgramElementInfo(StreamTypeAAC, 0x0101, BuildDescriptor(TagISO639Language, []byte("jpn\x00")))),
+			`{"stream_type":15,"elementary_pid":257,"es_info_length":6,"descriptors":[{"tag":10,"length":4,"data":"6a706e00"}]}`,
+		},
+		{
+			CAT(cat),
+			`"version_number":3,"current_next_indicator":1`,
+		},
+	} {
+		b, err := json.Marshal(tc.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), tc.want) {
+			t.Errorf("%0d: MarshalJSON() => %s, want %s in it", i, b, tc.want)
+		}
+		var got, want []byte
+		switch v := tc.v.(type) {
+		case PAT:
+			want = v
+			var pat PAT
+			err = json.Unmarshal(b, &pat)
+			got = pat
+		case PMT:
+			want = v
+			var pmt PMT
+			err = json.Unmarshal(b, &pmt)
+			got = pmt
+		case CAT:
+			want = v
+			var cat CAT
+			err = json.Unmarshal(b, &cat)
+			got = cat
+		default:
+			t.Fatalf("%0d: unexpected %T", i, v)
+		}
+		if err != nil {
+			t.Fatalf("%0d: UnmarshalJSON() causes %s", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%0d: UnmarshalJSON() => %X, want %X", i, got, want)
+		}
+	}
+
+	// edited fixture
+	var pat PAT
+	if err := json.Unmarshal([]byte(`{"transport_stream_id":2,"section_number":1,"last_section_number":1,"programs":[{"program_number":3,"pid":4097}]}`), &pat); err != nil {
+		t.Fatal(err)
+	}
+	if pat.TransportStreamID() != 2 || pat.SectionNumber() != 1 || pat.CurrentNextIndicator() != 1 || pat.ProgramPIDMap()[3] != 0x1001 || !PSI(pat).VerifyCRC32() {
+		t.Errorf("UnmarshalJSON() => %X", []byte(pat))
+	}
+}
+
+func TestRawJSON(t *testing.T) {
+	// descriptor_length inconsistent with the data
+	d := Descriptor{0x0A, 0x02, 0x6A, 0x70, 0x6E, 0x00}
+	b, err := json.Marshal(RawJSON{d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"raw":"0a026a706e00"`) {
+		t.Errorf("MarshalJSON() => %s, want the raw bytes", b)
+	}
+	var got Descriptor
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, d) {
+		t.Errorf("UnmarshalJSON() => %X, want %X", got, d)
+	}
+
+	// the descriptors in the PMT
+	lang := BuildDescriptor(TagISO639Language, []byte("jpn\x00"))
+	pmt := BuildPMT(1, 0, 0x0100, nil, BuildProgramElementInfo(StreamTypeAAC, 0x0101, lang))
+	b, err = json.Marshal(RawJSON{pmt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"raw":"0a046a706e00"`) {
+		t.Errorf("MarshalJSON() => %s, want the raw bytes of the descriptor", b)
+	}
+	var gotPMT PMT
+	if err := json.Unmarshal(b, &gotPMT); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(gotPMT, pmt) {
+		t.Errorf("UnmarshalJSON() => %X, want %X", []byte(gotPMT), []byte(pmt))
+	}
+
+	// not affecting the other calls
+	if b, err := json.Marshal(d); err != nil || strings.Contains(string(b), `"raw"`) {
+		t.Errorf("MarshalJSON() => %s, %v, want no raw", b, err)
+	}
+}
+
+func TestJSONShort(t *testing.T) {
+	// PMT not received yet
+	b, err := json.Marshal(&Program{Number: 1, PID: 0x100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"PMT":null`) {
+		t.Errorf("MarshalJSON() => %s, want the PMT of null", b)
+	}
+
+	for i, v := range []interface{}{Packet{0x47}, PAT{0x00}, CAT{0x01}, PMT{0x02, 0xB0}, ProgramElementInfo{0x1B}, Descriptor{0x0A}} {
+		if _, err := json.Marshal(v); err == nil {
+			t.Errorf("%0d: MarshalJSON(%X) causes no error", i, v)
+		}
+	}
+
+	var p Packet
+	if err := json.Unmarshal([]byte(`{"raw":"4700"}`), &p); err != ErrInvalidPacketSize {
+		t.Errorf("UnmarshalJSON() causes %v, want %v", err, ErrInvalidPacketSize)
+	}
+}
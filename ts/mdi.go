@@ -0,0 +1,251 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// m2tsPacketSize is the size of a packet with TP_extra_header in M2TS.
+const m2tsPacketSize = 4 + packetDefaultSize
+
+// arrivalTimeStampWrap is the wrap of arrival_time_stamp in M2TS.
+const arrivalTimeStampWrap = 1 << 30
+
+// mdiDefaultInterval is the interval of the MDI used for a non-positive one.
+const mdiDefaultInterval = time.Second
+
+// MDI is the Media Delivery Index of RFC 4445 over an interval.
+type MDI struct {
+	Start         time.Duration // arrival time of the start of the interval
+	End           time.Duration // arrival time of the end of the interval
+	Packets       int64         // number of the packets arrived
+	Lost          int64         // number of the packets lost
+	DelayFactor   time.Duration
+	MediaLossRate float64    // packets lost per second
+	InterArrival  *Histogram // by 100 µs from 0
+}
+
+func newMDI(start time.Duration) *MDI {
+	return &MDI{
+		Start:        start,
+		End:          start,
+		InterArrival: NewHistogram(0, 100*time.Microsecond, 200),
+	}
+}
+
+// String returns the MDI in the form of DF:MLR with DF in milliseconds.
+func (m MDI) String() string {
+	return fmt.Sprintf("%.3f:%.3f", m.DelayFactor.Seconds()*1000, m.MediaLossRate)
+}
+
+// MDIAnalyzer analyzes the arrival of the packets and reports the Media
+// Delivery Index of each interval.
+//
+// The packets written by a call of Write are timestamped on arrival, or by
+// their arrival_time_stamp in M2TS. The lost packets are counted by the
+// continuity_counter of each PID. The media rate is measured by the PCRs of
+// the first PCR_PID found unless the bitrate is set, and the Delay Factor is
+// 0 until it is known.
+type MDIAnalyzer struct {
+	interval time.Duration
+	report   func(m MDI)
+	now      func() time.Time
+	clock    *streamClock
+	framer   packetFramer
+	m2ts     bool
+	buf      []byte // partial M2TS packet
+	n        int64
+	origin   time.Time
+	ats      int64 // last arrival_time_stamp
+	atsTicks int64 // arrival_time_stamp unwrapped
+	started  bool
+	last     time.Duration // last arrival
+	pids     map[PID]*continuity
+
+	vb, vbMin, vbMax float64 // virtual buffer in bytes
+	cur              *MDI
+	total            *MDI
+	maxDF            time.Duration
+}
+
+// NewMDIAnalyzer returns a new MDIAnalyzer reporting the MDI to report every
+// interval. The interval of 0 or less is replaced by the default of 1 second.
+func NewMDIAnalyzer(interval time.Duration, report func(m MDI)) *MDIAnalyzer {
+	if interval <= 0 {
+		interval = mdiDefaultInterval
+	}
+	return &MDIAnalyzer{
+		interval: interval,
+		report:   report,
+		now:      time.Now,
+		clock:    newStreamClock(0),
+		pids:     make(map[PID]*continuity),
+		cur:      newMDI(0),
+		total:    newMDI(0),
+	}
+}
+
+// Bitrate sets the media rate in bits per second instead of measuring it.
+// It must be called before writing.
+func (a *MDIAnalyzer) Bitrate(bps int64) {
+	a.clock = newStreamClock(bps)
+}
+
+// M2TS sets whether the stream is M2TS whose packets have TP_extra_header
+// with arrival_time_stamp.
+func (a *MDIAnalyzer) M2TS(m2ts bool) {
+	a.m2ts = m2ts
+}
+
+// Write analyzes the packets in b arrived now. The packet split between the
+// calls is buffered until the rest is written, and arrives with it.
+func (a *MDIAnalyzer) Write(b []byte) (int, error) {
+	if a.m2ts {
+		a.writeM2TS(b)
+		return len(b), nil
+	}
+	now := a.now()
+	if a.origin.IsZero() {
+		a.origin = now
+	}
+	t := now.Sub(a.origin)
+	_, err := a.framer.write(b, func(p Packet) error {
+		a.WritePacketAt(p, t)
+		return nil
+	})
+	return len(b), err
+}
+
+func (a *MDIAnalyzer) writeM2TS(b []byte) {
+	a.buf = append(a.buf, b...)
+	buf := a.buf
+	for len(buf) >= m2tsPacketSize {
+		if buf[4] != SyncByte {
+			i := bytes.IndexByte(buf[5:], SyncByte)
+			if i < 0 {
+				buf = buf[len(buf)-4:]
+				break
+			}
+			buf = buf[i+1:]
+			continue
+		}
+		a.WritePacketAt(Packet(buf[4:m2tsPacketSize]), a.arrivalTime(binary.BigEndian.Uint32(buf)))
+		buf = buf[m2tsPacketSize:]
+	}
+	a.buf = append(a.buf[:0], buf...)
+}
+
+// arrivalTime returns the time of TP_extra_header from the first one.
+func (a *MDIAnalyzer) arrivalTime(header uint32) time.Duration {
+	ats := int64(header & (arrivalTimeStampWrap - 1))
+	if a.started {
+		a.atsTicks += (ats - a.ats + arrivalTimeStampWrap) % arrivalTimeStampWrap
+	}
+	a.ats = ats
+	return ticksToDuration(a.atsTicks)
+}
+
+// WritePacketAt analyzes the packet arrived at t. The packets arrived at the
+// same time are counted as a datagram.
+func (a *MDIAnalyzer) WritePacketAt(p Packet, t time.Duration) {
+	n := a.n
+	a.n++
+	a.clock.packet(p, n)
+	rate := a.clock.ticksPerPacket()
+
+	if !a.started {
+		a.started = true
+		a.last = t
+		a.cur = newMDI(t)
+		a.total = newMDI(t)
+	}
+	for t >= a.cur.Start+a.interval {
+		a.flush(a.cur.Start + a.interval)
+	}
+	if t != a.last {
+		a.cur.InterArrival.Add(t - a.last)
+		a.total.InterArrival.Add(t - a.last)
+		if rate > 0 {
+			a.vb -= (t - a.last).Seconds() * packetDefaultSize * SystemClockFrequency / rate
+		}
+		a.last = t
+	}
+	if rate == 0 {
+		a.vb = 0
+	}
+	a.vbMin = min(a.vbMin, a.vb)
+	a.vb += packetDefaultSize
+	if rate == 0 {
+		a.vb = 0
+	}
+	a.vbMax = max(a.vbMax, a.vb)
+	if rate > 0 {
+		a.cur.DelayFactor = time.Duration((a.vbMax - a.vbMin) * float64(time.Second) * rate / (packetDefaultSize * SystemClockFrequency))
+	}
+	a.cur.Packets++
+	a.total.Packets++
+
+	c, ok := a.pids[p.PID()]
+	if !ok {
+		cc := newContinuity()
+		c = &cc
+		a.pids[p.PID()] = c
+	}
+	inspect(p, n, c, func(e Event) {
+		if e.Type == EventContinuityError {
+			lost := int64(e.Actual-e.Expected+16) % 16
+			a.cur.Lost += lost
+			a.total.Lost += lost
+		}
+	})
+}
+
+// flush reports the MDI of the interval ending at end.
+func (a *MDIAnalyzer) flush(end time.Duration) {
+	m := a.cur
+	m.End = end
+	if end > m.Start {
+		m.MediaLossRate = float64(m.Lost) / (end - m.Start).Seconds()
+	}
+	a.maxDF = max(a.maxDF, m.DelayFactor)
+	if m.Packets > 0 && a.report != nil {
+		a.report(*m)
+	}
+	a.cur = newMDI(end)
+	a.vbMin, a.vbMax = a.vb, a.vb
+}
+
+// Flush reports the MDI of the interval in progress.
+func (a *MDIAnalyzer) Flush() {
+	if a.cur.Packets > 0 {
+		a.flush(a.last)
+	}
+}
+
+// Stats returns the MDI since the first packet. Its Delay Factor is the
+// maximum of the intervals.
+func (a *MDIAnalyzer) Stats() MDI {
+	m := *a.total
+	m.End = a.last
+	m.DelayFactor = max(a.maxDF, a.cur.DelayFactor)
+	if m.End > m.Start {
+		m.MediaLossRate = float64(m.Lost) / (m.End - m.Start).Seconds()
+	}
+	return m
+}
@@ -0,0 +1,143 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestMDIAnalyzer(t *testing.T) {
+	abs := func(d time.Duration) time.Duration {
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+	for i, tc := range []struct {
+		f       func(i int, p []byte) []byte
+		m2ts    bool
+		reports int
+		df      time.Duration
+		lost    int64
+	}{
+		// datagrams of 7 packets every 7 ms
+		{nil, false, 3, 7 * time.Millisecond, 0},
+		{
+			func(i int, p []byte) []byte {
+				if i == 503 || i == 1503 || i == 1504 {
+					return nil
+				}
+				return p
+			},
+			false, 3, 7 * time.Millisecond, 3,
+		},
+		// packets every 1 ms
+		{nil, true, 3, time.Millisecond, 0},
+	} {
+		stream := makeTestMonitorStream(2100, true, tc.f)
+		var reports []MDI
+		a := NewMDIAnalyzer(time.Second, func(m MDI) {
+			reports = append(reports, m)
+		})
+		a.Bitrate(1504000)
+		origin := time.Now()
+		var now time.Time
+		a.now = func() time.Time {
+			return now
+		}
+		if tc.m2ts {
+			a.M2TS(true)
+			var b []byte
+			for j := 0; j*packetDefaultSize < len(stream); j++ {
+				// wraps at 1.5 s
+				ats := uint32(arrivalTimeStampWrap - 1500*27000 + j*27000)
+				b = binary.BigEndian.AppendUint32(b, ats%arrivalTimeStampWrap)
+				b = append(b, stream[j*packetDefaultSize:(j+1)*packetDefaultSize]...)
+			}
+			// split in the middle of the packets
+			for len(b) > 0 {
+				k := min(1000, len(b))
+				if _, err := a.Write(b[:k]); err != nil {
+					t.Fatal(err)
+				}
+				b = b[k:]
+			}
+		} else {
+			for j := 0; len(stream) > 0; j++ {
+				now = origin.Add(time.Duration(j) * 7 * time.Millisecond)
+				k := min(7*packetDefaultSize, len(stream))
+				if _, err := a.Write(stream[:k]); err != nil {
+					t.Fatal(err)
+				}
+				stream = stream[k:]
+			}
+		}
+		a.Flush()
+
+		if len(reports) != tc.reports {
+			t.Fatalf("%0d: reported %d intervals, want %d", i, len(reports), tc.reports)
+		}
+		var lost, packets int64
+		for j, r := range reports {
+			if r.Start != time.Duration(j)*time.Second {
+				t.Errorf("%0d: reports[%d].Start => %s, want %s", i, j, r.Start, time.Duration(j)*time.Second)
+			}
+			// the lost packets delay the following ones
+			if tc.lost == 0 && abs(r.DelayFactor-tc.df) > time.Microsecond {
+				t.Errorf("%0d: reports[%d].DelayFactor => %s, want %s", i, j, r.DelayFactor, tc.df)
+			}
+			if h := r.InterArrival; h.Total() == 0 || h.Counts[tc.df/h.Width] != h.Total() {
+				t.Errorf("%0d: reports[%d].InterArrival => %v, want at %s", i, j, r.InterArrival.Counts, tc.df)
+			}
+			lost += r.Lost
+			packets += r.Packets
+		}
+		if lost != tc.lost {
+			t.Errorf("%0d: reported %d lost packets, want %d", i, lost, tc.lost)
+		}
+		s := a.Stats()
+		if s.Lost != tc.lost || s.Packets != packets {
+			t.Errorf("%0d: Stats() => %d lost in %d, want %d in %d", i, s.Lost, s.Packets, tc.lost, packets)
+		}
+		if tc.lost == 0 && abs(s.DelayFactor-tc.df) > time.Microsecond {
+			t.Errorf("%0d: Stats().DelayFactor => %s, want %s", i, s.DelayFactor, tc.df)
+		}
+	}
+}
+
+func TestMDIString(t *testing.T) {
+	m := MDI{DelayFactor: 7500 * time.Microsecond, MediaLossRate: 2}
+	if s := m.String(); s != "7.500:2.000" {
+		t.Errorf("String() => %s, want %s", s, "7.500:2.000")
+	}
+}
+
+func TestMDIAnalyzerInterval(t *testing.T) {
+	stream := makeTestMonitorStream(100, true, nil)
+	for i, interval := range []time.Duration{0, -time.Second} {
+		a := NewMDIAnalyzer(interval, nil)
+		if a.interval != time.Second {
+			t.Errorf("%0d: NewMDIAnalyzer(%v) => interval %v, want %v", i, interval, a.interval, time.Second)
+		}
+		for j := 0; j*packetDefaultSize < len(stream); j++ {
+			a.WritePacketAt(Packet(stream[j*packetDefaultSize:(j+1)*packetDefaultSize]), time.Duration(j)*time.Millisecond)
+		}
+		if m := a.Stats(); m.Packets != 100 {
+			t.Errorf("%0d: Stats().Packets => %d, want %d", i, m.Packets, 100)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MonitorConfig is the configuration of the Monitor. The zero values are
+// replaced by the defaults.
+type MonitorConfig struct {
+	// Bitrate is the constant bitrate in bits per second to time the packets.
+	// The default 0 times them by the PCRs.
+	Bitrate int64
+
+	// SyncLossThreshold is the number of the consecutive corrupted sync bytes
+	// to lose the sync. The default is 2.
+	SyncLossThreshold int
+
+	// SyncAcquireThreshold is the number of the consecutive correct sync
+	// bytes to acquire the sync. The default is 5.
+	SyncAcquireThreshold int
+
+	// PATInterval is the maximum interval of the PAT. The default is 500 ms.
+	PATInterval time.Duration
+
+	// PMTInterval is the maximum interval of the PMTs. The default is 500 ms.
+	PMTInterval time.Duration
+
+	// PIDInterval is the maximum interval of the packets of the PIDs referred
+	// by the PMTs. The default is 5 s.
+	PIDInterval time.Duration
+
+	// PCRInterval is the maximum interval of the PCRs of a PID. The default
+	// is 40 ms.
+	PCRInterval time.Duration
+
+	// PCRDiscontinuity is the maximum difference of the consecutive PCRs
+	// without the discontinuity_indicator. The default is 100 ms.
+	PCRDiscontinuity time.Duration
+
+	// PCRAccuracy is the maximum error of the PCRs from the time of the
+	// packets. The default is 500 ns.
+	PCRAccuracy time.Duration
+
+	// PTSInterval is the maximum interval of the PTSs of a PID. The default
+	// is 700 ms.
+	PTSInterval time.Duration
+
+	// CheckSI enables the priority 3 checks of the repetition of the DVB SI.
+	CheckSI bool
+
+	// NITInterval, SDTInterval, EITInterval and TDTInterval are the maximum
+	// intervals of the NIT, the SDT and the EIT present/following of the
+	// actual transport stream, and of the TDT. The defaults are 10 s, 2 s,
+	// 2 s and 30 s.
+	NITInterval time.Duration
+	SDTInterval time.Duration
+	EITInterval time.Duration
+	TDTInterval time.Duration
+}
+
+func (c *MonitorConfig) setDefaults() {
+	setDefault := func(v *time.Duration, d time.Duration) {
+		if *v <= 0 {
+			*v = d
+		}
+	}
+	if c.SyncLossThreshold <= 0 {
+		c.SyncLossThreshold = 2
+	}
+	if c.SyncAcquireThreshold <= 0 {
+		c.SyncAcquireThreshold = 5
+	}
+	setDefault(&c.PATInterval, 500*time.Millisecond)
+	setDefault(&c.PMTInterval, 500*time.Millisecond)
+	setDefault(&c.PIDInterval, 5*time.Second)
+	setDefault(&c.PCRInterval, 40*time.Millisecond)
+	setDefault(&c.PCRDiscontinuity, 100*time.Millisecond)
+	setDefault(&c.PCRAccuracy, 500*time.Nanosecond)
+	setDefault(&c.PTSInterval, 700*time.Millisecond)
+	setDefault(&c.NITInterval, 10*time.Second)
+	setDefault(&c.SDTInterval, 2*time.Second)
+	setDefault(&c.EITInterval, 2*time.Second)
+	setDefault(&c.TDTInterval, 30*time.Second)
+}
+
+// Monitor checks the stream written for the errors of ETSI TR 101 290, and
+// emits them to the Observer with the index and the time of the packet.
+//
+// The priority 1 errors are reported as EventSyncLoss, EventSyncByteError,
+// EventPATError, EventContinuityError, EventPMTError and EventPIDError. The
+// priority 2 errors are reported as EventTransportError, EventCRCError,
+// EventPCRRepetitionError, EventPCRDiscontinuityError,
+// EventPCRAccuracyError, EventPTSError and EventCATError, and the priority 3
+// errors of the SI repetition as EventNITError, EventSDTError, EventEITError
+// and EventTDTError if enabled.
+//
+// The packets are not checked until the sync is acquired. The time of the
+// packets is counted from the first PCR by the PCRs of the first PCR_PID, or
+// by the constant bitrate if it is configured.
+type Monitor struct {
+	c        MonitorConfig
+	observer Observer
+	clock    *streamClock
+	d        *Demuxer
+
+	buf       []byte
+	synced    bool
+	corrupted int           // consecutive corrupted sync bytes
+	n         int64         // index of the next packet
+	now       time.Duration // time of the current packet
+
+	continuity map[PID]*continuity
+	timers     map[monitorTimerKey]*monitorTimer
+	subscribed map[PID]bool // PIDs of the sections subscribed
+	pcrs       map[PID]monitorPCR
+	cat        bool // whether the CAT is received
+	scrambled  bool // whether CAT_error is reported for the scrambled packets
+}
+
+// monitorTimer emits the error unless reset within the limit.
+type monitorTimer struct {
+	last  time.Duration
+	limit time.Duration
+	what  string
+}
+
+type monitorTimerKey struct {
+	t   EventType
+	pid PID
+}
+
+// monitorPCR is the last PCR of a PID.
+type monitorPCR struct {
+	n   int64
+	pcr int64
+}
+
+// NewMonitor returns a new Monitor emitting the errors to o.
+func NewMonitor(c MonitorConfig, o Observer) *Monitor {
+	c.setDefaults()
+	m := &Monitor{
+		c:          c,
+		observer:   o,
+		clock:      newStreamClock(c.Bitrate),
+		d:          NewDemuxer(),
+		continuity: make(map[PID]*continuity),
+		timers:     make(map[monitorTimerKey]*monitorTimer),
+		subscribed: make(map[PID]bool),
+		pcrs:       make(map[PID]monitorPCR),
+	}
+	m.d.HandleSection(PidPAT, m.handlePAT)
+	m.d.HandleSection(PidCAT, m.handleCAT)
+	m.d.HandleProgram(m.handleProgram)
+	m.start(EventPATError, PidPAT, c.PATInterval, "PAT")
+	for _, pid := range []PID{PidNIT, PidSDT, PidEIT, PidTDT} {
+		m.subscribe(pid, m.handleSI)
+	}
+	if c.CheckSI {
+		m.start(EventNITError, PidNIT, c.NITInterval, "NIT actual")
+		m.start(EventSDTError, PidSDT, c.SDTInterval, "SDT actual")
+		m.start(EventEITError, PidEIT, c.EITInterval, "EIT present/following actual")
+		m.start(EventTDTError, PidTDT, c.TDTInterval, "TDT")
+	}
+	return m
+}
+
+// Write checks the stream in b. The packet split between the calls is
+// buffered until the rest is written. The packet which can not be parsed, e.g.
+// by the adaptation_field_length, is reported by EventAdaptationFieldError.
+func (m *Monitor) Write(b []byte) (int, error) {
+	m.buf = append(m.buf, b...)
+	pos := 0
+	for {
+		if !m.synced {
+			i, ok := m.acquire(m.buf[pos:])
+			pos += i
+			if !ok {
+				break
+			}
+			m.synced = true
+			m.corrupted = 0
+		}
+		if len(m.buf)-pos < packetDefaultSize {
+			break
+		}
+		m.packet(Packet(m.buf[pos : pos+packetDefaultSize]))
+		pos += packetDefaultSize
+	}
+	m.buf = append(m.buf[:0], m.buf[pos:]...)
+	return len(b), nil
+}
+
+// acquire finds the sync in b. It returns the position of the sync, or of the
+// data to search with the rest to be written if not found.
+func (m *Monitor) acquire(b []byte) (int, bool) {
+	span := (m.c.SyncAcquireThreshold-1)*packetDefaultSize + 1
+	i := 0
+	for ; i+span <= len(b); i++ {
+		ok := true
+		for k := 0; k < m.c.SyncAcquireThreshold; k++ {
+			if b[i+k*packetDefaultSize] != SyncByte {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return i, true
+		}
+	}
+	return i, false
+}
+
+// emit emits the event at the current packet.
+func (m *Monitor) emit(e Event) {
+	e.Time = m.now
+	m.observer.observe(e)
+}
+
+// event returns a new event at the current packet.
+func (m *Monitor) event(t EventType, pid PID, format string, a ...interface{}) Event {
+	return Event{Type: t, PID: pid, Packet: m.n - 1, Detail: fmt.Sprintf(format, a...)}
+}
+
+// packet checks the packet while synced.
+func (m *Monitor) packet(p Packet) {
+	n := m.n
+	m.n++
+	pid := p.PID()
+	if p.SyncByte() != SyncByte {
+		m.now = m.clock.time(n)
+		m.emit(m.event(EventSyncByteError, pid, "sync_byte 0x%02X", p.SyncByte()))
+		m.corrupted++
+		if m.corrupted >= m.c.SyncLossThreshold {
+			m.emit(m.event(EventSyncLoss, pid, "%d consecutive corrupted sync bytes", m.corrupted))
+			m.synced = false
+		}
+		return
+	}
+	m.corrupted = 0
+	m.clock.packet(p, n)
+	m.now = m.clock.time(n)
+
+	if p.HasTransportError() {
+		m.emit(m.event(EventTransportError, pid, "transport_error_indicator"))
+	}
+	c, ok := m.continuity[pid]
+	if !ok {
+		c = &continuity{}
+		*c = newContinuity()
+		m.continuity[pid] = c
+	}
+	if _, _, err := inspect(p, n, c, m.emit); err != nil {
+		// the rest of the packet is unknown
+		m.emit(m.event(EventAdaptationFieldError, pid, "%s", err))
+		m.reset(EventPIDError, pid)
+		m.checkTimers()
+		return
+	}
+
+	if p.TransportScramblingControl() != 0 {
+		switch {
+		case pid == PidPAT:
+			m.emit(m.event(EventPATError, pid, "scrambled"))
+		case m.timers[monitorTimerKey{EventPMTError, pid}] != nil:
+			m.emit(m.event(EventPMTError, pid, "scrambled"))
+		case !m.cat && !m.scrambled:
+			m.emit(m.event(EventCATError, pid, "scrambled without CAT"))
+			m.scrambled = true
+		}
+	}
+	m.reset(EventPIDError, pid)
+	m.checkPCR(p, n)
+	m.checkPTS(p)
+	m.d.WritePacket(p)
+	m.checkTimers()
+}
+
+// checkPCR checks the PCR of the n-th packet.
+func (m *Monitor) checkPCR(p Packet, n int64) {
+	af, _ := p.AdaptationField()
+	if len(af) < 8 || !af.HasPCR() {
+		return
+	}
+	pid := p.PID()
+	pcr := af.PCR().Value()
+	prev, ok := m.pcrs[pid]
+	m.pcrs[pid] = monitorPCR{n: n, pcr: pcr}
+	m.start(EventPCRRepetitionError, pid, m.c.PCRInterval, "PCR")
+	m.reset(EventPCRRepetitionError, pid)
+	if !ok || af.IsDiscontinuous() {
+		return
+	}
+
+	d := (pcr - prev.pcr + clockReferenceWrap) % clockReferenceWrap
+	if d > clockReferenceWrap/2 {
+		m.emit(m.event(EventPCRDiscontinuityError, pid, "PCR decreased by %v", ticksToDuration(clockReferenceWrap-d)))
+		return
+	}
+	if limit := m.c.PCRDiscontinuity; ticksToDuration(d) > limit {
+		m.emit(m.event(EventPCRDiscontinuityError, pid, "PCR increased by %v", ticksToDuration(d)))
+		return
+	}
+	if rate := m.clock.ticksPerPacket(); rate > 0 {
+		jitter := float64(d) - float64(n-prev.n)*rate
+		if jitter < 0 {
+			jitter = -jitter
+		}
+		if e := time.Duration(jitter * 1000 / 27); e > m.c.PCRAccuracy {
+			m.emit(m.event(EventPCRAccuracyError, pid, "PCR inaccurate by %v", e))
+		}
+	}
+}
+
+// checkPTS checks the PTS of the PES packet starting in the packet.
+func (m *Monitor) checkPTS(p Packet) {
+	if !p.IsPayloadUnitStart() {
+		return
+	}
+	pes, err := NewPES(p.Payload())
+	if err != nil || !pes.HasPTS() {
+		return
+	}
+	m.start(EventPTSError, p.PID(), m.c.PTSInterval, "PTS")
+	m.reset(EventPTSError, p.PID())
+}
+
+// start starts the timer unless started.
+func (m *Monitor) start(t EventType, pid PID, limit time.Duration, what string) {
+	k := monitorTimerKey{t, pid}
+	if _, ok := m.timers[k]; !ok {
+		m.timers[k] = &monitorTimer{last: m.now, limit: limit, what: what}
+	}
+}
+
+// reset resets the timer if started.
+func (m *Monitor) reset(t EventType, pid PID) {
+	if tm, ok := m.timers[monitorTimerKey{t, pid}]; ok {
+		tm.last = m.now
+	}
+}
+
+// checkTimers emits the errors of the timers expired in order of the event
+// type and the PID, and resets them.
+func (m *Monitor) checkTimers() {
+	var expired []monitorTimerKey
+	for k, tm := range m.timers {
+		if m.now-tm.last > tm.limit {
+			expired = append(expired, k)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if expired[i].t != expired[j].t {
+			return expired[i].t < expired[j].t
+		}
+		return expired[i].pid < expired[j].pid
+	})
+	for _, k := range expired {
+		tm := m.timers[k]
+		m.emit(m.event(k.t, k.pid, "no %s for %v", tm.what, m.now-tm.last))
+		tm.last = m.now
+	}
+}
+
+// subscribe subscribes the sections of the PID unless subscribed.
+func (m *Monitor) subscribe(pid PID, h SectionHandler) {
+	if !m.subscribed[pid] {
+		m.subscribed[pid] = true
+		m.d.HandleSection(pid, h)
+	}
+}
+
+// checkCRC reports whether the CRC_32 of the section is valid, emitting
+// CRC_error if not. The sections without the CRC_32 are valid.
+func (m *Monitor) checkCRC(rx *SectionReceiver) bool {
+	b := PSI(rx.Bytes())
+	if b.SectionSyntaxIndicator() == 0 && b.TableID() != TableIDTOT {
+		return true
+	}
+	if b.VerifyCRC32() {
+		return true
+	}
+	m.emit(m.event(EventCRCError, rx.PID, "table_id 0x%02X", b.TableID()))
+	return false
+}
+
+func (m *Monitor) handlePAT(rx *SectionReceiver) error {
+	if !m.checkCRC(rx) {
+		return nil
+	}
+	id := PSI(rx.Bytes()).TableID()
+	if id != 0x00 {
+		m.emit(m.event(EventPATError, PidPAT, "table_id 0x%02X", id))
+		return nil
+	}
+	m.reset(EventPATError, PidPAT)
+
+	pmts := make(map[PID]bool)
+	for _, pg := range m.d.Programs() {
+		pmts[pg.PID] = true
+		m.start(EventPMTError, pg.PID, m.c.PMTInterval, "PMT")
+		m.subscribe(pg.PID, m.handlePMT)
+	}
+	for k := range m.timers {
+		if k.t == EventPMTError && !pmts[k.pid] {
+			delete(m.timers, k)
+		}
+	}
+	if pat, err := NewPAT(rx.Bytes()); err == nil {
+		if pid, err := pat.NetworkPID(); err == nil {
+			m.subscribe(pid, m.handleSI)
+		}
+	}
+	return nil
+}
+
+func (m *Monitor) handlePMT(rx *SectionReceiver) error {
+	if m.checkCRC(rx) && PSI(rx.Bytes()).TableID() == 0x02 {
+		m.reset(EventPMTError, rx.PID)
+	}
+	return nil
+}
+
+func (m *Monitor) handleCAT(rx *SectionReceiver) error {
+	if !m.checkCRC(rx) {
+		return nil
+	}
+	if id := PSI(rx.Bytes()).TableID(); id != 0x01 {
+		m.emit(m.event(EventCATError, PidCAT, "table_id 0x%02X", id))
+		return nil
+	}
+	m.cat = true
+	return nil
+}
+
+func (m *Monitor) handleSI(rx *SectionReceiver) error {
+	if !m.checkCRC(rx) {
+		return nil
+	}
+	switch PSI(rx.Bytes()).TableID() {
+	case TableIDNITActual:
+		m.reset(EventNITError, PidNIT)
+	case TableIDSDTActual:
+		m.reset(EventSDTError, PidSDT)
+	case TableIDEITActual:
+		m.reset(EventEITError, PidEIT)
+	case TableIDTDT:
+		m.reset(EventTDTError, PidTDT)
+	}
+	return nil
+}
+
+func (m *Monitor) handleProgram(*Program) error {
+	referred := make(map[PID]bool)
+	for _, pg := range m.d.Programs() {
+		for _, st := range pg.Streams {
+			referred[st.PID] = true
+			m.start(EventPIDError, st.PID, m.c.PIDInterval, "packet")
+		}
+	}
+	for k := range m.timers {
+		if k.t == EventPIDError && !referred[k.pid] {
+			delete(m.timers, k)
+		}
+	}
+	return nil
+}
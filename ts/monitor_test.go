@@ -0,0 +1,295 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"testing"
+	"time"
+)
+
+// makeTestMonitorStream makes the stream of n packets of 1 ms at 1504 kbps,
+// having the PAT and the PMT every 100 ms and the video otherwise. If timed,
+// the video has the PCR every 10 ms and the PTS every 40 ms. The packets are
+// replaced by f.
+func makeTestMonitorStream(n int, timed bool, f func(i int, p []byte) []byte) []byte {
+	pat := BuildPAT(0x0001, 0, map[ProgramNumber]PID{1: 0x1000})
+	pmt := BuildPMT(1, 0, 0x0100, nil, BuildProgramElementInfo(StreamTypeH264, 0x0100))
+	cc := make(map[PID]uint8)
+	var stream []byte
+	for i := 0; i < n; i++ {
+		var p []byte
+		switch i % 100 {
+		case 0:
+			p = makeTSPacket(PidPAT, cc[PidPAT], true, append([]byte{0x00}, pat...))
+			cc[PidPAT]++
+		case 1:
+			p = makeTSPacket(0x1000, cc[0x1000], true, append([]byte{0x00}, pmt...))
+			cc[0x1000]++
+		case 5, 15, 25, 35, 45, 55, 65, 75, 85, 95:
+			if timed {
+				p = makeTestAFPacket(0x0100, cc[0x0100], false, 0x00, int64(i)*27000, make([]byte, 100))
+				cc[0x0100]++
+				break
+			}
+			fallthrough
+		default:
+			if timed && i%40 == 2 {
+				p = makeTSPacket(0x0100, cc[0x0100], true, makeTestPESWithPTS(0xE0, int64(i)*90, 184))
+			} else {
+				p = makeTSPacket(0x0100, cc[0x0100], false, make([]byte, 184))
+			}
+			cc[0x0100]++
+		}
+		if f != nil {
+			p = f(i, p)
+		}
+		stream = append(stream, p...)
+	}
+	return stream
+}
+
+func TestMonitor(t *testing.T) {
+	type result struct {
+		Type   EventType
+		PID    PID
+		Packet int64
+		Time   time.Duration
+	}
+	for i, tc := range []struct {
+		f    func(i int, p []byte) []byte
+		want []result
+	}{
+		{nil, nil},
+		{
+			func(i int, p []byte) []byte {
+				if i == 350 {
+					p[0] = 0x00
+				}
+				return p
+			},
+			[]result{
+				{EventSyncByteError, 0x0100, 350, 350 * time.Millisecond},
+				{EventContinuityError, 0x0100, 351, 351 * time.Millisecond},
+			},
+		},
+		{
+			func(i int, p []byte) []byte {
+				if i == 350 || i == 351 {
+					p[0] = 0x00
+				}
+				return p
+			},
+			[]result{
+				{EventSyncByteError, 0x0100, 350, 350 * time.Millisecond},
+				{EventSyncByteError, 0x0100, 351, 351 * time.Millisecond},
+				{EventSyncLoss, 0x0100, 351, 351 * time.Millisecond},
+				{EventContinuityError, 0x0100, 352, 352 * time.Millisecond},
+			},
+		},
+		{
+			func(i int, p []byte) []byte {
+				if 200 <= i && i < 900 && Packet(p).PID() == PidPAT {
+					return nullPacket()
+				}
+				return p
+			},
+			[]result{
+				{EventPATError, PidPAT, 601, 601 * time.Millisecond},
+				{EventContinuityError, PidPAT, 900, 900 * time.Millisecond},
+			},
+		},
+		{
+			func(i int, p []byte) []byte {
+				switch i {
+				case 200:
+					p[5] = 0x42 // table_id
+					sec := PSI(p[5:])
+					PSI(sec[:3+sec.SectionLength()]).UpdateCRC32()
+				case 300:
+					p[3] |= 0x80 // transport_scrambling_control
+				case 401:
+					p[3] |= 0x80
+				}
+				return p
+			},
+			[]result{
+				{EventPATError, PidPAT, 200, 200 * time.Millisecond},
+				{EventPATError, PidPAT, 300, 300 * time.Millisecond},
+				{EventPMTError, 0x1000, 401, 401 * time.Millisecond},
+			},
+		},
+		{
+			func(i int, p []byte) []byte {
+				if i == 550 || i > 1000 && i < 2300 && Packet(p).PID() == 0x0100 {
+					return nullPacket()
+				}
+				return p
+			},
+			[]result{
+				{EventContinuityError, 0x0100, 551, 551 * time.Millisecond},
+				{EventPIDError, 0x0100, 2000, 2000 * time.Millisecond},
+				{EventContinuityError, 0x0100, 2302, 2302 * time.Millisecond},
+			},
+		},
+	} {
+		var got []result
+		m := NewMonitor(MonitorConfig{Bitrate: 1504000, PIDInterval: time.Second}, func(e Event) {
+			got = append(got, result{e.Type, e.PID, e.Packet, e.Time})
+		})
+		stream := makeTestMonitorStream(3000, false, tc.f)
+		for pos := 0; pos < len(stream); pos += 1000 {
+			end := pos + 1000
+			if end > len(stream) {
+				end = len(stream)
+			}
+			m.Write(stream[pos:end])
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%0d: events => %v, want %v", i, got, tc.want)
+			continue
+		}
+		for j := range got {
+			if got[j] != tc.want[j] {
+				t.Errorf("%0d: event %d => %v, want %v", i, j, got[j], tc.want[j])
+			}
+		}
+	}
+}
+
+func TestMonitorPriority2(t *testing.T) {
+	type result struct {
+		Type   EventType
+		PID    PID
+		Packet int64
+	}
+	for i, tc := range []struct {
+		checkSI bool
+		f       func(i int, p []byte) []byte
+		want    []result
+	}{
+		{false, nil, nil},
+		{
+			false,
+			func(i int, p []byte) []byte {
+				switch i {
+				case 250:
+					p[1] |= 0x80 // transport_error_indicator
+				case 301:
+					p[10] ^= 0xFF
+				case 810:
+					p[3] |= 0x80 // transport_scrambling_control
+				case 910:
+					p[3] |= 0xC0
+				}
+				return p
+			},
+			[]result{
+				{EventTransportError, 0x0100, 250},
+				{EventCRCError, 0x1000, 301},
+				{EventCATError, 0x0100, 810},
+			},
+		},
+		{
+			false,
+			func(i int, p []byte) []byte {
+				if 500 <= i && i < 550 && i%10 == 5 {
+					p[3] = p[3]&0xCF | 0x10 // no adaptation field
+				}
+				return p
+			},
+			[]result{{EventPCRRepetitionError, 0x0100, 536}},
+		},
+		{
+			false,
+			func(i int, p []byte) []byte {
+				if i == 705 {
+					copy(p[6:12], encodeTestPCR(int64(i+200)*27000))
+				}
+				return p
+			},
+			[]result{
+				{EventPCRDiscontinuityError, 0x0100, 705},
+				{EventPCRDiscontinuityError, 0x0100, 715},
+			},
+		},
+		{
+			false,
+			func(i int, p []byte) []byte {
+				if i == 705 {
+					copy(p[6:12], encodeTestPCR(int64(i)*27000+27))
+				}
+				return p
+			},
+			[]result{
+				{EventPCRAccuracyError, 0x0100, 705},
+				{EventPCRAccuracyError, 0x0100, 715},
+			},
+		},
+		{
+			false,
+			func(i int, p []byte) []byte {
+				if 1000 <= i && i < 1800 && i%40 == 2 {
+					p[1] &^= 0x40 // payload_unit_start_indicator
+				}
+				return p
+			},
+			[]result{{EventPTSError, 0x0100, 1663}},
+		},
+		{
+			true,
+			nil,
+			[]result{
+				{EventSDTError, PidSDT, 2001},
+				{EventEITError, PidEIT, 2001},
+			},
+		},
+	} {
+		var got []result
+		m := NewMonitor(MonitorConfig{Bitrate: 1504000, CheckSI: tc.checkSI}, func(e Event) {
+			got = append(got, result{e.Type, e.PID, e.Packet})
+		})
+		m.Write(makeTestMonitorStream(3000, true, tc.f))
+		if len(got) != len(tc.want) {
+			t.Errorf("%0d: events => %v, want %v", i, got, tc.want)
+			continue
+		}
+		for j := range got {
+			if got[j] != tc.want[j] {
+				t.Errorf("%0d: event %d => %v, want %v", i, j, got[j], tc.want[j])
+			}
+		}
+	}
+}
+
+func TestMonitorBrokenAdaptationField(t *testing.T) {
+	// packet of the PAT
+	stream := makeTestMonitorStream(300, false, func(i int, p []byte) []byte {
+		if i == 200 {
+			p[3] |= 0x30 // adaptation_field_control
+			p[4] = 200   // adaptation_field_length
+		}
+		return p
+	})
+	var got []Event
+	m := NewMonitor(MonitorConfig{Bitrate: 1504000}, func(e Event) {
+		got = append(got, e)
+	})
+	if n, err := m.Write(stream); n != len(stream) || err != nil {
+		t.Errorf("Write() => %d, %v, want %d, nil", n, err, len(stream))
+	}
+	if len(got) != 1 || got[0].Type != EventAdaptationFieldError || got[0].PID != PidPAT || got[0].Packet != 200 {
+		t.Errorf("events => %+v, want EventAdaptationFieldError of the packet 200", got)
+	}
+}
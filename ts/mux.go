@@ -0,0 +1,339 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrTooManyPrograms is returned when no more program can be added.
+var ErrTooManyPrograms = errors.New("ts: too many programs")
+
+const (
+	muxPSIInterval = SystemClockFrequency / 10 // interval of the PAT, PMTs and SDT
+	muxPIDStep     = 0x0100                    // PIDs for a program
+	muxMaxPrograms = 0x1E                      // programs whose PIDs fit in 0x0100 .. 0x1EFF
+)
+
+// Muxer combines single program transport streams(SPTS) into a multi program
+// transport stream(MPTS).
+//
+// The k-th program added has the program_number k, the program_map_PID of
+// k*0x100 and the PIDs of its PCR, elementary streams and ECMs from k*0x100+1
+// in order of the PMT. The packets of the inputs are timed by their PCRs and
+// sent in order of the time, and the PAT, the PMTs and the SDT are sent every
+// 100 ms. The inputs must carry the PCR.
+//
+// If the bitrate is set, the output is padded to the constant bitrate with
+// the null packets. The PCRs are restamped by the time they are sent at, and
+// the PTSs and the DTSs of the program are delayed by the largest offset of
+// its PCRs restamped so far, so that no access unit arrives later to the
+// decoder than in the input.
+type Muxer struct {
+	tsid    TransportStreamID
+	bitrate int64
+	sdt     bool
+	onid    uint16
+	inputs  []*muxInput
+}
+
+// NewMuxer returns a new Muxer of the transport_stream_id.
+func NewMuxer(tsid TransportStreamID) *Muxer {
+	return &Muxer{tsid: tsid}
+}
+
+// Bitrate sets the constant bitrate of the output in bits per second. The
+// default 0 sends the packets without padding.
+func (m *Muxer) Bitrate(bps int64) {
+	m.bitrate = bps
+}
+
+// SDT enables the SDT of the original_network_id, which has the services of
+// the programs.
+func (m *Muxer) SDT(onid uint16) {
+	m.sdt = true
+	m.onid = onid
+}
+
+// AddProgram adds the first program of the stream from r, and returns the
+// program_number assigned. The name is the service_name in the SDT.
+func (m *Muxer) AddProgram(r io.Reader, name string) (ProgramNumber, error) {
+	if len(m.inputs) >= muxMaxPrograms {
+		return 0, ErrTooManyPrograms
+	}
+	in := &muxInput{
+		number: ProgramNumber(len(m.inputs) + 1),
+		name:   name,
+		pr:     NewPacketReader(r),
+		d:      NewDemuxer(),
+		pids:   make(map[PID]PID),
+		pcrPID: PidNull,
+		pcr:    -1,
+	}
+	in.d.HandleProgram(in.handleProgram)
+	m.inputs = append(m.inputs, in)
+	return in.number, nil
+}
+
+// Mux writes the multiplexed stream to w until all the inputs end. It returns
+// ErrNoProgram if an input has no PMT before the first PCR.
+func (m *Muxer) Mux(w io.Writer) error {
+	programs := make(map[ProgramNumber]PID)
+	var services []SDTService
+	for _, in := range m.inputs {
+		if err := in.fill(); err != nil {
+			return err
+		}
+		if in.pmt == nil {
+			return ErrNoProgram
+		}
+		programs[in.number] = in.pmtPID()
+		services = append(services, BuildSDTService(in.number,
+			BuildServiceDescriptor(ServiceTypeDigitalTelevision, "", in.name)))
+	}
+	pat := BuildPAT(m.tsid, 0, programs)
+	sdt := BuildSDT(m.tsid, m.onid, 0, services)
+	patpz := NewPacketizer(PidPAT)
+	sdtpz := NewPacketizer(PidSDT)
+	pmtpz := make([]*Packetizer, len(m.inputs))
+	for i, in := range m.inputs {
+		pmtpz[i] = NewPacketizer(in.pmtPID())
+	}
+	null := nullPacket()
+
+	var psi []Packet
+	var now, next int64 // time of the output and of the next PSI
+	for slot := int64(1); ; slot++ {
+		in, err := m.earliest()
+		if err != nil {
+			return err
+		}
+		if in == nil && len(psi) == 0 {
+			return nil
+		}
+		if m.bitrate == 0 && in != nil && in.queue[0].t > now {
+			now = in.queue[0].t
+		}
+		if now >= next {
+			psi = append(psi, patpz.Section(pat)...)
+			for i, in := range m.inputs {
+				psi = append(psi, pmtpz[i].Section(in.pmt)...)
+			}
+			if m.sdt {
+				psi = append(psi, sdtpz.Section(sdt)...)
+			}
+			for now >= next {
+				next += muxPSIInterval
+			}
+		}
+
+		var p Packet
+		switch {
+		case len(psi) > 0:
+			p, psi = psi[0], psi[1:]
+		case in != nil && in.queue[0].t <= now:
+			p = in.pop(now)
+		default:
+			p = null
+		}
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+		if m.bitrate > 0 {
+			now = m.slotTime(slot)
+		}
+	}
+}
+
+// slotTime returns the time of the n-th packet at the bitrate in units of
+// 27 MHz.
+func (m *Muxer) slotTime(n int64) int64 {
+	bits := n * packetDefaultSize * 8
+	return bits/m.bitrate*SystemClockFrequency + bits%m.bitrate*SystemClockFrequency/m.bitrate
+}
+
+// earliest returns the input whose next packet is the earliest, or nil if all
+// the inputs end.
+func (m *Muxer) earliest() (*muxInput, error) {
+	var earliest *muxInput
+	for _, in := range m.inputs {
+		if err := in.fill(); err != nil {
+			return nil, err
+		}
+		if len(in.queue) == 0 {
+			continue
+		}
+		if earliest == nil || in.queue[0].t < earliest.queue[0].t {
+			earliest = in
+		}
+	}
+	return earliest, nil
+}
+
+// nullPacket returns a new null packet.
+func nullPacket() Packet {
+	p := make(Packet, packetDefaultSize)
+	putHeader(p, PidNull, false, 0x01, 0)
+	for i := 4; i < len(p); i++ {
+		p[i] = 0xFF
+	}
+	return p
+}
+
+// muxInput is an input of the Muxer.
+type muxInput struct {
+	number  ProgramNumber
+	name    string
+	pr      *PacketReader
+	d       *Demuxer
+	source  ProgramNumber // program_number of the input
+	pmt     PMT           // PMT of the output
+	pids    map[PID]PID   // PIDs of the input to those of the output
+	pcrPID  PID
+	pending []Packet // packets after the last PCR
+	queue   []muxPacket
+	pcr     int64 // last PCR, or -1 before the first
+	clock   int64 // time of the last PCR
+	offset  int64 // largest offset of the PCRs restamped
+	eof     bool
+}
+
+// muxPacket is a packet timed relative to the first PCR of the input in units
+// of 27 MHz.
+type muxPacket struct {
+	p    Packet
+	t    int64
+	pcr  bool
+	base int64 // PCR - t
+}
+
+func (in *muxInput) pmtPID() PID {
+	return PID(in.number) * muxPIDStep
+}
+
+func (in *muxInput) handleProgram(pg *Program) error {
+	if in.source == 0 {
+		in.source = pg.Number
+	}
+	if pg.Number != in.source {
+		return nil
+	}
+	base := in.pmtPID()
+	assign := func(pid PID) {
+		if _, ok := in.pids[pid]; !ok && pid != PidNull && len(in.pids) < muxPIDStep-1 {
+			in.pids[pid] = base + 1 + PID(len(in.pids))
+		}
+	}
+	assign(pg.PCRPID())
+	for _, pid := range caPIDs(pg.PMT.Descriptors()) {
+		assign(pid)
+	}
+	for _, st := range pg.Streams {
+		assign(st.PID)
+		for _, pid := range caPIDs(st.Info.Descriptors()) {
+			assign(pid)
+		}
+	}
+	in.pcrPID = pg.PCRPID()
+
+	pmt := append(PMT{}, pg.PMT...)
+	remapPMT(pmt, func(pid PID) PID {
+		if dst, ok := in.pids[pid]; ok {
+			return dst
+		}
+		return PidNull
+	})
+	binary.BigEndian.PutUint16(pmt[3:5], uint16(in.number))
+	PSI(pmt).UpdateCRC32()
+	in.pmt = pmt
+	return nil
+}
+
+// fill reads the input until a packet is timed or the input ends.
+func (in *muxInput) fill() error {
+	for len(in.queue) == 0 && !in.eof {
+		p, err := in.pr.Next()
+		if err == io.EOF {
+			in.eof = true
+			for _, q := range in.pending {
+				in.queue = append(in.queue, muxPacket{p: q, t: in.clock})
+			}
+			in.pending = nil
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := in.d.WritePacket(p); err != nil {
+			return err
+		}
+		if _, ok := in.pids[p.PID()]; ok {
+			in.push(append(Packet{}, p...))
+		}
+	}
+	return nil
+}
+
+// push times the packets pending until the packet having the PCR.
+func (in *muxInput) push(p Packet) {
+	pcr := int64(-1)
+	if p.PID() == in.pcrPID {
+		if af, _ := p.AdaptationField(); len(af) >= 8 && af.HasPCR() {
+			pcr = af.PCR().Value()
+		}
+	}
+	if pcr < 0 {
+		in.pending = append(in.pending, p)
+		return
+	}
+
+	var d int64
+	if in.pcr >= 0 {
+		d = (pcr - in.pcr + clockReferenceWrap) % clockReferenceWrap
+		if d > SystemClockFrequency { // discontinuity
+			d = 0
+		}
+	}
+	n := int64(len(in.pending) + 1)
+	for i, q := range in.pending {
+		in.queue = append(in.queue, muxPacket{p: q, t: in.clock + d*int64(i+1)/n})
+	}
+	in.pending = in.pending[:0]
+	in.clock += d
+	in.pcr = pcr
+	in.queue = append(in.queue, muxPacket{p: p, t: in.clock, pcr: true, base: pcr - in.clock})
+}
+
+// pop returns the next packet with the PID of the output, and the PCR or the
+// PTS and the DTS restamped by now.
+func (in *muxInput) pop(now int64) Packet {
+	mp := in.queue[0]
+	in.queue[0] = muxPacket{}
+	in.queue = in.queue[1:]
+	putPID(mp.p[1:3], in.pids[mp.p.PID()])
+	if mp.pcr {
+		af, _ := mp.p.AdaptationField()
+		putClockReference(af.PCR(), mp.base+now)
+		if d := now - mp.t; d > in.offset {
+			in.offset = d
+		}
+	}
+	if in.offset != 0 && mp.p.IsPayloadUnitStart() && mp.p.IsPES() {
+		PES(mp.p.Payload()).addTimestamps(in.offset / 300)
+	}
+	return mp.p
+}
@@ -0,0 +1,143 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMuxer(t *testing.T) {
+	for i, bitrate := range []int64{0, 2000000} {
+		m := NewMuxer(0x0001)
+		m.Bitrate(bitrate)
+		m.SDT(0x0002)
+		for j, frames := range []int{10, 6} {
+			n, err := m.AddProgram(bytes.NewReader(makeTestProgramStream(frames)), "service")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != ProgramNumber(j+1) {
+				t.Errorf("%0d: AddProgram() => %d, want %d", i, n, j+1)
+			}
+		}
+		var out bytes.Buffer
+		if err := m.Mux(&out); err != nil {
+			t.Fatalf("%0d: Mux() causes %s", i, err)
+		}
+		if out.Len()%packetDefaultSize != 0 {
+			t.Fatalf("%0d: Mux() outputs %d bytes", i, out.Len())
+		}
+
+		d := NewDemuxer()
+		var events []Event
+		d.Observe(func(e Event) {
+			events = append(events, e)
+		})
+		var sdts []SDT
+		d.HandleSection(PidSDT, func(rx *SectionReceiver) error {
+			if !PSI(rx.Bytes()).VerifyCRC32() {
+				t.Errorf("%0d: SDT CRC_32 is invalid", i)
+			}
+			sdt, _ := NewSDT(rx.Bytes())
+			sdts = append(sdts, append(SDT{}, sdt...))
+			return nil
+		})
+		pts := make(map[PID][]int64)
+		d.HandleStreamType(StreamTypeH264, func(pid PID, pes PES) error {
+			pts[pid] = append(pts[pid], pes.PTS())
+			return nil
+		})
+		var nulls, packets int
+		pcrs := make(map[PID][]int64)
+		pcrAt := make(map[PID][]int)
+		for _, pid := range []PID{PidNull, 0x101, 0x201} {
+			pid := pid
+			d.HandlePacket(pid, func(p Packet) error {
+				if pid == PidNull {
+					nulls++
+				} else if af, _ := p.AdaptationField(); af != nil && af.HasPCR() {
+					pcrs[pid] = append(pcrs[pid], af.PCR().Value())
+					pcrAt[pid] = append(pcrAt[pid], packets)
+					// the input has the PTS equal to the PCR, which is delayed
+					// by a few packets at most
+					pes, _ := NewPES(p.Payload())
+					if d := pes.PTS()*300 - af.PCR().Value(); d <= -300 || d > SystemClockFrequency/100 {
+						t.Errorf("%0d: PTS of 0x%04X differs from PCR by %d", i, pid, d)
+					}
+				}
+				return nil
+			})
+		}
+		for pos := 0; pos < out.Len(); pos += packetDefaultSize {
+			if err := d.WritePacket(Packet(out.Bytes()[pos : pos+packetDefaultSize])); err != nil {
+				t.Fatal(err)
+			}
+			packets++
+		}
+		if err := d.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(events) != 0 {
+			t.Errorf("%0d: Mux() causes events %v", i, events)
+		}
+		var numbers []ProgramNumber
+		for _, pg := range d.Programs() {
+			numbers = append(numbers, pg.Number)
+			base := PID(pg.Number) * 0x100
+			if pg.PID != base || pg.PCRPID() != base+1 || len(pg.Streams) != 2 ||
+				pg.Streams[0].PID != base+1 || pg.Streams[1].PID != base+2 {
+				t.Errorf("%0d: program %d => %v", i, pg.Number, pg)
+			}
+		}
+		if want := []ProgramNumber{1, 2}; !reflect.DeepEqual(numbers, want) {
+			t.Errorf("%0d: programs => %v, want %v", i, numbers, want)
+		}
+		if len(sdts) == 0 || len(sdts[0].Services()) != 2 {
+			t.Errorf("%0d: Mux() outputs SDTs %v", i, sdts)
+		}
+		if len(pts[0x101]) != 10 || len(pts[0x201]) != 6 {
+			t.Errorf("%0d: Mux() outputs PES packets %v", i, pts)
+		}
+
+		if bitrate == 0 {
+			if nulls != 0 {
+				t.Errorf("%0d: Mux() outputs %d null packets, want 0", i, nulls)
+			}
+			continue
+		}
+		if nulls == 0 {
+			t.Errorf("%0d: Mux() outputs no null packets", i)
+		}
+		// the PCRs are restamped by the position in the output
+		for pid, values := range pcrs {
+			for j := 1; j < len(values); j++ {
+				slots := int64(pcrAt[pid][j] - pcrAt[pid][j-1])
+				want := slots * packetDefaultSize * 8 * SystemClockFrequency / bitrate
+				if got := values[j] - values[j-1]; got < want-1 || got > want+1 {
+					t.Errorf("%0d: PCR of 0x%04X increases by %d in %d packets, want %d", i, pid, got, slots, want)
+				}
+			}
+		}
+	}
+
+	m := NewMuxer(0x0001)
+	m.AddProgram(bytes.NewReader(make([]byte, packetDefaultSize)), "")
+	if err := m.Mux(&bytes.Buffer{}); err != ErrNoProgram {
+		t.Errorf("Mux() causes %v, want %s", err, ErrNoProgram)
+	}
+}
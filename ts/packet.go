@@ -19,6 +19,12 @@ import "io"
 // SyncByte is used to identify the start of the TS Packet.
 const SyncByte = 0x47
 
+// Frequencies of the clocks.
+const (
+	SystemClockFrequency = 27000000 // system_clock_frequency of PCR in Hz
+	TimestampFrequency   = 90000    // frequency of PTS, DTS and PCR base in Hz
+)
+
 // PIDs for packet.
 const (
 	PidPAT  = 0x0000 // PAT
@@ -388,4 +394,64 @@ func (af AdaptationField) AdaptationExtensionLength() int {
 	return int(af[low])
 }
 
+// Base returns the program_clock_reference_base in units of 90 kHz.
+func (pcr PCR) Base() int64 {
+	return clockReferenceBase(pcr)
+}
+
+// Extension returns the program_clock_reference_extension.
+func (pcr PCR) Extension() int {
+	return clockReferenceExtension(pcr)
+}
+
+// Value returns the PCR in units of 27 MHz.
+func (pcr PCR) Value() int64 {
+	return pcr.Base()*300 + int64(pcr.Extension())
+}
+
+// Base returns the original_program_clock_reference_base in units of 90 kHz.
+func (opcr OPCR) Base() int64 {
+	return clockReferenceBase(opcr)
+}
+
+// Extension returns the original_program_clock_reference_extension.
+func (opcr OPCR) Extension() int {
+	return clockReferenceExtension(opcr)
+}
+
+// Value returns the OPCR in units of 27 MHz.
+func (opcr OPCR) Value() int64 {
+	return opcr.Base()*300 + int64(opcr.Extension())
+}
+
+func clockReferenceBase(b []byte) int64 {
+	return int64(b[0])<<25 | int64(b[1])<<17 | int64(b[2])<<9 | int64(b[3])<<1 | int64(b[4]>>7)
+}
+
+func clockReferenceExtension(b []byte) int {
+	return int(b[4]&0x01)<<8 | int(b[5])
+}
+
+// clockReferenceWrap is the period of the clock reference in units of 27 MHz.
+const clockReferenceWrap = 1 << 33 * 300
+
+// timestampWrap is the period of PTS and DTS in units of 90 kHz.
+const timestampWrap = 1 << 33
+
+// putClockReference puts the clock reference of v in units of 27 MHz in
+// b[0:6].
+func putClockReference(b []byte, v int64) {
+	v %= clockReferenceWrap
+	if v < 0 {
+		v += clockReferenceWrap
+	}
+	base, ext := v/300, v%300
+	b[0] = byte(base >> 25)
+	b[1] = byte(base >> 17)
+	b[2] = byte(base >> 9)
+	b[3] = byte(base >> 1)
+	b[4] = byte(base&0x01)<<7 | 0x7E | byte(ext>>8&0x01)
+	b[5] = byte(ext)
+}
+
 // TODO: methods of AdaptationExtensionField
@@ -515,6 +515,31 @@ func TestPCR(t *testing.T) {
 	}
 }
 
+func TestPCRValue(t *testing.T) {
+	for i, tc := range []struct {
+		pcr  PCR
+		base int64
+		ext  int
+	}{
+		{PCR{0x7A, 0x34, 0x0F, 0x14, 0x7E, 0x78}, 0xF4681E28, 0x78},
+		{PCR{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}, 0x1FFFFFFFF, 0x1FF},
+		{PCR{0x00, 0x00, 0x00, 0x00, 0x7E, 0x00}, 0x0, 0x0},
+	} {
+		if got := tc.pcr.Base(); got != tc.base {
+			t.Errorf("%0d: PCR 0x%X Base() => 0x%X, want 0x%X", i, tc.pcr, got, tc.base)
+		}
+		if got := tc.pcr.Extension(); got != tc.ext {
+			t.Errorf("%0d: PCR 0x%X Extension() => 0x%X, want 0x%X", i, tc.pcr, got, tc.ext)
+		}
+		if got, exp := tc.pcr.Value(), tc.base*300+int64(tc.ext); got != exp {
+			t.Errorf("%0d: PCR 0x%X Value() => %d, want %d", i, tc.pcr, got, exp)
+		}
+		if got, exp := OPCR(tc.pcr).Value(), tc.base*300+int64(tc.ext); got != exp {
+			t.Errorf("%0d: OPCR 0x%X Value() => %d, want %d", i, tc.pcr, got, exp)
+		}
+	}
+}
+
 func TestOPCR(t *testing.T) {
 	afLen := byte(0x7B)
 	pcr := []byte{0x7A, 0x34, 0x0F, 0x14, 0x7E, 0x78}
@@ -0,0 +1,163 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+// Packetizer splits the sections into the packets of a PID, counting the
+// continuity_counter.
+type Packetizer struct {
+	pid PID
+	cc  uint8 // continuity_counter of the next packet
+}
+
+// NewPacketizer returns a new Packetizer for the PID.
+func NewPacketizer(pid PID) *Packetizer {
+	return &Packetizer{pid: pid}
+}
+
+// PID returns the PID.
+func (pz *Packetizer) PID() PID {
+	return pz.pid
+}
+
+// SetContinuityCounter sets the continuity_counter of the next packet.
+func (pz *Packetizer) SetContinuityCounter(cc uint8) {
+	pz.cc = cc & 0x0F
+}
+
+// Section returns the packets carrying the section. The first packet starts
+// the section by the pointer_field of 0, and the last one is filled with the
+// stuffing bytes.
+func (pz *Packetizer) Section(section []byte) []Packet {
+	data := make([]byte, 0, len(section)+1)
+	data = append(data, 0x00) // pointer_field
+	data = append(data, section...)
+
+	var packets []Packet
+	for start := true; len(data) > 0; start = false {
+		p := pz.packet(start)
+		n := copy(p[4:], data)
+		for i := 4 + n; i < len(p); i++ {
+			p[i] = 0xFF
+		}
+		data = data[n:]
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+// PES returns the packets carrying the PES packet. The first packet has the
+// adaptation field with the PCR in units of 27 MHz if pcr is not negative and
+// the random_access_indicator if rai is true, and the last one is stuffed by
+// the adaptation field.
+func (pz *Packetizer) PES(pes []byte, pcr int64, rai bool) []Packet {
+	var packets []Packet
+	for start := true; start || len(pes) > 0; start = false {
+		var flags byte
+		afSize := 0 // adaptation_field_length and the following
+		if start && rai {
+			flags |= 0x40
+			afSize = 2
+		}
+		if start && pcr >= 0 {
+			flags |= 0x10
+			afSize = 8
+		}
+		room := packetDefaultSize - 4 - afSize
+		if len(pes) < room {
+			afSize += room - len(pes)
+			room = len(pes)
+		}
+
+		p := make(Packet, packetDefaultSize)
+		afc := byte(0x01)
+		switch {
+		case room == 0:
+			afc = 0x02
+		case afSize > 0:
+			afc = 0x03
+		}
+		putHeader(p, pz.pid, start, afc, pz.cc)
+		pz.cc = (pz.cc + 1) & 0x0F
+		if afSize > 0 {
+			p[4] = byte(afSize - 1)
+		}
+		if afSize > 1 {
+			p[5] = flags
+			pos := 6
+			if flags&0x10 != 0 {
+				putClockReference(p[6:12], pcr)
+				pos = 12
+			}
+			for ; pos < 4+afSize; pos++ {
+				p[pos] = 0xFF
+			}
+		}
+		copy(p[4+afSize:], pes[:room])
+		pes = pes[room:]
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+// packet returns a new packet with the header for the payload only.
+func (pz *Packetizer) packet(start bool) Packet {
+	p := make(Packet, packetDefaultSize)
+	putHeader(p, pz.pid, start, 0x01, pz.cc)
+	pz.cc = (pz.cc + 1) & 0x0F
+	return p
+}
+
+// putHeader puts the header of the packet.
+func putHeader(p Packet, pid PID, start bool, afc byte, cc uint8) {
+	p[0] = SyncByte
+	p[1] = byte(pid >> 8 & 0x1F)
+	if start {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	p[3] = afc<<4 | cc&0x0F
+}
+
+// setContinuityCounter sets the continuity_counter of the packet.
+func setContinuityCounter(p Packet, cc uint8) {
+	p[3] = p[3]&0xF0 | cc&0x0F
+}
+
+// putPID puts the 13 bits PID in b[0:2], keeping the upper 3 bits of b[0].
+func putPID(b []byte, pid PID) {
+	b[0] = b[0]&0xE0 | byte(pid>>8&0x1F)
+	b[1] = byte(pid)
+}
+
+// adaptationFieldPacket returns a new packet with the adaptation field only,
+// whose flags are set.
+func adaptationFieldPacket(pid PID, cc uint8, flags byte) Packet {
+	p := make(Packet, packetDefaultSize)
+	putHeader(p, pid, false, 0x02, cc)
+	p[4] = byte(packetDefaultSize - 5) // adaptation_field_length
+	p[5] = flags
+	for i := 6; i < len(p); i++ {
+		p[i] = 0xFF
+	}
+	return p
+}
+
+// pcrPacket returns a new packet with the adaptation field only, which has the
+// PCR in units of 27 MHz and the flags.
+func pcrPacket(pid PID, cc uint8, flags byte, pcr int64) Packet {
+	p := adaptationFieldPacket(pid, cc, flags|0x10)
+	putClockReference(p[6:12], pcr)
+	return p
+}
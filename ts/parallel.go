@@ -0,0 +1,169 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+const (
+	parallelDefaultQueue = 64  // packets queued per worker
+	parallelChunkPackets = 256 // packets copied into a chunk
+)
+
+// Parallel is a fan-out stage which shards the packets by PID to the worker
+// goroutines.
+//
+// The packets of a PID are always processed by the same worker in the order
+// of the stream, so the state per PID needs no lock in the worker. The
+// results are merged in the order of the packets in the stream regardless of
+// the scheduling, so the output is deterministic.
+//
+// The queues between the stages are bounded. A slow worker or merge blocks the
+// reading of the stream instead of buffering without limit.
+type Parallel[R any] struct {
+	workers   int
+	queue     int
+	newWorker func() func(p Packet) R
+}
+
+// NewParallel returns a new Parallel with the number of workers. newWorker is
+// called once for each worker to make its processing function.
+func NewParallel[R any](workers int, newWorker func() func(p Packet) R) *Parallel[R] {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Parallel[R]{
+		workers:   workers,
+		queue:     parallelDefaultQueue,
+		newWorker: newWorker,
+	}
+}
+
+// QueueSize sets the number of the packets queued per worker.
+func (pp *Parallel[R]) QueueSize(n int) {
+	if n < 1 {
+		n = 1
+	}
+	pp.queue = n
+}
+
+// Run reads the packets from r, processes them in the workers and calls merge
+// with the results in the order of the packets. It returns when the stream
+// ends, ctx is done, or reading or merge fails, and all the goroutines started
+// by Run have exited by then.
+//
+// Run can not interrupt a blocking Read of r.
+func (pp *Parallel[R]) Run(ctx context.Context, r io.Reader, merge func(R) error) error {
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	in := make([]chan Packet, pp.workers)
+	out := make([]chan R, pp.workers)
+	var wg sync.WaitGroup
+	for i := range in {
+		in[i] = make(chan Packet, pp.queue)
+		out[i] = make(chan R, pp.queue)
+		wg.Add(1)
+		go func(process func(Packet) R, in <-chan Packet, out chan<- R) {
+			defer wg.Done()
+			for p := range in {
+				select {
+				case out <- process(p):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(pp.newWorker(), in[i], out[i])
+	}
+
+	// order tells the merger which worker has the next result.
+	order := make(chan int, pp.workers*pp.queue)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(order)
+		defer func() {
+			for _, c := range in {
+				close(c)
+			}
+		}()
+		readErr <- pp.dispatch(ctx, r, in, order)
+	}()
+
+	var err error
+loop:
+	for w := range order {
+		select {
+		case res := <-out[w]:
+			if err = merge(res); err != nil {
+				break loop
+			}
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	cancel()
+	for range order {
+		// drain to let the dispatcher exit
+	}
+	wg.Wait()
+	rerr := <-readErr
+
+	switch {
+	case err != nil:
+		return err
+	case parent.Err() != nil:
+		return parent.Err()
+	}
+	return rerr
+}
+
+// dispatch reads the packets from r and sends them to the workers by PID.
+func (pp *Parallel[R]) dispatch(ctx context.Context, r io.Reader, in []chan Packet, order chan<- int) error {
+	pr := NewPacketReader(r)
+	var chunk []byte
+	for {
+		p, err := pr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// copy the packets into a chunk shared by the following packets
+		if len(chunk) < len(p) {
+			chunk = make([]byte, parallelChunkPackets*packetDefaultSize)
+		}
+		c := Packet(chunk[:len(p):len(p)])
+		copy(c, p)
+		chunk = chunk[len(p):]
+
+		w := int(c.PID()) % len(in)
+		select {
+		case order <- w:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case in[w] <- c:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+type parallelTestResult struct {
+	pid PID
+	seq int // sequence in the PID counted by the worker
+}
+
+func TestParallel(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 1000; i++ {
+		stream = concatPacket(stream, makeTSPacket(PID(i%7), uint8(i), false, nil))
+	}
+
+	pp := NewParallel(3, func() func(Packet) parallelTestResult {
+		seq := make(map[PID]int)
+		return func(p Packet) parallelTestResult {
+			pid := p.PID()
+			seq[pid]++
+			return parallelTestResult{pid, seq[pid]}
+		}
+	})
+	pp.QueueSize(4)
+
+	i := 0
+	err := pp.Run(context.Background(), bytes.NewReader(stream), func(res parallelTestResult) error {
+		exp := parallelTestResult{PID(i % 7), i/7 + 1}
+		if res != exp {
+			t.Errorf("%d: got %+v, want %+v", i, res, exp)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 1000 {
+		t.Errorf("got %d results, want %d", i, 1000)
+	}
+}
+
+func TestParallelStop(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 1000; i++ {
+		stream = concatPacket(stream, makeTSPacket(PID(i%7), uint8(i), false, nil))
+	}
+	pp := NewParallel(3, func() func(Packet) PID {
+		return func(p Packet) PID {
+			return p.PID()
+		}
+	})
+	pp.QueueSize(1)
+
+	errStop := errors.New("stop")
+	n := 0
+	err := pp.Run(context.Background(), bytes.NewReader(stream), func(PID) error {
+		n++
+		if n == 10 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || n != 10 {
+		t.Errorf("Run() causes %v after %d results, want %s after 10", err, n, errStop)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n = 0
+	err = pp.Run(ctx, bytes.NewReader(stream), func(PID) error {
+		n++
+		if n == 10 {
+			cancel()
+		}
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("Run() causes %v, want %s", err, context.Canceled)
+	}
+}
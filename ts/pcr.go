@@ -0,0 +1,251 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"sort"
+	"time"
+)
+
+// PCRAccuracyLimit is the limit of the PCR accuracy in ETSI TR 101 290.
+const PCRAccuracyLimit = 500 * time.Nanosecond
+
+// PCRStats is the statistics of the PCRs of a PID over an interval.
+//
+// The accuracy of a PCR is the difference from the previous PCR added by the
+// time of the bytes between them at the transport rate. The overall jitter is
+// the difference from the first PCR added by the time of the bytes from it.
+type PCRStats struct {
+	PID   PID
+	Start time.Duration // start of the interval in the stream
+	End   time.Duration // end of the interval in the stream
+	Count int           // number of the PCRs
+
+	AccuracyMin    time.Duration
+	AccuracyMax    time.Duration
+	AccuracyErrors int // number of the PCRs out of PCRAccuracyLimit
+	JitterMin      time.Duration
+	JitterMax      time.Duration
+
+	FrequencyOffset float64 // offset from 27 MHz in Hz
+	Drift           float64 // change of the FrequencyOffset in Hz per second
+
+	Accuracy *Histogram // by 50 ns from -1 µs
+	Jitter   *Histogram // by 50 µs from -1 ms
+}
+
+func newPCRStats(pid PID, start time.Duration) *PCRStats {
+	return &PCRStats{
+		PID:      pid,
+		Start:    start,
+		End:      start,
+		Accuracy: NewHistogram(-time.Microsecond, 50*time.Nanosecond, 40),
+		Jitter:   NewHistogram(-time.Millisecond, 50*time.Microsecond, 40),
+	}
+}
+
+func (s *PCRStats) add(accuracy, jitter time.Duration) {
+	if s.Count == 0 || accuracy < s.AccuracyMin {
+		s.AccuracyMin = accuracy
+	}
+	if s.Count == 0 || accuracy > s.AccuracyMax {
+		s.AccuracyMax = accuracy
+	}
+	if s.Count == 0 || jitter < s.JitterMin {
+		s.JitterMin = jitter
+	}
+	if s.Count == 0 || jitter > s.JitterMax {
+		s.JitterMax = jitter
+	}
+	if accuracy < -PCRAccuracyLimit || accuracy > PCRAccuracyLimit {
+		s.AccuracyErrors++
+	}
+	s.Accuracy.Add(accuracy)
+	s.Jitter.Add(jitter)
+	s.Count++
+}
+
+// PCRAnalyzer analyzes the PCRs of the PIDs versus their positions in the
+// stream, and reports the statistics of each interval.
+//
+// The transport rate is measured by the PCRs of the first PCR_PID found
+// unless the bitrate is set, and the stream is timed at the rate from the
+// first PCR.
+type PCRAnalyzer struct {
+	interval time.Duration
+	report   func(s PCRStats)
+	clock    *streamClock
+	framer   packetFramer
+	n        int64
+	start    time.Duration // start of the current interval
+	pids     map[PID]*pcrTrack
+}
+
+// pcrTrack tracks the PCRs of a PID.
+type pcrTrack struct {
+	n0, pcr0 int64 // first PCR
+	n, pcr   int64 // last PCR unwrapped
+	raw      int64 // last PCR as it is
+	fn, fpcr int64 // first PCR in the interval
+	fo       float64
+	hasFO    bool
+	interval *PCRStats
+	total    *PCRStats
+}
+
+// NewPCRAnalyzer returns a new PCRAnalyzer reporting the statistics of each
+// PID to report every interval. The interval of 0 or less reports nothing
+// but by Flush.
+func NewPCRAnalyzer(interval time.Duration, report func(s PCRStats)) *PCRAnalyzer {
+	return &PCRAnalyzer{
+		interval: interval,
+		report:   report,
+		clock:    newStreamClock(0),
+		pids:     make(map[PID]*pcrTrack),
+	}
+}
+
+// Bitrate sets the transport rate in bits per second instead of measuring it.
+// It must be called before writing.
+func (a *PCRAnalyzer) Bitrate(bps int64) {
+	a.clock = newStreamClock(bps)
+}
+
+// Write analyzes the packets in b. The packet split between the calls is
+// buffered until the rest is written.
+func (a *PCRAnalyzer) Write(b []byte) (int, error) {
+	return a.framer.write(b, a.WritePacket)
+}
+
+// WritePacket analyzes the packet.
+func (a *PCRAnalyzer) WritePacket(p Packet) error {
+	n := a.n
+	a.n++
+	a.clock.packet(p, n)
+	if a.clock.bitrate == 0 && a.clock.pid == PidNull {
+		return nil
+	}
+	now := a.clock.time(n)
+	for a.interval > 0 && now >= a.start+a.interval {
+		a.flush(a.start + a.interval)
+	}
+
+	af, _ := p.AdaptationField()
+	if len(af) < 8 || !af.HasPCR() {
+		return nil
+	}
+	pid := p.PID()
+	raw := af.PCR().Value()
+	tr, ok := a.pids[pid]
+	if !ok {
+		a.pids[pid] = &pcrTrack{
+			n0: n, pcr0: raw, n: n, pcr: raw, raw: raw, fn: n, fpcr: raw,
+			interval: newPCRStats(pid, a.start),
+			total:    newPCRStats(pid, a.start),
+		}
+		return nil
+	}
+
+	rate := a.clock.ticksPerPacket()
+	expected := float64(n-tr.n) * rate
+	prev := tr.pcr
+	if af.IsDiscontinuous() {
+		// continue the timeline over the discontinuity
+		tr.pcr += int64(expected)
+	} else {
+		tr.pcr += (raw - tr.raw + clockReferenceWrap) % clockReferenceWrap
+	}
+	tr.n, tr.raw = n, raw
+	if rate == 0 || af.IsDiscontinuous() {
+		return nil
+	}
+	accuracy := floatTicksToDuration(float64(tr.pcr-prev) - expected)
+	jitter := floatTicksToDuration(float64(tr.pcr-tr.pcr0) - float64(n-tr.n0)*rate)
+	tr.interval.add(accuracy, jitter)
+	tr.total.add(accuracy, jitter)
+	return nil
+}
+
+// floatTicksToDuration returns the duration of the ticks in units of 27 MHz.
+func floatTicksToDuration(ticks float64) time.Duration {
+	return time.Duration(ticks * 1000 / 27)
+}
+
+// frequencyOffset returns the offset of the frequency of the PCRs from the
+// packet n0 with pcr0 to the last one in Hz.
+func (tr *pcrTrack) frequencyOffset(n0, pcr0 int64, rate float64) (float64, bool) {
+	if tr.n <= n0 || rate == 0 {
+		return 0, false
+	}
+	ratio := float64(tr.pcr-pcr0) / (float64(tr.n-n0) * rate)
+	return (ratio - 1) * SystemClockFrequency, true
+}
+
+// flush reports the statistics of the interval ending at end.
+func (a *PCRAnalyzer) flush(end time.Duration) {
+	rate := a.clock.ticksPerPacket()
+	for _, pid := range a.PIDs() {
+		tr := a.pids[pid]
+		s := tr.interval
+		s.End = end
+		if fo, ok := tr.frequencyOffset(tr.fn, tr.fpcr, rate); ok {
+			s.FrequencyOffset = fo
+			if tr.hasFO && end > s.Start {
+				s.Drift = (fo - tr.fo) / (end - s.Start).Seconds()
+			}
+			tr.fo, tr.hasFO = fo, true
+			tr.total.Drift = s.Drift
+		}
+		if s.Count > 0 && a.report != nil {
+			a.report(*s)
+		}
+		tr.interval = newPCRStats(pid, end)
+		tr.fn, tr.fpcr = tr.n, tr.pcr
+	}
+	a.start = end
+}
+
+// Flush reports the statistics of the interval in progress.
+func (a *PCRAnalyzer) Flush() {
+	if a.n > 0 {
+		a.flush(a.clock.time(a.n - 1))
+	}
+}
+
+// PIDs returns the PIDs having the PCRs in order.
+func (a *PCRAnalyzer) PIDs() []PID {
+	pids := make([]PID, 0, len(a.pids))
+	for pid := range a.pids {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool {
+		return pids[i] < pids[j]
+	})
+	return pids
+}
+
+// Stats returns the statistics of the PCRs of the PID since the first one.
+func (a *PCRAnalyzer) Stats(pid PID) (PCRStats, bool) {
+	tr, ok := a.pids[pid]
+	if !ok {
+		return PCRStats{}, false
+	}
+	s := *tr.total
+	s.End = a.clock.time(tr.n)
+	if fo, ok := tr.frequencyOffset(tr.n0, tr.pcr0, a.clock.ticksPerPacket()); ok {
+		s.FrequencyOffset = fo
+	}
+	return s, true
+}
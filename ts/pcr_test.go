@@ -0,0 +1,127 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPCRAnalyzer(t *testing.T) {
+	for i, tc := range []struct {
+		f              func(i int, p []byte) []byte
+		accuracyErrors int
+		jitterMax      time.Duration
+		fo             float64
+	}{
+		{nil, 0, 0, 0},
+		{
+			// 1 µs late
+			func(i int, p []byte) []byte {
+				if i == 505 {
+					putClockReference(p[6:], int64(i)*27000+27)
+				}
+				return p
+			},
+			2, time.Microsecond, 0,
+		},
+		{
+			// 1000 ppm fast
+			func(i int, p []byte) []byte {
+				if i%10 == 5 {
+					putClockReference(p[6:], int64(i)*27027)
+				}
+				return p
+			},
+			199, 1990 * time.Microsecond, 27000,
+		},
+	} {
+		stream := makeTestMonitorStream(2000, true, tc.f)
+		var reports []PCRStats
+		a := NewPCRAnalyzer(500*time.Millisecond, func(s PCRStats) {
+			reports = append(reports, s)
+		})
+		a.Bitrate(1504000)
+		if _, err := a.Write(stream); err != nil {
+			t.Fatal(err)
+		}
+		a.Flush()
+
+		if pids := a.PIDs(); len(pids) != 1 || pids[0] != 0x0100 {
+			t.Fatalf("%0d: PIDs() => %v, want [256]", i, pids)
+		}
+		s, ok := a.Stats(0x0100)
+		if !ok {
+			t.Fatalf("%0d: Stats(0x0100) => false", i)
+		}
+		if s.Count != 199 {
+			t.Errorf("%0d: Stats().Count => %d, want %d", i, s.Count, 199)
+		}
+		if s.AccuracyErrors != tc.accuracyErrors {
+			t.Errorf("%0d: Stats().AccuracyErrors => %d, want %d", i, s.AccuracyErrors, tc.accuracyErrors)
+		}
+		if s.JitterMax != tc.jitterMax {
+			t.Errorf("%0d: Stats().JitterMax => %s, want %s", i, s.JitterMax, tc.jitterMax)
+		}
+		if math.Abs(s.FrequencyOffset-tc.fo) > 1 {
+			t.Errorf("%0d: Stats().FrequencyOffset => %f, want %f", i, s.FrequencyOffset, tc.fo)
+		}
+		if s.Accuracy.Total() != 199 || s.Jitter.Total() != 199 {
+			t.Errorf("%0d: Stats() histograms total => %d, %d, want 199", i, s.Accuracy.Total(), s.Jitter.Total())
+		}
+
+		if len(reports) != 4 {
+			t.Fatalf("%0d: reported %d intervals, want %d", i, len(reports), 4)
+		}
+		count := 0
+		for j, r := range reports {
+			if r.Start != time.Duration(j)*500*time.Millisecond {
+				t.Errorf("%0d: reports[%d].Start => %s, want %s", i, j, r.Start, time.Duration(j)*500*time.Millisecond)
+			}
+			if math.Abs(r.FrequencyOffset-tc.fo) > 1 {
+				t.Errorf("%0d: reports[%d].FrequencyOffset => %f, want %f", i, j, r.FrequencyOffset, tc.fo)
+			}
+			if math.Abs(r.Drift) > 1 {
+				t.Errorf("%0d: reports[%d].Drift => %f, want 0", i, j, r.Drift)
+			}
+			count += r.Count
+		}
+		if count != s.Count {
+			t.Errorf("%0d: reported %d PCRs, want %d", i, count, s.Count)
+		}
+	}
+}
+
+func TestPCRAnalyzerNoInterval(t *testing.T) {
+	stream := makeTestMonitorStream(2000, true, nil)
+	for i, interval := range []time.Duration{0, -time.Second} {
+		var reports []PCRStats
+		a := NewPCRAnalyzer(interval, func(s PCRStats) {
+			reports = append(reports, s)
+		})
+		a.Bitrate(1504000)
+		if _, err := a.Write(stream); err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 0 {
+			t.Errorf("%0d: reported %d intervals before Flush, want 0", i, len(reports))
+		}
+		a.Flush()
+		if len(reports) != 1 || reports[0].Count != 199 {
+			t.Errorf("%0d: Flush() reports %+v, want 199 PCRs", i, reports)
+		}
+	}
+}
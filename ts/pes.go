@@ -0,0 +1,293 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalidStartCode is returned when bytes do not start with the
+// packet_start_code_prefix.
+var ErrInvalidStartCode = errors.New("ts: invalid packet_start_code_prefix")
+
+// Stream IDs for PES.
+const (
+	StreamIDProgramStreamMap       = 0xBC // program_stream_map
+	StreamIDPrivateStream1         = 0xBD // private_stream_1
+	StreamIDPaddingStream          = 0xBE // padding_stream
+	StreamIDPrivateStream2         = 0xBF // private_stream_2
+	StreamIDECM                    = 0xF0 // ECM_stream
+	StreamIDEMM                    = 0xF1 // EMM_stream
+	StreamIDDSMCC                  = 0xF2 // DSMCC_stream
+	StreamIDH2221TypeE             = 0xF8 // ITU-T Rec. H.222.1 type E stream
+	StreamIDProgramStreamDirectory = 0xFF // program_stream_directory
+	// 0xC0 .. 0xDF ISO/IEC 13818-3 or ISO/IEC 11172-3 or ISO/IEC 13818-7 or ISO/IEC 14496-3 audio stream
+	// 0xE0 .. 0xEF ITU-T Rec. H.262 | ISO/IEC 13818-2, ISO/IEC 11172-2, ISO/IEC 14496-2, ITU-T Rec. H.264 | ISO/IEC 14496-10 or ITU-T Rec. H.265 | ISO/IEC 23008-2 video stream
+)
+
+const pesHeaderSize = 6 // packet_start_code_prefix .. PES_packet_length
+
+// PES is a Packetized Elementary Stream(PES) packet.
+type PES []byte
+
+// NewPES returns a new PES.
+func NewPES(b []byte) (PES, error) {
+	if len(b) < pesHeaderSize {
+		return nil, ErrTooShort
+	}
+	if !Payload(b).IsPES() {
+		return nil, ErrInvalidStartCode
+	}
+	p := PES(b)
+	if p.HasOptionalHeader() {
+		if len(b) < pesHeaderSize+3 || len(b) < pesHeaderSize+3+p.HeaderDataLength() {
+			return nil, ErrTooShort
+		}
+		// PTS and DTS in PES_header_data_length
+		if p.HasPTS() && p.HeaderDataLength() < 5 || p.HasDTS() && p.HeaderDataLength() < 10 {
+			return nil, ErrTooShort
+		}
+	}
+	return p, nil
+}
+
+// StreamID returns the stream_id.
+func (p PES) StreamID() byte {
+	return p[3]
+}
+
+// PacketLength returns the PES_packet_length that specifying the number of
+// bytes in the PES packet following the last byte of the field.
+// 0 means that the length is neither specified nor bounded.
+func (p PES) PacketLength() int {
+	return int(binary.BigEndian.Uint16(p[4:6]))
+}
+
+// HasOptionalHeader reports whether the PES packet has the optional PES header
+// from the '10' bits to the PES_header_data_length.
+func (p PES) HasOptionalHeader() bool {
+	switch p.StreamID() {
+	case StreamIDProgramStreamMap, StreamIDPaddingStream, StreamIDPrivateStream2,
+		StreamIDECM, StreamIDEMM, StreamIDDSMCC, StreamIDH2221TypeE,
+		StreamIDProgramStreamDirectory:
+		return false
+	}
+	return true
+}
+
+// ScramblingControl returns the PES_scrambling_control.
+func (p PES) ScramblingControl() byte {
+	if !p.HasOptionalHeader() {
+		return 0
+	}
+	return p[6] & 0x30 >> 4
+}
+
+// DataAlignmentIndicator returns the data_alignment_indicator.
+func (p PES) DataAlignmentIndicator() byte {
+	if !p.HasOptionalHeader() {
+		return 0
+	}
+	return p[6] & 0x04 >> 2
+}
+
+// PTSDTSFlags returns the PTS_DTS_flags.
+// - 0x00(00): no PTS or DTS
+// - 0x01(01): forbidden
+// - 0x02(10): PTS only
+// - 0x03(11): both PTS and DTS
+func (p PES) PTSDTSFlags() byte {
+	if !p.HasOptionalHeader() {
+		return 0
+	}
+	return p[7] & 0xC0 >> 6
+}
+
+// HasPTS reports whether the PES packet has the PTS.
+func (p PES) HasPTS() bool {
+	return p.PTSDTSFlags()&0x02 != 0
+}
+
+// HasDTS reports whether the PES packet has the DTS.
+func (p PES) HasDTS() bool {
+	return p.PTSDTSFlags() == 0x03
+}
+
+// HeaderDataLength returns the PES_header_data_length.
+func (p PES) HeaderDataLength() int {
+	if !p.HasOptionalHeader() {
+		return 0
+	}
+	return int(p[8])
+}
+
+// PTS returns the presentation time stamp in units of 90 kHz.
+func (p PES) PTS() int64 {
+	if !p.HasPTS() {
+		return 0
+	}
+	return timestamp(p[9:14])
+}
+
+// DTS returns the decoding time stamp in units of 90 kHz.
+// It returns the PTS if the PES packet has no DTS.
+func (p PES) DTS() int64 {
+	if !p.HasDTS() {
+		return p.PTS()
+	}
+	return timestamp(p[14:19])
+}
+
+// Payload returns the PES_packet_data_byte.
+func (p PES) Payload() []byte {
+	low := pesHeaderSize
+	if p.HasOptionalHeader() {
+		low += 3 + p.HeaderDataLength()
+	}
+	high := len(p)
+	if n := p.PacketLength(); n > 0 && pesHeaderSize+n < high {
+		high = pesHeaderSize + n
+	}
+	if low > high {
+		return nil
+	}
+	return p[low:high]
+}
+
+func timestamp(b []byte) int64 {
+	return int64(b[0]&0x0E)<<29 | int64(b[1])<<22 | int64(b[2]&0xFE)<<14 | int64(b[3])<<7 | int64(b[4])>>1
+}
+
+// putTimestamp puts the PTS or the DTS of ts in b[0:5] with the 4 bits
+// prefix.
+func putTimestamp(b []byte, prefix byte, ts int64) {
+	b[0] = prefix<<4 | byte(ts>>29)&0x0E | 0x01
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>14) | 0x01
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts<<1) | 0x01
+}
+
+// addTimestamps adds d in units of 90 kHz to the PTS and the DTS of the PES
+// packet. The timestamps out of the bytes are left as they are.
+func (p PES) addTimestamps(d int64) {
+	if p.HasPTS() && len(p) >= 14 {
+		putTimestamp(p[9:14], p[9]>>4, (p.PTS()+d)%timestampWrap)
+	}
+	if p.HasDTS() && len(p) >= 19 {
+		putTimestamp(p[14:19], p[14]>>4, (p.DTS()+d)%timestampWrap)
+	}
+}
+
+// BuildPES returns a new PES packet of the stream_id with the data aligned.
+// The PTS is omitted if pts is negative, and the DTS is omitted if it equals
+// the PTS. The PES_packet_length is 0 if the packet exceeds 65535 bytes,
+// which is allowed for the video streams only.
+func BuildPES(streamID byte, pts, dts int64, data []byte) PES {
+	var flags byte
+	headerDataLength := 0
+	switch {
+	case pts < 0:
+	case dts == pts:
+		flags, headerDataLength = 0x80, 5
+	default:
+		flags, headerDataLength = 0xC0, 10
+	}
+	size := pesHeaderSize + 3 + headerDataLength + len(data)
+	p := make(PES, pesHeaderSize+3+headerDataLength, size)
+	copy(p, startCode)
+	p[3] = streamID
+	if n := size - pesHeaderSize; n <= 0xFFFF {
+		binary.BigEndian.PutUint16(p[4:6], uint16(n))
+	}
+	p[6] = 0x84 // '10', data_alignment_indicator
+	p[7] = flags
+	p[8] = byte(headerDataLength)
+	if flags&0x80 != 0 {
+		putTimestamp(p[9:14], flags>>6, pts)
+	}
+	if flags&0x40 != 0 {
+		putTimestamp(p[14:19], 0x01, dts)
+	}
+	return append(p, data...)
+}
+
+// pesBuffer reassembles the PES packets of a PID.
+type pesBuffer struct {
+	buf  []byte  // nil when no PES packet is in progress
+	pool *[]byte // pooled buffer of buf
+	size int     // 0 if PES_packet_length is unbounded
+	read bool    // whether PES_packet_length is read
+}
+
+// depacketize appends the payload to the PES packet and calls emit for the PES
+// packet completed. The PES packet passed to emit is valid only until emit
+// returns.
+func (pb *pesBuffer) depacketize(payload Payload, atStart bool, emit func(PES) error) error {
+	if atStart {
+		if err := pb.flush(emit); err != nil {
+			return err
+		}
+		if !payload.IsPES() {
+			return nil
+		}
+		pb.pool = getBuffer(&pesPool)
+		pb.buf = append((*pb.pool)[:0], payload...)
+	} else if pb.buf != nil {
+		pb.buf = append(pb.buf, payload...)
+	}
+	// PES_packet_length may be in the following packets
+	if pb.buf != nil && !pb.read && len(pb.buf) >= pesHeaderSize {
+		if n := PES(pb.buf).PacketLength(); n > 0 {
+			pb.size = pesHeaderSize + n
+		}
+		pb.read = true
+	}
+
+	if pb.size > 0 && len(pb.buf) >= pb.size {
+		pb.buf = pb.buf[:pb.size]
+		return pb.flush(emit)
+	}
+	return nil
+}
+
+// flush calls emit for the PES packet in progress.
+func (pb *pesBuffer) flush(emit func(PES) error) error {
+	if pb.buf == nil {
+		return nil
+	}
+	defer pb.drop()
+	if len(pb.buf) < pb.size {
+		// truncated
+		return nil
+	}
+	p, err := NewPES(pb.buf)
+	if err != nil {
+		// incomplete header
+		return nil
+	}
+	return emit(p)
+}
+
+func (pb *pesBuffer) drop() {
+	if pb.pool != nil {
+		putBuffer(&pesPool, pb.pool, pb.buf)
+	}
+	pb.buf = nil
+	pb.pool = nil
+	pb.size = 0
+	pb.read = false
+}
@@ -0,0 +1,161 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPES(t *testing.T) {
+	for i, tc := range []struct {
+		b       []byte
+		id      byte
+		length  int
+		optHdr  bool
+		hasPTS  bool
+		hasDTS  bool
+		pts     int64
+		dts     int64
+		payload []byte
+		err     error
+	}{
+		{
+			b: []byte{0x00, 0x00, 0x01, 0xE0, 0x00, 0x00, 0x80, 0xC0, 0x0A,
+				0x39, 0x8D, 0x15, 0xCF, 0x13, 0x19, 0x8D, 0x15, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0x01, 0x09},
+			id:      0xE0,
+			length:  0,
+			optHdr:  true,
+			hasPTS:  true,
+			hasDTS:  true,
+			pts:     0x123456789,
+			dts:     0x123450000,
+			payload: []byte{0x00, 0x00, 0x00, 0x01, 0x09},
+		},
+		{
+			b: []byte{0x00, 0x00, 0x01, 0xC0, 0x00, 0x0A, 0x80, 0x80, 0x05,
+				0x39, 0x8D, 0x15, 0xCF, 0x13, 0xFF, 0xF1, 0xFF},
+			id:      0xC0,
+			length:  10,
+			optHdr:  true,
+			hasPTS:  true,
+			pts:     0x123456789,
+			dts:     0x123456789,
+			payload: []byte{0xFF, 0xF1},
+		},
+		{
+			b:       []byte{0x00, 0x00, 0x01, 0xBE, 0x00, 0x02, 0xFF, 0xFF},
+			id:      0xBE,
+			length:  2,
+			payload: []byte{0xFF, 0xFF},
+		},
+		{
+			b:   []byte{0x00, 0x00, 0x01, 0xE0, 0x00},
+			err: ErrTooShort,
+		},
+		{
+			b:   []byte{0x00, 0x00, 0x02, 0xE0, 0x00, 0x00},
+			err: ErrInvalidStartCode,
+		},
+		{
+			// PTS beyond PES_header_data_length
+			b:   []byte{0x00, 0x00, 0x01, 0xE0, 0x00, 0x00, 0x80, 0xC0, 0x00},
+			err: ErrTooShort,
+		},
+		{
+			// DTS beyond PES_header_data_length
+			b: []byte{0x00, 0x00, 0x01, 0xE0, 0x00, 0x00, 0x80, 0xC0, 0x05,
+				0x39, 0x8D, 0x15, 0xCF, 0x13},
+			err: ErrTooShort,
+		},
+	} {
+		i, tc := i, tc
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			pes, err := NewPES(tc.b)
+			if err != tc.err {
+				t.Fatalf("%0d: NewPES(0x%02X) causes %v, want %v", i, tc.b, err, tc.err)
+			}
+			if err != nil {
+				return
+			}
+			if pes.StreamID() != tc.id {
+				t.Errorf("%0d: PES.StreamID() => 0x%02X, want 0x%02X", i, pes.StreamID(), tc.id)
+			}
+			if pes.PacketLength() != tc.length {
+				t.Errorf("%0d: PES.PacketLength() => %d, want %d", i, pes.PacketLength(), tc.length)
+			}
+			if pes.HasOptionalHeader() != tc.optHdr {
+				t.Errorf("%0d: PES.HasOptionalHeader() => %t, want %t", i, pes.HasOptionalHeader(), tc.optHdr)
+			}
+			if pes.HasPTS() != tc.hasPTS {
+				t.Errorf("%0d: PES.HasPTS() => %t, want %t", i, pes.HasPTS(), tc.hasPTS)
+			}
+			if pes.HasDTS() != tc.hasDTS {
+				t.Errorf("%0d: PES.HasDTS() => %t, want %t", i, pes.HasDTS(), tc.hasDTS)
+			}
+			if pes.PTS() != tc.pts {
+				t.Errorf("%0d: PES.PTS() => 0x%X, want 0x%X", i, pes.PTS(), tc.pts)
+			}
+			if pes.DTS() != tc.dts {
+				t.Errorf("%0d: PES.DTS() => 0x%X, want 0x%X", i, pes.DTS(), tc.dts)
+			}
+			if !bytes.Equal(pes.Payload(), tc.payload) {
+				t.Errorf("%0d: PES.Payload() => 0x%02X, want 0x%02X", i, pes.Payload(), tc.payload)
+			}
+		})
+	}
+}
+
+func TestBuildPES(t *testing.T) {
+	for i, tc := range []struct {
+		pts, dts int64
+		size     int
+		flags    byte
+		length   int
+	}{
+		{-1, -1, 10, 0x00, 13},
+		{90000, 90000, 10, 0x02, 18},
+		{0x1FFFFFFFF, 90000, 10, 0x03, 23},
+		{90000, 90000, 70000, 0x02, 0},
+	} {
+		data := make([]byte, tc.size)
+		data[0] = 0xAB
+		pes, err := NewPES(BuildPES(0xE0, tc.pts, tc.dts, data))
+		if err != nil {
+			t.Fatalf("%0d: NewPES() causes %s", i, err)
+		}
+		if f := pes.PTSDTSFlags(); f != tc.flags {
+			t.Errorf("%0d: PTSDTSFlags() => %d, want %d", i, f, tc.flags)
+		}
+		if tc.flags != 0 && pes.PTS() != tc.pts {
+			t.Errorf("%0d: PTS() => %d, want %d", i, pes.PTS(), tc.pts)
+		}
+		if tc.flags != 0 && pes.DTS() != tc.dts {
+			t.Errorf("%0d: DTS() => %d, want %d", i, pes.DTS(), tc.dts)
+		}
+		if l := pes.PacketLength(); l != tc.length {
+			t.Errorf("%0d: PacketLength() => %d, want %d", i, l, tc.length)
+		}
+		if pes.DataAlignmentIndicator() != 1 {
+			t.Errorf("%0d: DataAlignmentIndicator() => 0, want 1", i)
+		}
+		if p := pes.Payload(); len(p) != tc.size || p[0] != 0xAB {
+			t.Errorf("%0d: Payload() => % X..., want %d bytes", i, p[:1], tc.size)
+		}
+	}
+}
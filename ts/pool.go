@@ -0,0 +1,51 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "sync"
+
+const (
+	sectionMaxSize   = 4096      // maximum size of the private section
+	pesBufferMinSize = 64 * 1024 // initial capacity of the pooled PES buffer
+)
+
+// The buffers for the section and PES reassembly are reused through the pools.
+// A buffer taken by getBuffer is owned by the taker until putBuffer is
+// called, and neither the buffer nor any slice of it may be used after that.
+var (
+	sectionPool = sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, 0, sectionMaxSize)
+			return &b
+		},
+	}
+	pesPool = sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, 0, pesBufferMinSize)
+			return &b
+		},
+	}
+)
+
+func getBuffer(pool *sync.Pool) *[]byte {
+	return pool.Get().(*[]byte)
+}
+
+// putBuffer returns b to the pool. b is the last slice of the buffer, which
+// may be reallocated by append after getBuffer.
+func putBuffer(pool *sync.Pool, pb *[]byte, b []byte) {
+	*pb = b[:0]
+	pool.Put(pb)
+}
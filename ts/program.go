@@ -0,0 +1,183 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "sort"
+
+// ProgramHandler handles the program.
+type ProgramHandler func(pg *Program) error
+
+// Program is a program found by the Demuxer.
+type Program struct {
+	Number  ProgramNumber
+	PID     PID       // program_map_PID
+	PMT     PMT       // nil until the PMT is received
+	Streams []*Stream // program elements in the order of the PMT
+}
+
+// PCRPID returns the PCR_PID of the program.
+// It returns PidNull if the PMT has not been received.
+func (pg *Program) PCRPID() PID {
+	if pg.PMT == nil {
+		return PidNull
+	}
+	return pg.PMT.PCRPID()
+}
+
+// Stream is an elementary stream of a program.
+type Stream struct {
+	PID        PID
+	StreamType byte
+	Info       ProgramElementInfo
+}
+
+// Programs returns the programs in the current PAT ordered by program_number.
+func (d *Demuxer) Programs() []*Program {
+	pgs := make([]*Program, 0, len(d.programs))
+	for _, pg := range d.programs {
+		pgs = append(pgs, pg)
+	}
+	sort.Slice(pgs, func(i, j int) bool {
+		return pgs[i].Number < pgs[j].Number
+	})
+	return pgs
+}
+
+// Program returns the program by program_number.
+func (d *Demuxer) Program(number ProgramNumber) (*Program, bool) {
+	pg, ok := d.programs[number]
+	return pg, ok
+}
+
+func (d *Demuxer) subscribePSI(pid PID, h SectionHandler) {
+	dp := d.pid(pid)
+	if dp.sec == nil {
+		dp.sec = newSectionBuffer(pid)
+	}
+	dp.psi = h
+}
+
+func (d *Demuxer) unsubscribePSI(pid PID) {
+	if dp, ok := d.pids[pid]; ok {
+		dp.psi = nil
+		dp.release()
+	}
+}
+
+func (d *Demuxer) subscribeStream(st *Stream) {
+	hs := d.typed[st.StreamType]
+	if len(hs) == 0 {
+		return
+	}
+	dp := d.pid(st.PID)
+	if dp.pesbuf == nil {
+		dp.pesbuf = &pesBuffer{}
+	}
+	dp.typed = hs
+}
+
+func (d *Demuxer) unsubscribeStream(st *Stream) {
+	if dp, ok := d.pids[st.PID]; ok {
+		dp.typed = nil
+		dp.release()
+	}
+}
+
+func (d *Demuxer) handlePAT(rx *SectionReceiver) error {
+	pat, err := NewPAT(rx.Bytes())
+	if err != nil || PSI(pat).TableID() != 0x00 || pat.CurrentNextIndicator() != 1 || !PSI(pat).VerifyCRC32() {
+		return nil
+	}
+	for _, cur := range d.pat {
+		if cur.VersionNumber() != pat.VersionNumber() {
+			d.pat = make(map[byte]PAT)
+		}
+		break
+	}
+	if cur, ok := d.pat[pat.SectionNumber()]; ok && cur.VersionNumber() == pat.VersionNumber() {
+		return nil
+	}
+	d.pat[pat.SectionNumber()] = append(PAT{}, pat...)
+
+	m := make(map[ProgramNumber]PID)
+	for _, t := range d.pat {
+		for n, pid := range t.ProgramPIDMap() {
+			m[n] = pid
+		}
+	}
+
+	// remove the programs gone or moved
+	for n, pg := range d.programs {
+		if pid, ok := m[n]; ok && pid == pg.PID {
+			continue
+		}
+		for _, st := range pg.Streams {
+			d.unsubscribeStream(st)
+		}
+		delete(d.programs, n)
+	}
+	pmtPIDs := make(map[PID]bool)
+	for n, pid := range m {
+		pmtPIDs[pid] = true
+		if _, ok := d.programs[n]; !ok {
+			d.programs[n] = &Program{Number: n, PID: pid}
+		}
+	}
+	for pid, dp := range d.pids {
+		if pid != PidPAT && dp.psi != nil && !pmtPIDs[pid] {
+			d.unsubscribePSI(pid)
+		}
+	}
+	for pid := range pmtPIDs {
+		d.subscribePSI(pid, d.handlePMT)
+	}
+	return nil
+}
+
+func (d *Demuxer) handlePMT(rx *SectionReceiver) error {
+	pmt, err := NewPMT(rx.Bytes())
+	if err != nil || PSI(pmt).TableID() != 0x02 || pmt.CurrentNextIndicator() != 1 || !PSI(pmt).VerifyCRC32() {
+		return nil
+	}
+	pg, ok := d.programs[pmt.ProgramNumber()]
+	if !ok || pg.PID != rx.PID {
+		return nil
+	}
+	if pg.PMT != nil && pg.PMT.VersionNumber() == pmt.VersionNumber() {
+		return nil
+	}
+
+	for _, st := range pg.Streams {
+		d.unsubscribeStream(st)
+	}
+	pg.PMT = append(PMT{}, pmt...)
+	pg.Streams = nil
+	for _, info := range pg.PMT.ElementInfo() {
+		st := &Stream{
+			PID:        info.ElementaryPID(),
+			StreamType: info.StreamType(),
+			Info:       info,
+		}
+		pg.Streams = append(pg.Streams, st)
+		d.subscribeStream(st)
+	}
+
+	for _, h := range d.program {
+		if err := h(pg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "testing"
+
+var (
+	testPAT = []byte{
+		0x00, 0xB0, 0x1D, 0x7F, 0xE5, 0xED, 0x00, 0x00, 0x00, 0x00,
+		0xE0, 0x10, 0x04, 0x28, 0xE4, 0x28, 0x04, 0x29, 0xE4, 0x29,
+		0x04, 0x2A, 0xE4, 0x2A, 0x05, 0xA8, 0xFF, 0xC8, 0x8E, 0xFD,
+		0xB2, 0xA4}
+	testPMT = []byte{
+		0x02, 0xB0, 0x63, 0x05, 0xA8, 0xED, 0x00, 0x00, 0xE1,
+		0x01, 0xF0, 0x06, 0xC1, 0x01, 0x88, 0xDE, 0x01, 0xEF, 0x1B,
+		0xE1, 0x81, 0xF0, 0x03, 0x52, 0x01, 0x81, 0x0F, 0xE1, 0x82,
+		0xF0, 0x03, 0x52, 0x01, 0x83, 0x06, 0xE1, 0x84, 0xF0, 0x08,
+		0x52, 0x01, 0x87, 0xFD, 0x03, 0x00, 0x12, 0xAD, 0x0D, 0xF0,
+		0x30, 0xF0, 0x0F, 0x52, 0x01, 0x80, 0xFD, 0x0A, 0x00, 0x0D,
+		0x3F, 0x2F, 0x00, 0x0C, 0x00, 0x00, 0xFF, 0xBF, 0x0D, 0xF0,
+		0x39, 0xF0, 0x03, 0x52, 0x01, 0x89, 0x0D, 0xF0, 0x3A, 0xF0,
+		0x03, 0x52, 0x01, 0x8A, 0x0D, 0xF0, 0x3B, 0xF0, 0x0A, 0x52,
+		0x01, 0x8B, 0xFD, 0x05, 0x00, 0x0D, 0x1F, 0xFF, 0xBF, 0xFE,
+		0x9B, 0xEB, 0xD9}
+	// testPAT version 23 with the program 0x05A8 only
+	testPATUpdated = []byte{
+		0x00, 0xB0, 0x11, 0x7F, 0xE5, 0xEF, 0x00, 0x00, 0x00, 0x00,
+		0xE0, 0x10, 0x05, 0xA8, 0xFF, 0xC8, 0x46, 0x4F, 0xE7, 0x7E}
+)
+
+func TestDemuxerPrograms(t *testing.T) {
+	d := NewDemuxer()
+	var updated []ProgramNumber
+	d.HandleProgram(func(pg *Program) error {
+		updated = append(updated, pg.Number)
+		return nil
+	})
+	var pids []PID
+	d.HandleStreamType(StreamTypeH264, func(pid PID, pes PES) error {
+		pids = append(pids, pid)
+		return nil
+	})
+
+	write := func(b []byte) {
+		if _, err := d.Write(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, testPAT...)))
+	if n := len(d.Programs()); n != 4 {
+		t.Fatalf("Programs() => len: %d, want %d", n, 4)
+	}
+	write(makeTSPacket(0x1FC8, 0, true, append([]byte{0x00}, testPMT...)))
+	write(makeTSPacket(0x0181, 0, true, makeTestPES(0xE0, 184)))
+	write(makeTSPacket(0x0182, 0, true, makeTestPES(0xC0, 184)))
+	write(makeTSPacket(0x0181, 1, true, makeTestPES(0xE0, 184)))
+
+	if len(updated) != 1 || updated[0] != 0x05A8 {
+		t.Errorf("ProgramHandler is called with %v, want [%d]", updated, 0x05A8)
+	}
+	pg, ok := d.Program(0x05A8)
+	if !ok {
+		t.Fatalf("Program(0x%04X) is not found", 0x05A8)
+	}
+	if pg.PID != 0x1FC8 || pg.PCRPID() != 0x0101 || len(pg.Streams) != 7 {
+		t.Errorf("Program(0x%04X) => PID: 0x%04X, PCR_PID: 0x%04X, %d streams", pg.Number, pg.PID, pg.PCRPID(), len(pg.Streams))
+	}
+	if len(pids) != 1 || pids[0] != 0x0181 {
+		t.Errorf("PESHandler is called with %v, want [%d]", pids, 0x0181)
+	}
+
+	write(makeTSPacket(PidPAT, 1, true, append([]byte{0x00}, testPATUpdated...)))
+	if n := len(d.Programs()); n != 1 {
+		t.Errorf("Programs() => len: %d, want %d", n, 1)
+	}
+	if _, ok := d.Program(0x0428); ok {
+		t.Errorf("Program(0x%04X) is found after PAT update", 0x0428)
+	}
+	if _, ok := d.Program(0x05A8); !ok {
+		t.Errorf("Program(0x%04X) is not found after PAT update", 0x05A8)
+	}
+}
+
+func TestDemuxerProgramsCRCError(t *testing.T) {
+	d := NewDemuxer()
+	pat := append([]byte{}, testPAT...)
+	pat[len(pat)-1] ^= 0xFF
+	if _, err := d.Write(makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, pat...))); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(d.Programs()); n != 0 {
+		t.Errorf("Programs() => len: %d, want %d", n, 0)
+	}
+
+	pmt := append([]byte{}, testPMT...)
+	pmt[len(pmt)-1] ^= 0xFF
+	if _, err := d.Write(makeTSPacket(PidPAT, 1, true, append([]byte{0x00}, testPAT...))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Write(makeTSPacket(0x1FC8, 0, true, append([]byte{0x00}, pmt...))); err != nil {
+		t.Fatal(err)
+	}
+	if pg, ok := d.Program(0x05A8); !ok || pg.PMT != nil {
+		t.Errorf("Program(0x%04X) has the PMT with CRC error", 0x05A8)
+	}
+}
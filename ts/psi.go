@@ -17,6 +17,7 @@ package ts
 import (
 	"encoding/binary"
 	"errors"
+	"sort"
 )
 
 const crc32size = 4
@@ -131,7 +132,7 @@ func (t PAT) associations() []assoc {
 	pos := 8
 	fixedsize := 5 // transport_stream_id .. last_section_number
 	n := (PSI(t).SectionLength() - fixedsize - crc32size) / 4
-	for i := 0; i < n; i++ {
+	for i := 0; i < n && pos+4 <= len(t); i++ {
 		a := assoc(t[pos : pos+4])
 		pos += len(a)
 		associations = append(associations, a)
@@ -244,9 +245,30 @@ func (t PMT) ProgramInfoLength() int {
 	return int(uint16(t[11]) | uint16(t[10]&0x0F)<<8)
 }
 
-// Descriptors returns the descriptors.
+// Descriptors returns the descriptors. The ones beyond the section are
+// omitted.
 func (t PMT) Descriptors() []Descriptor {
-	return Descriptors(t[12 : 12+t.ProgramInfoLength()])
+	end := 12 + t.ProgramInfoLength()
+	if end > len(t)-crc32size {
+		end = len(t) - crc32size
+	}
+	if end < 12 {
+		return nil
+	}
+	return Descriptors(t[12:end])
+}
+
+// wellFormed reports whether the section_length of the PMT matches its size,
+// and the program_info_length and the ES_info_lengths fit in the section.
+func (t PMT) wellFormed() bool {
+	if !wellFormedSection(t, 16) {
+		return false
+	}
+	pos := 12 + t.ProgramInfoLength()
+	for _, i := range t.ElementInfo() {
+		pos += len(i)
+	}
+	return pos == len(t)-crc32size
 }
 
 // ElementInfo returns the list of ProgramElementInfo.
@@ -254,8 +276,11 @@ func (t PMT) ElementInfo() []ProgramElementInfo {
 	headsize := 5 // stream_type .. ES_info_length
 	var info []ProgramElementInfo
 	pos := 12 + t.ProgramInfoLength()
-	for pos < len(t)-crc32size {
+	for pos+headsize <= len(t)-crc32size {
 		size := headsize + ProgramElementInfo(t[pos:]).ESInfoLength()
+		if pos+size > len(t)-crc32size {
+			break
+		}
 		i := ProgramElementInfo(t[pos : pos+size])
 		pos += len(i)
 		info = append(info, i)
@@ -266,6 +291,30 @@ func (t PMT) ElementInfo() []ProgramElementInfo {
 // ProgramElementInfo is an information for program element.
 type ProgramElementInfo []byte
 
+// Stream types for program element.
+const (
+	StreamTypeMPEG1Video      = 0x01 // ISO/IEC 11172-2 Video
+	StreamTypeMPEG2Video      = 0x02 // Rec. ITU-T H.262 | ISO/IEC 13818-2 Video
+	StreamTypeMPEG1Audio      = 0x03 // ISO/IEC 11172-3 Audio
+	StreamTypeMPEG2Audio      = 0x04 // ISO/IEC 13818-3 Audio
+	StreamTypePrivateSections = 0x05 // Rec. ITU-T H.222.0 | ISO/IEC 13818-1 private_sections
+	StreamTypePrivateData     = 0x06 // Rec. ITU-T H.222.0 | ISO/IEC 13818-1 PES packets containing private data
+	StreamTypeMHEG            = 0x07 // ISO/IEC 13522 MHEG
+	StreamTypeDSMCC           = 0x08 // Rec. ITU-T H.222.0 | ISO/IEC 13818-1 Annex A DSM-CC
+	StreamTypeDSMCCTypeA      = 0x0A // ISO/IEC 13818-6 type A
+	StreamTypeDSMCCTypeB      = 0x0B // ISO/IEC 13818-6 type B
+	StreamTypeDSMCCTypeC      = 0x0C // ISO/IEC 13818-6 type C
+	StreamTypeDSMCCTypeD      = 0x0D // ISO/IEC 13818-6 type D
+	StreamTypeAAC             = 0x0F // ISO/IEC 13818-7 Audio with ADTS transport syntax
+	StreamTypeMPEG4Video      = 0x10 // ISO/IEC 14496-2 Visual
+	StreamTypeLATMAAC         = 0x11 // ISO/IEC 14496-3 Audio with the LATM transport syntax
+	StreamTypeMetadataPES     = 0x15 // Metadata carried in PES packets
+	StreamTypeH264            = 0x1B // AVC video stream as defined in Rec. ITU-T H.264 | ISO/IEC 14496-10 Video
+	StreamTypeHEVC            = 0x24 // Rec. ITU-T H.265 | ISO/IEC 23008-2 video stream
+	StreamTypeAC3             = 0x81 // ATSC A/52 AC-3 audio (User Private)
+	StreamTypeEAC3            = 0x87 // ATSC A/52 E-AC-3 audio (User Private)
+)
+
 // StreamType returns the stream_type.
 func (i ProgramElementInfo) StreamType() byte {
 	return i[0]
@@ -286,6 +335,12 @@ func (i ProgramElementInfo) Descriptors() []Descriptor {
 	return Descriptors(i[5:])
 }
 
+// wellFormedSection reports whether b is a section of minsize bytes or more
+// sliced to its section_length, with the valid CRC_32.
+func wellFormedSection(b []byte, minsize int) bool {
+	return len(b) >= minsize && sectionMinSize+PSI(b).SectionLength() == len(b) && PSI(b).VerifyCRC32()
+}
+
 // VersionNumber returns the version_number from PSI bytes.
 // Not all of the PSI has this.
 func VersionNumber(b []byte) int {
@@ -309,3 +364,68 @@ func SectionNumber(b []byte) byte {
 func LastSectionNumber(b []byte) byte {
 	return b[7]
 }
+
+// BuildPAT returns a new PAT section with the programs, which map the
+// program_numbers to the program_map_PIDs. The program_number 0 maps to the
+// network_PID.
+func BuildPAT(tsid TransportStreamID, version int, programs map[ProgramNumber]PID) PAT {
+	numbers := make([]ProgramNumber, 0, len(programs))
+	for n := range programs {
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers, func(i, j int) bool {
+		return numbers[i] < numbers[j]
+	})
+	body := make([]byte, 0, 4*len(numbers))
+	for _, n := range numbers {
+		pid := programs[n]
+		body = append(body, byte(n>>8), byte(n), 0xE0|byte(pid>>8&0x1F), byte(pid))
+	}
+	return PAT(buildSection(0x00, uint16(tsid), version, body))
+}
+
+// BuildProgramElementInfo returns a new program element of the PMT.
+func BuildProgramElementInfo(streamType byte, pid PID, descriptors ...Descriptor) ProgramElementInfo {
+	info := ProgramElementInfo{streamType, 0xE0 | byte(pid>>8&0x1F), byte(pid), 0xF0, 0x00}
+	for _, d := range descriptors {
+		info = append(info, d...)
+	}
+	size := len(info) - 5
+	info[3] |= byte(size >> 8 & 0x03)
+	info[4] = byte(size)
+	return info
+}
+
+// BuildPMT returns a new PMT section of the program with the program
+// descriptors and the program elements.
+func BuildPMT(number ProgramNumber, version int, pcrPID PID, descriptors []Descriptor, elements ...ProgramElementInfo) PMT {
+	body := []byte{0xE0 | byte(pcrPID>>8&0x1F), byte(pcrPID), 0xF0, 0x00}
+	for _, d := range descriptors {
+		body = append(body, d...)
+	}
+	size := len(body) - 4
+	body[2] |= byte(size >> 8 & 0x03)
+	body[3] = byte(size)
+	for _, info := range elements {
+		body = append(body, info...)
+	}
+	return PMT(buildSection(0x02, uint16(number), version, body))
+}
+
+// buildSection returns a new section with the long header and the CRC_32.
+func buildSection(tableID TableID, ext uint16, version int, body []byte) []byte {
+	size := 8 + len(body) + crc32size
+	b := make([]byte, 8, size)
+	b[0] = byte(tableID)
+	length := size - sectionMinSize
+	b[1] = 0xB0 | byte(length>>8&0x0F) // section_syntax_indicator, '0', reserved
+	b[2] = byte(length)
+	binary.BigEndian.PutUint16(b[3:5], ext)
+	b[5] = 0xC1 | byte(version<<1)&0x3E // reserved, version_number, current_next_indicator
+	b[6] = 0x00                         // section_number
+	b[7] = 0x00                         // last_section_number
+	b = append(b, body...)
+	b = append(b, 0, 0, 0, 0)
+	PSI(b).UpdateCRC32()
+	return b
+}
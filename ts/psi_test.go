@@ -506,3 +506,65 @@ func TestProgramElementInfo(t *testing.T) {
 		})
 	}
 }
+
+func TestPSIVerifyCRC32(t *testing.T) {
+	for i, b := range [][]byte{testPAT, testPMT} {
+		if !PSI(b).VerifyCRC32() {
+			t.Errorf("%0d: PSI(0x%02X).VerifyCRC32() => false, want true", i, b)
+		}
+		c := append(PSI{}, b...)
+		c[3] ^= 0x01
+		if c.VerifyCRC32() {
+			t.Errorf("%0d: PSI(0x%02X).VerifyCRC32() => true, want false", i, c)
+		}
+		c.UpdateCRC32()
+		if !c.VerifyCRC32() {
+			t.Errorf("%0d: PSI(0x%02X).VerifyCRC32() after UpdateCRC32() => false, want true", i, c)
+		}
+	}
+}
+
+func TestBuildPMT(t *testing.T) {
+	pmt := BuildPMT(3, 5, 0x0100, []Descriptor{BuildDescriptor(TagCA, []byte{0x00, 0x05, 0xE3, 0x00})},
+		BuildProgramElementInfo(StreamTypeH264, 0x0100),
+		BuildProgramElementInfo(StreamTypeAAC, 0x0101, BuildDescriptor(TagISO639Language, []byte("jpn\x00"))),
+	)
+	if !PSI(pmt).VerifyCRC32() {
+		t.Errorf("VerifyCRC32() => false, want true")
+	}
+	if n := pmt.ProgramNumber(); n != 3 {
+		t.Errorf("ProgramNumber() => %d, want 3", n)
+	}
+	if v := pmt.VersionNumber(); v != 5 {
+		t.Errorf("VersionNumber() => %d, want 5", v)
+	}
+	if pid := pmt.PCRPID(); pid != 0x0100 {
+		t.Errorf("PCRPID() => 0x%04X, want 0x0100", pid)
+	}
+	if ds := pmt.Descriptors(); len(ds) != 1 || ds[0].Tag() != TagCA {
+		t.Errorf("Descriptors() => %v, want CA_descriptor", ds)
+	}
+	infos := pmt.ElementInfo()
+	if len(infos) != 2 {
+		t.Fatalf("len(ElementInfo()) => %d, want 2", len(infos))
+	}
+	for i, tc := range []struct {
+		streamType byte
+		pid        PID
+		descs      int
+	}{
+		{StreamTypeH264, 0x0100, 0},
+		{StreamTypeAAC, 0x0101, 1},
+	} {
+		info := infos[i]
+		if st := info.StreamType(); st != tc.streamType {
+			t.Errorf("%0d: StreamType() => 0x%02X, want 0x%02X", i, st, tc.streamType)
+		}
+		if pid := info.ElementaryPID(); pid != tc.pid {
+			t.Errorf("%0d: ElementaryPID() => 0x%04X, want 0x%04X", i, pid, tc.pid)
+		}
+		if n := len(info.Descriptors()); n != tc.descs {
+			t.Errorf("%0d: len(Descriptors()) => %d, want %d", i, n, tc.descs)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"io"
+)
+
+const (
+	packetReaderDefaultPackets = 1024 // packets in the default buffer
+	packetReaderMaxEmptyReads  = 100  // consecutive reads of nothing before io.ErrNoProgress
+)
+
+// PacketReader is a high-throughput reader of the packets of 188 bytes.
+//
+// PacketReader reads a large buffer at once and returns the packets as views
+// of the buffer without allocation. The packet returned by Next is valid only
+// until the next call of Next, so copy it to retain.
+type PacketReader struct {
+	r    io.Reader
+	buf  []byte
+	pos  int   // start of the unread bytes in buf
+	end  int   // end of the bytes in buf
+	base int64 // stream offset of buf[0]
+	off  int64 // stream offset of the last packet
+	err  error
+}
+
+// NewPacketReader returns a new PacketReader to read from r with the default
+// buffer size.
+func NewPacketReader(r io.Reader) *PacketReader {
+	return NewPacketReaderSize(r, packetReaderDefaultPackets)
+}
+
+// NewPacketReaderSize returns a new PacketReader to read from r, whose buffer
+// holds n packets.
+func NewPacketReaderSize(r io.Reader, n int) *PacketReader {
+	if n < 1 {
+		n = 1
+	}
+	return &PacketReader{
+		r:   r,
+		buf: make([]byte, n*packetDefaultSize),
+		off: -1,
+	}
+}
+
+// Reset discards the buffered data and switches to read from r.
+func (pr *PacketReader) Reset(r io.Reader) {
+	pr.r = r
+	pr.pos = 0
+	pr.end = 0
+	pr.base = 0
+	pr.off = -1
+	pr.err = nil
+}
+
+// Next returns the next packet. It returns io.EOF when the stream ends.
+// The bytes not aligned to the sync byte are skipped, and a partial packet at
+// the end of the stream is discarded.
+func (pr *PacketReader) Next() (Packet, error) {
+	for {
+		if pr.pos < pr.end && pr.buf[pr.pos] != SyncByte {
+			i := bytes.IndexByte(pr.buf[pr.pos:pr.end], SyncByte)
+			if i < 0 {
+				pr.pos = pr.end
+			} else {
+				pr.pos += i
+			}
+		}
+		if pr.end-pr.pos >= packetDefaultSize {
+			p := Packet(pr.buf[pr.pos : pr.pos+packetDefaultSize])
+			pr.off = pr.base + int64(pr.pos)
+			pr.pos += packetDefaultSize
+			return p, nil
+		}
+		if pr.err != nil {
+			return nil, pr.err
+		}
+		pr.fill()
+	}
+}
+
+// fill moves the partial packet to the head of the buffer and reads until a
+// whole packet is buffered, so as not to wait for the rest of a live source.
+func (pr *PacketReader) fill() {
+	n := copy(pr.buf, pr.buf[pr.pos:pr.end])
+	pr.base += int64(pr.pos)
+	pr.pos = 0
+	pr.end = n
+	for empty := 0; pr.end < packetDefaultSize && pr.err == nil; {
+		var m int
+		m, pr.err = pr.r.Read(pr.buf[pr.end:])
+		pr.end += m
+		if m > 0 || pr.err != nil {
+			empty = 0
+			continue
+		}
+		// avoid spinning on a reader returning nothing
+		if empty++; empty >= packetReaderMaxEmptyReads {
+			pr.err = io.ErrNoProgress
+		}
+	}
+}
+
+// Offset returns the byte offset of the packet returned last by Next in the
+// stream. It returns -1 before the first packet.
+func (pr *PacketReader) Offset() int64 {
+	return pr.off
+}
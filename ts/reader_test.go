@@ -0,0 +1,129 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestPacketReader(t *testing.T) {
+	var stream []byte
+	stream = concatPacket(stream, []byte{0x00, 0x01, 0x02}) // garbage before the sync
+	for i := 0; i < 5; i++ {
+		stream = concatPacket(stream, makeTSPacket(PID(i), uint8(i), false, nil))
+	}
+	stream = concatPacket(stream, []byte{SyncByte, 0x00}) // partial packet
+
+	// buffer smaller than the stream
+	pr := NewPacketReaderSize(bytes.NewReader(stream), 2)
+	for i := 0; i < 5; i++ {
+		p, err := pr.Next()
+		if err != nil {
+			t.Fatalf("%d: Next() causes %s", i, err)
+		}
+		if p.PID() != PID(i) {
+			t.Errorf("%d: Next().PID() => 0x%04X, want 0x%04X", i, p.PID(), i)
+		}
+		if exp := int64(3 + i*188); pr.Offset() != exp {
+			t.Errorf("%d: Offset() => %d, want %d", i, pr.Offset(), exp)
+		}
+	}
+	if _, err := pr.Next(); err != io.EOF {
+		t.Errorf("Next() causes %v, want %s", err, io.EOF)
+	}
+}
+
+// chunkReader returns the chunks of n bytes after the empty reads.
+type chunkReader struct {
+	b     []byte
+	n     int
+	empty int
+	reads int
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	if r.reads++; r.reads <= r.empty {
+		return 0, nil
+	}
+	r.reads = 0
+	n := copy(b[:min(len(b), r.n)], r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
+
+func TestPacketReaderChunks(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 3; i++ {
+		stream = concatPacket(stream, makeTSPacket(PID(i), uint8(i), false, nil))
+	}
+
+	// a packet is returned before the buffer is filled
+	r := &chunkReader{b: stream, n: 100}
+	pr := NewPacketReader(r)
+	if p, err := pr.Next(); err != nil || p.PID() != 0 {
+		t.Fatalf("Next() => %v, %v, want PID 0x0000", p, err)
+	}
+	if len(r.b) != len(stream)-200 {
+		t.Errorf("Next() reads %d bytes, want 200", len(stream)-len(r.b))
+	}
+
+	for i, tc := range []struct {
+		empty   int
+		packets int
+		err     error
+	}{
+		{packetReaderMaxEmptyReads - 1, 3, io.EOF},
+		{packetReaderMaxEmptyReads, 0, io.ErrNoProgress},
+	} {
+		pr := NewPacketReader(&chunkReader{b: stream, n: 100, empty: tc.empty})
+		packets := 0
+		var err error
+		for {
+			if _, err = pr.Next(); err != nil {
+				break
+			}
+			packets++
+		}
+		if packets != tc.packets || err != tc.err {
+			t.Errorf("%0d: Next() returns %d packets and causes %v, want %d packets and %s", i, packets, err, tc.packets, tc.err)
+		}
+	}
+}
+
+func BenchmarkPacketReader(b *testing.B) {
+	var stream []byte
+	for i := 0; i < 1000; i++ {
+		stream = concatPacket(stream, makeTSPacket(PID(i), uint8(i), false, nil))
+	}
+	r := bytes.NewReader(stream)
+	pr := NewPacketReader(r)
+	b.SetBytes(int64(len(stream)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(stream)
+		pr.Reset(r)
+		for {
+			if _, err := pr.Next(); err != nil {
+				break
+			}
+		}
+	}
+}
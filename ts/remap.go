@@ -0,0 +1,228 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrPIDCollision is returned when PIDs are remapped to the same PID.
+var ErrPIDCollision = errors.New("ts: PID collision")
+
+// ErrReservedPID is returned when a reserved PID is remapped.
+var ErrReservedPID = errors.New("ts: reserved PID")
+
+// Remapper renumbers the PIDs of a transport stream.
+//
+// The PIDs of the packets are rewritten, and so are the program_map_PIDs in
+// the PAT, the PCR_PID, the elementary_PIDs and the CA_PIDs in the PMT and
+// the CA_PIDs in the CAT, whose sections are sent with a new CRC_32. The
+// PIDs not in the map are left as they are. The sections with the invalid
+// CRC_32 or lengths are sent as they are.
+type Remapper struct {
+	m map[PID]PID
+}
+
+// NewRemapper returns a new Remapper by the map from the PIDs of the input to
+// those of the output. It returns ErrReservedPID if the map has the PIDs of
+// 0x0000 to 0x000F or the null packet, and ErrPIDCollision if the map has the
+// same output PID for multiple input PIDs.
+func NewRemapper(m map[PID]PID) (*Remapper, error) {
+	to := make(map[PID]bool)
+	rm := &Remapper{m: make(map[PID]PID)}
+	for src, dst := range m {
+		if isReservedPID(src) || isReservedPID(dst) {
+			return nil, ErrReservedPID
+		}
+		if to[dst] {
+			return nil, ErrPIDCollision
+		}
+		to[dst] = true
+		rm.m[src] = dst
+	}
+	return rm, nil
+}
+
+func isReservedPID(pid PID) bool {
+	return pid <= 0x000F || pid >= PidNull
+}
+
+// PID returns the output PID of the input PID.
+func (rm *Remapper) PID(pid PID) PID {
+	if dst, ok := rm.m[pid]; ok {
+		return dst
+	}
+	return pid
+}
+
+// remapping is the state of a remapping.
+type remapping struct {
+	*Remapper
+	w      io.Writer
+	d      *Demuxer
+	to     map[PID]bool // output PIDs of the map
+	pmts   map[PID]bool // PMT PIDs in the current PAT
+	subs   map[PID]bool // PMT PIDs subscribed
+	psi    map[PID]*Packetizer
+	packet Packet
+}
+
+// Remap reads the stream from r and writes the remapped stream to w. It
+// returns ErrPIDCollision if a PID not in the map is found in the stream
+// and it is the output PID of another one.
+func (rm *Remapper) Remap(w io.Writer, r io.Reader) error {
+	x := &remapping{
+		Remapper: rm,
+		w:        w,
+		d:        NewDemuxer(),
+		to:       make(map[PID]bool),
+		pmts:     make(map[PID]bool),
+		subs:     make(map[PID]bool),
+		psi:      make(map[PID]*Packetizer),
+		packet:   make(Packet, packetDefaultSize),
+	}
+	for src, dst := range rm.m {
+		if src != dst {
+			x.to[dst] = true
+		}
+	}
+	x.d.HandleSection(PidPAT, x.handlePAT)
+	x.d.HandleSection(PidCAT, x.handleCAT)
+
+	pr := NewPacketReader(r)
+	for {
+		p, err := pr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := x.d.WritePacket(p); err != nil {
+			return err
+		}
+		if err := x.write(p); err != nil {
+			return err
+		}
+	}
+}
+
+// write writes the packet with the PID remapped unless it carries PSI.
+func (x *remapping) write(p Packet) error {
+	pid := p.PID()
+	if pid == PidPAT || pid == PidCAT || x.pmts[pid] {
+		return nil
+	}
+	dst, ok := x.m[pid]
+	if !ok {
+		if x.to[pid] {
+			return ErrPIDCollision
+		}
+		_, err := x.w.Write(p)
+		return err
+	}
+	x.packet = append(x.packet[:0], p...)
+	putPID(x.packet[1:3], dst)
+	_, err := x.w.Write(x.packet)
+	return err
+}
+
+// writeSection writes the section in the packets of the PID.
+func (x *remapping) writeSection(pid PID, section []byte) error {
+	pz, ok := x.psi[pid]
+	if !ok {
+		pz = NewPacketizer(pid)
+		x.psi[pid] = pz
+	}
+	for _, p := range pz.Section(section) {
+		if _, err := x.w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *remapping) handlePAT(rx *SectionReceiver) error {
+	pat := PAT(append([]byte{}, rx.Bytes()...))
+	if !wellFormedSection(pat, 12) {
+		return x.writeSection(PidPAT, pat)
+	}
+	if PSI(pat).TableID() == 0x00 && pat.CurrentNextIndicator() == 1 {
+		if pat.SectionNumber() == 0 {
+			x.pmts = make(map[PID]bool)
+		}
+		for _, pid := range pat.ProgramPIDMap() {
+			x.pmts[pid] = true
+			if !x.subs[pid] {
+				x.subs[pid] = true
+				x.d.HandleSection(pid, x.handlePMT(pid))
+			}
+		}
+	}
+	if PSI(pat).TableID() == 0x00 {
+		for _, a := range pat.associations() {
+			putPID(a[2:4], x.PID(a.pid()))
+		}
+		PSI(pat).UpdateCRC32()
+	}
+	return x.writeSection(PidPAT, pat)
+}
+
+func (x *remapping) handlePMT(pid PID) SectionHandler {
+	return func(rx *SectionReceiver) error {
+		if !x.pmts[pid] {
+			return nil
+		}
+		if _, ok := x.m[pid]; !ok && x.to[pid] {
+			return ErrPIDCollision
+		}
+		pmt := PMT(append([]byte{}, rx.Bytes()...))
+		if pmt.wellFormed() && PSI(pmt).TableID() == 0x02 {
+			remapPMT(pmt, x.PID)
+			PSI(pmt).UpdateCRC32()
+		}
+		return x.writeSection(x.PID(pid), pmt)
+	}
+}
+
+func (x *remapping) handleCAT(rx *SectionReceiver) error {
+	cat := CAT(append([]byte{}, rx.Bytes()...))
+	if wellFormedSection(cat, 12) && PSI(cat).TableID() == 0x01 {
+		remapCAPIDs(cat.Descriptors(), x.PID)
+		PSI(cat).UpdateCRC32()
+	}
+	return x.writeSection(PidCAT, cat)
+}
+
+// remapPMT rewrites the PCR_PID, the elementary_PIDs and the CA_PIDs of the
+// PMT in place by pid. The CRC_32 is left as it is.
+func remapPMT(pmt PMT, pid func(PID) PID) {
+	putPID(pmt[8:10], pid(pmt.PCRPID()))
+	remapCAPIDs(pmt.Descriptors(), pid)
+	for _, info := range pmt.ElementInfo() {
+		putPID(info[1:3], pid(info.ElementaryPID()))
+		remapCAPIDs(info.Descriptors(), pid)
+	}
+}
+
+// remapCAPIDs rewrites the CA_PIDs of the CA_descriptors in place by pid.
+func remapCAPIDs(descriptors []Descriptor, pid func(PID) PID) {
+	for _, d := range descriptors {
+		if d.Tag() == TagCA && d.Length() >= 4 {
+			putPID(d[4:6], pid(caPID(d)))
+		}
+	}
+}
@@ -0,0 +1,178 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestNewRemapper(t *testing.T) {
+	for i, tc := range []struct {
+		m   map[PID]PID
+		err error
+	}{
+		{map[PID]PID{0x100: 0x200, 0x200: 0x100}, nil},
+		{map[PID]PID{0x100: 0x300, 0x200: 0x300}, ErrPIDCollision},
+		{map[PID]PID{PidPAT: 0x100}, ErrReservedPID},
+		{map[PID]PID{0x100: PidCAT}, ErrReservedPID},
+		{map[PID]PID{0x100: PidNull}, ErrReservedPID},
+	} {
+		if _, err := NewRemapper(tc.m); err != tc.err {
+			t.Errorf("%0d: NewRemapper(%v) causes %v, want %v", i, tc.m, err, tc.err)
+		}
+	}
+}
+
+func TestRemapper(t *testing.T) {
+	stream := makeTestMPTS()
+	rm, err := NewRemapper(map[PID]PID{
+		0x100:  0x1100,
+		0x101:  0x100,
+		0x300:  0x1300,
+		0x1000: 0x1010,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := rm.Remap(&out, bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != len(stream) {
+		t.Errorf("Remap() outputs %d bytes, want %d", out.Len(), len(stream))
+	}
+
+	d := NewDemuxer()
+	var events []Event
+	d.Observe(func(e Event) {
+		events = append(events, e)
+	})
+	var crcErrors int
+	for _, pid := range []PID{PidPAT, 0x1010, 0x1001} {
+		d.HandleSection(pid, func(rx *SectionReceiver) error {
+			if !PSI(rx.Bytes()).VerifyCRC32() {
+				crcErrors++
+			}
+			return nil
+		})
+	}
+	counts := make(map[PID]int)
+	for _, pid := range []PID{0x100, 0x101, 0x1100, 0x1300, 0x300} {
+		pid := pid
+		d.HandlePacket(pid, func(p Packet) error {
+			counts[pid]++
+			return nil
+		})
+	}
+	if _, err := d.Write(out.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Remap() causes events %v", events)
+	}
+	if crcErrors != 0 {
+		t.Errorf("Remap() outputs %d sections with invalid CRC_32", crcErrors)
+	}
+	if want := map[PID]int{0x100: 2, 0x1100: 2, 0x1300: 2}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("Remap() outputs packets %v, want %v", counts, want)
+	}
+
+	pg, ok := d.Program(1)
+	if !ok || pg.PMT == nil {
+		t.Fatal("Remap() outputs no program 1")
+	}
+	if pg.PID != 0x1010 {
+		t.Errorf("program_map_PID => 0x%04X, want 0x1010", pg.PID)
+	}
+	if pid := pg.PCRPID(); pid != 0x1100 {
+		t.Errorf("PCRPID() => 0x%04X, want 0x1100", pid)
+	}
+	var pids []PID
+	for _, st := range pg.Streams {
+		pids = append(pids, st.PID)
+	}
+	if want := []PID{0x1100, 0x100}; !reflect.DeepEqual(pids, want) {
+		t.Errorf("elementary_PIDs => %v, want %v", pids, want)
+	}
+	if ca := caPIDs(pg.PMT.Descriptors()); !reflect.DeepEqual(ca, []PID{0x1300}) {
+		t.Errorf("CA_PIDs => %v, want [0x1300]", ca)
+	}
+	if pg, ok := d.Program(2); !ok || pg.PID != 0x1001 || pg.PCRPID() != 0x200 {
+		t.Errorf("Remap() changes program 2 => %v", pg)
+	}
+
+	rm, err = NewRemapper(map[PID]PID{0x100: 0x200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.Remap(&bytes.Buffer{}, bytes.NewReader(stream)); err != ErrPIDCollision {
+		t.Errorf("Remap() causes %v, want %s", err, ErrPIDCollision)
+	}
+}
+
+func TestRemapperCorruptSections(t *testing.T) {
+	pat := BuildPAT(0x0001, 0, map[ProgramNumber]PID{1: 0x1000})
+	pat[len(pat)-1] ^= 0xFF // CRC_32
+	pmt := BuildPMT(1, 0, 0x0100, nil, BuildProgramElementInfo(StreamTypeH264, 0x0100))
+	pmt[10], pmt[11] = 0xFF, 0xFF // program_info_length beyond the section
+	PSI(pmt).UpdateCRC32()
+	var stream []byte
+	for _, p := range NewPacketizer(PidPAT).Section(pat) {
+		stream = append(stream, p...)
+	}
+	for _, p := range NewPacketizer(0x1000).Section(pmt) {
+		stream = append(stream, p...)
+	}
+
+	rm, err := NewRemapper(map[PID]PID{0x100: 0x200, 0x1000: 0x1010})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := rm.Remap(&out, bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes()[:packetDefaultSize], stream[:packetDefaultSize]) {
+		t.Errorf("Remap() => PAT %X, want it as it is", out.Bytes()[:packetDefaultSize])
+	}
+
+	// the PMT announced by a valid PAT
+	pat = BuildPAT(0x0001, 0, map[ProgramNumber]PID{1: 0x1000})
+	stream = stream[:0]
+	for _, p := range NewPacketizer(PidPAT).Section(pat) {
+		stream = append(stream, p...)
+	}
+	for _, p := range NewPacketizer(0x1000).Section(pmt) {
+		stream = append(stream, p...)
+	}
+	out.Reset()
+	if err := rm.Remap(&out, bytes.NewReader(stream)); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	d := NewDemuxer()
+	d.HandleSection(0x1010, func(rx *SectionReceiver) error {
+		got = append([]byte{}, rx.Bytes()...)
+		return nil
+	})
+	if _, err := d.Write(out.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, pmt) {
+		t.Errorf("Remap() => PMT %X, want %X", got, []byte(pmt))
+	}
+}
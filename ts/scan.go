@@ -17,6 +17,7 @@ package ts
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"io"
 )
 
@@ -63,13 +64,15 @@ func (s *PacketScanner) Packet() Packet {
 }
 
 // SectionScanner reads the sections.
+//
+// SectionScanner sends the sections to the channels given to
+// NewSectionScanner. SectionReader provides the same scanning without
+// channels, which returns errors and can be cancelled.
 type SectionScanner struct {
-	r      io.Reader  // The reader provided by the client.
-	filter FilterFunc // The function to filter the tokens.
-	buf    map[PID]*sectionBuffer
-	ch     chan *SectionReceiver
-	done   chan bool
-	fail   chan error
+	sr   *SectionReader
+	ch   chan *SectionReceiver
+	done chan bool
+	fail chan error
 }
 
 // FilterFunc is the signature of the filter function used to filter the
@@ -78,8 +81,9 @@ type FilterFunc func(pid PID) bool
 
 // SectionReceiver is a section bytes with PID.
 type SectionReceiver struct {
-	PID PID
-	buf []byte
+	PID  PID
+	buf  []byte
+	pool *[]byte
 }
 
 // Bytes returns the bytes.
@@ -87,171 +91,368 @@ func (rx *SectionReceiver) Bytes() []byte {
 	return rx.buf
 }
 
+// Release returns the buffer of the section to the pool for reuse.
+// Neither rx nor the bytes returned by Bytes may be used after Release.
+//
+// Calling Release is optional. The receivers not released are just collected
+// by GC.
+func (rx *SectionReceiver) Release() {
+	if rx.pool != nil {
+		putBuffer(&sectionPool, rx.pool, rx.buf)
+	}
+	rx.buf = nil
+	rx.pool = nil
+}
+
 // NewSectionScanner returns a new SectionScanner to read from r.
 func NewSectionScanner(r io.Reader, ch chan *SectionReceiver, done chan bool, fail chan error) *SectionScanner {
 	return &SectionScanner{
-		r:      r,
-		filter: NoopFilter,
-		buf:    make(map[PID]*sectionBuffer),
-		ch:     ch,
-		done:   done,
-		fail:   fail,
+		sr:   NewSectionReader(r),
+		ch:   ch,
+		done: done,
+		fail: fail,
 	}
 }
 
 // Scan scans packets, merges by PID and send it to the channel.
+//
+// Scan sends true to done when it reaches the end of the stream, or an error
+// to fail when it stops on the error. Exactly one of them is sent.
 func (s *SectionScanner) Scan() {
-	ps := NewPacketScanner(s.r)
-	for ps.Scan() {
-		p := ps.Packet()
-		pid := p.PID()
-		if !s.filter(pid) {
-			continue
+	for {
+		rx, err := s.sr.Next()
+		if err == io.EOF {
+			s.done <- true
+			return
 		}
+		if err != nil {
+			s.fail <- err
+			return
+		}
+		s.ch <- rx
+	}
+}
+
+// SectionReader reads the sections one by one.
+type SectionReader struct {
+	ps       *PacketScanner
+	filter   FilterFunc
+	secf     SectionFilter
+	observer Observer
+	skipTEI  bool
+	buf      map[PID]*sectionBuffer
+	queue    []*SectionReceiver // sections completed but not yet returned
+	n        int64              // number of the packets read
+}
+
+// NewSectionReader returns a new SectionReader to read from r.
+func NewSectionReader(r io.Reader) *SectionReader {
+	return &SectionReader{
+		ps:     NewPacketScanner(r),
+		filter: NoopFilter,
+		buf:    make(map[PID]*sectionBuffer),
+	}
+}
+
+// Filter sets the filter function for the SectionReader.
+// The default filter function is NoopFilter.
+func (s *SectionReader) Filter(filter FilterFunc) {
+	s.filter = filter
+}
+
+// FilterSection sets the filter function for the sections. The sections not
+// matched are not returned.
+func (s *SectionReader) FilterSection(filter SectionFilter) {
+	s.secf = filter
+}
 
-		sec, ok := s.buf[pid]
-		if !ok {
-			sec = newSectionBuffer(pid)
-			s.buf[pid] = sec
+// Observe sets the observer of the events found in the packets of the PIDs
+// passed by the filter function.
+func (s *SectionReader) Observe(o Observer) {
+	s.observer = o
+}
+
+// SkipTransportErrors sets whether the packets with transport_error_indicator
+// are skipped. They are processed as normal by default.
+func (s *SectionReader) SkipTransportErrors(skip bool) {
+	s.skipTEI = skip
+}
+
+// Next returns the next section.
+// It returns io.EOF when the stream ends.
+func (s *SectionReader) Next() (*SectionReceiver, error) {
+	return s.next(context.Background())
+}
+
+// next returns the next section, checking ctx before each packet.
+func (s *SectionReader) next(ctx context.Context) (*SectionReceiver, error) {
+	for len(s.queue) == 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !s.ps.Scan() {
+			if err := s.ps.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		// the payload is copied to the section buffer
+		if err := s.packet(Packet(s.ps.Bytes())); err != nil {
+			return nil, err
 		}
+	}
+	rx := s.queue[0]
+	s.queue[0] = nil
+	s.queue = s.queue[1:]
+	return rx, nil
+}
 
-		// reset cc by discontinuity indicator
-		af, err := p.AdaptationField()
+// Scan calls handler for each section until the stream ends, ctx is done or
+// handler returns an error. It returns nil at the end of the stream,
+// otherwise the error of ctx or handler.
+//
+// Scan checks ctx between packets, so it can not interrupt a blocking Read of
+// the underlying reader.
+func (s *SectionReader) Scan(ctx context.Context, handler func(*SectionReceiver) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rx, err := s.next(ctx)
+		if err == io.EOF {
+			return nil
+		}
 		if err != nil {
-			s.fail <- err
-			return
+			return err
 		}
-		if af != nil && af.IsDiscontinuous() {
-			sec.cc = -1
+		if err := handler(rx); err != nil {
+			return err
 		}
+	}
+}
 
-		// check continuously
-		if !sec.isContinuous(pid, int(p.ContinuityCounter())) {
-			continue
+func (s *SectionReader) packet(p Packet) error {
+	n := s.n
+	s.n++
+	pid := p.PID()
+	if !s.filter(pid) {
+		return nil
+	}
+
+	if p.HasTransportError() {
+		s.observer.observe(Event{Type: EventTransportError, PID: pid, Packet: n})
+		if s.skipTEI {
+			return nil
 		}
+	}
 
-		// append
-		sec.depacketize(s.ch, p.Payload(), p.IsPayloadUnitStart())
+	sec, ok := s.buf[pid]
+	if !ok {
+		sec = newSectionBuffer(pid)
+		s.buf[pid] = sec
 	}
-	err := ps.Err()
+
+	ok, drop, err := inspect(p, n, &sec.continuity, s.observer)
 	if err != nil {
-		s.fail <- err
+		return err
+	}
+	if drop {
+		sec.drop()
+	}
+	if !ok {
+		return nil
 	}
-	s.done <- true
+
+	// append
+	if sec.depacketize(p.Payload(), p.IsPayloadUnitStart(), s.enqueue) {
+		s.observer.observe(Event{Type: EventTruncatedSection, PID: pid, Packet: n})
+	}
+	return nil
+}
+
+func (s *SectionReader) enqueue(rx *SectionReceiver) {
+	if s.secf != nil && !s.secf(rx.buf) {
+		return
+	}
+	s.queue = append(s.queue, rx)
 }
 
 type sectionBuffer struct {
+	continuity
 	pid  PID
-	buf  []byte
-	cc   int
-	dup  int
-	size int
-	n    int
+	buf  []byte  // nil when no section is in progress
+	pool *[]byte // pooled buffer of buf
+	size int     // table_id .. CRC_32
 }
 
 func newSectionBuffer(pid PID) *sectionBuffer {
 	return &sectionBuffer{
-		pid: pid,
-		cc:  -1,
+		continuity: newContinuity(),
+		pid:        pid,
 	}
 }
 
-func (sec *sectionBuffer) init(payload Payload) int {
-	sec.n = 0
-	sec.size = PSI(payload).SectionLength()
-	headsize := 3 // table_id .. section_length
-	sec.buf = payload[:headsize]
-	return headsize
+// continuity tracks the continuity_counter of a PID.
+type continuity struct {
+	cc  int
+	dup int
 }
 
-func (sec *sectionBuffer) isContinuous(pid PID, cc int) bool {
-	if sec.cc == -1 || pid == PidNull {
-		sec.cc = cc
-		return true
+func newContinuity() continuity {
+	return continuity{cc: -1}
+}
+
+// ccStatus is a result of the continuity check.
+type ccStatus int
+
+const (
+	ccOK        ccStatus = iota // continuous
+	ccDuplicate                 // same as the previous one
+	ccError                     // not the expected one
+)
+
+// check checks the continuity of the packet with cc. It reports whether the
+// data buffered from the previous packets should be dropped, and the expected
+// continuity_counter.
+func (c *continuity) check(pid PID, cc int) (st ccStatus, drop bool, exp int) {
+	if c.cc == -1 || pid == PidNull {
+		c.cc = cc
+		return ccOK, false, cc
 	}
 
-	pre := sec.cc
-	exp := pre + 1
+	pre := c.cc
+	exp = pre + 1
 	if exp > 15 {
 		exp = 0
 	}
-	sec.cc = cc
+	c.cc = cc
 
 	// duplication
 	if pre == cc {
 		// pass to drop if first dup
-		if sec.dup >= 1 {
-			sec.drop()
-		}
-		sec.dup++
-		return false
+		drop = c.dup >= 1
+		c.dup++
+		return ccDuplicate, drop, exp
 	}
-	sec.dup = 0
+	c.dup = 0
 
 	// continuous
 	if exp != cc {
-		sec.drop()
-		return false
+		return ccError, true, exp
 	}
 
-	return true
+	return ccOK, false, exp
+}
+
+func (sec *sectionBuffer) start() {
+	if sec.pool == nil {
+		sec.pool = getBuffer(&sectionPool)
+	}
+	sec.buf = (*sec.pool)[:0]
+	sec.size = 0
 }
 
 func (sec *sectionBuffer) drop() {
+	if sec.pool != nil {
+		putBuffer(&sectionPool, sec.pool, sec.buf)
+	}
+	sec.pool = nil
 	sec.flush()
 }
 
+// flush clears the section in progress after its buffer has been handed over.
 func (sec *sectionBuffer) flush() {
-	sec.buf = []byte{}
+	sec.buf = nil
+	sec.pool = nil
 	sec.size = 0
-	sec.n = 0
 }
 
-func (sec *sectionBuffer) depacketize(ch chan *SectionReceiver, payload Payload, atStart bool) {
+// depacketize appends the payload to the sections and calls emit for each
+// section completed. It reports whether a section in progress is truncated by
+// the start of the next one.
+func (sec *sectionBuffer) depacketize(payload Payload, atStart bool, emit func(*SectionReceiver)) (truncated bool) {
+	if len(payload) == 0 {
+		return false
+	}
 	if !atStart {
-		sec.mergesend(payload, ch)
+		// a section never starts in the packet without payload_unit_start_indicator,
+		// so the rest is stuffing.
+		if sec.buf != nil {
+			sec.merge(payload, emit)
+		}
+		return false
+	}
+	if payload.IsPES() {
+		sec.drop()
+		return false
 	}
 
-	pos := 0
+	pos := 1
 	size := len(payload)
 
-	if payload.IsPSI() {
-		pf := payload.PointerField()
-		pos++
-		if pf > 0 {
-			// append buffer indicated by pointer_field to previous payload
-			high := pos + pf
-			if high > size {
-				high = size
-			}
-			buf := payload[pos:high]
-			pos += len(buf)
-			sec.mergesend(buf, ch)
+	// the bytes indicated by pointer_field belong to the previous section
+	high := pos + payload.PointerField()
+	if high > size {
+		high = size
+	}
+	if sec.buf != nil {
+		sec.merge(payload[pos:high], emit)
+		if sec.buf != nil {
+			// the previous section is truncated
+			sec.drop()
+			truncated = true
 		}
+	}
+	pos = high
 
-		for pos+sectionMinSize < size {
-			pos += sec.init(payload[pos:])
+	// 0xFF as table_id means the stuffing until the end of the packet
+	for pos < size && payload[pos] != 0xFF {
+		sec.start()
+		pos += sec.merge(payload[pos:], emit)
+	}
+	return truncated
+}
 
-			high := pos + sec.size
-			if high > size {
-				high = size
-			}
-			buf := payload[pos:high]
-			pos += len(buf)
-			sec.mergesend(buf, ch)
+// merge appends data to the section in progress and calls emit if the section
+// is completed. It returns the number of bytes consumed from data.
+func (sec *sectionBuffer) merge(data []byte, emit func(*SectionReceiver)) int {
+	n := 0
+	if len(sec.buf) < sectionMinSize {
+		k := sectionMinSize - len(sec.buf)
+		if k > len(data) {
+			k = len(data)
+		}
+		sec.buf = append(sec.buf, data[:k]...)
+		n += k
+		if len(sec.buf) < sectionMinSize {
+			return n
 		}
-	} else {
-		panic("TODO: PES")
+		sec.size = sectionMinSize + PSI(sec.buf).SectionLength()
 	}
-}
 
-func (sec *sectionBuffer) mergesend(data []byte, ch chan *SectionReceiver) {
-	sec.buf = append(sec.buf, data...)
-	sec.n += len(data)
-	if sec.n >= sec.size && len(sec.buf) > 0 {
-		ch <- &SectionReceiver{sec.pid, sec.buf}
+	k := sec.size - len(sec.buf)
+	if k > len(data)-n {
+		k = len(data) - n
+	}
+	sec.buf = append(sec.buf, data[n:n+k]...)
+	n += k
+	if len(sec.buf) == sec.size {
+		emit(&SectionReceiver{sec.pid, sec.buf, sec.pool})
 		sec.flush()
 	}
+	return n
+}
+
+// Observe sets the observer of the events.
+// See SectionReader.Observe.
+func (s *SectionScanner) Observe(o Observer) {
+	s.sr.Observe(o)
+}
+
+// SkipTransportErrors sets whether the packets with transport_error_indicator
+// are skipped. See SectionReader.SkipTransportErrors.
+func (s *SectionScanner) SkipTransportErrors(skip bool) {
+	s.sr.SkipTransportErrors(skip)
 }
 
 // Filter sets the filter function for the SectionScanner.
@@ -259,7 +460,7 @@ func (sec *sectionBuffer) mergesend(data []byte, ch chan *SectionReceiver) {
 //
 // Filter NOT panics if it is called after scanning has started.
 func (s *SectionScanner) Filter(filter FilterFunc) {
-	s.filter = filter
+	s.sr.Filter(filter)
 }
 
 // NoopFilter is a filter function for a SectionScanner that always returns true.
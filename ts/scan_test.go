@@ -16,6 +16,9 @@ package ts
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
 	"testing"
 )
 
@@ -98,3 +101,206 @@ func TestScanExtendedSizePacket(t *testing.T) {
 		t.Errorf("got: %d, expected: %d", len(s.Bytes()), 204)
 	}
 }
+
+func makeTSPacket(pid PID, cc uint8, pusi bool, payload []byte) []byte {
+	p := makeTestPacket(188, 0xFF)
+	p[1] = byte(pid >> 8 & 0x1F)
+	if pusi {
+		p[1] |= 0x40
+	}
+	p[2] = byte(pid)
+	p[3] = 0x10 | cc&0x0F
+	copy(p[4:], payload)
+	return p
+}
+
+func makeTestSection(tableID byte, size int) []byte {
+	b := make([]byte, size)
+	b[0] = tableID
+	b[1] = 0xB0 | byte((size-3)>>8&0x0F)
+	b[2] = byte(size - 3)
+	for i := 3; i < size; i++ {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func TestSectionReader(t *testing.T) {
+	s1 := makeTestSection(0x00, 32)
+	s2 := makeTestSection(0x40, 300)
+	s3 := makeTestSection(0x41, 20)
+
+	var stream []byte
+	// s1 in a packet
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, s1...)))
+	// s2 over two packets, followed by s3
+	stream = concatPacket(stream, makeTSPacket(0x10, 0, true, append([]byte{0x00}, s2[:183]...)))
+	rest := append([]byte{byte(len(s2) - 183)}, s2[183:]...)
+	rest = append(rest, s3...)
+	stream = concatPacket(stream, makeTSPacket(0x10, 1, true, rest))
+
+	sr := NewSectionReader(bytes.NewReader(stream))
+	for i, exp := range []struct {
+		pid PID
+		b   []byte
+	}{
+		{PidPAT, s1},
+		{0x10, s2},
+		{0x10, s3},
+	} {
+		rx, err := sr.Next()
+		if err != nil {
+			t.Fatalf("%d: Next() causes %s", i, err)
+		}
+		if rx.PID != exp.pid {
+			t.Errorf("%d: Next().PID => 0x%04X, want 0x%04X", i, rx.PID, exp.pid)
+		}
+		if !bytes.Equal(rx.Bytes(), exp.b) {
+			t.Errorf("%d: Next().Bytes() => 0x%02X, want 0x%02X", i, rx.Bytes(), exp.b)
+		}
+	}
+	if _, err := sr.Next(); err != io.EOF {
+		t.Errorf("Next() causes %v, want %s", err, io.EOF)
+	}
+}
+
+func TestSectionReaderScan(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 4; i++ {
+		s := makeTestSection(0x00, 32)
+		stream = concatPacket(stream, makeTSPacket(PidPAT, uint8(i), true, append([]byte{0x00}, s...)))
+	}
+
+	errStop := errors.New("stop")
+	n := 0
+	err := NewSectionReader(bytes.NewReader(stream)).Scan(context.Background(), func(rx *SectionReceiver) error {
+		n++
+		if n == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || n != 2 {
+		t.Errorf("Scan() causes %v after %d sections, want %s after 2", err, n, errStop)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = NewSectionReader(bytes.NewReader(stream)).Scan(ctx, func(rx *SectionReceiver) error {
+		t.Error("handler is called after cancel")
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("Scan() causes %v, want %s", err, context.Canceled)
+	}
+}
+
+// cancelReader cancels the context at the first Read.
+type cancelReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(b []byte) (int, error) {
+	r.cancel()
+	return r.r.Read(b)
+}
+
+func TestSectionReaderScanCancelBetweenPackets(t *testing.T) {
+	var stream []byte
+	for i := 0; i < 8; i++ {
+		stream = concatPacket(stream, makeTSPacket(PidNull, 0, false, nil))
+	}
+	s := makeTestSection(0x00, 32)
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, s...)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &cancelReader{r: bytes.NewReader(stream), cancel: cancel}
+	err := NewSectionReader(r).Scan(ctx, func(rx *SectionReceiver) error {
+		t.Error("handler is called after cancel")
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("Scan() causes %v, want %s", err, context.Canceled)
+	}
+}
+
+func TestSectionScanner(t *testing.T) {
+	s := makeTestSection(0x00, 32)
+	stream := makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, s...))
+
+	ch := make(chan *SectionReceiver)
+	done := make(chan bool)
+	fail := make(chan error)
+	go NewSectionScanner(bytes.NewReader(stream), ch, done, fail).Scan()
+
+	n := 0
+	for {
+		select {
+		case rx := <-ch:
+			if !bytes.Equal(rx.Bytes(), s) {
+				t.Errorf("got: 0x%02X, expected: 0x%02X", rx.Bytes(), s)
+			}
+			n++
+		case err := <-fail:
+			t.Fatal(err)
+		case <-done:
+			if n != 1 {
+				t.Errorf("got %d sections, expected: 1", n)
+			}
+			return
+		}
+	}
+}
+
+func TestSectionReaderObserve(t *testing.T) {
+	s := makeTestSection(0x00, 32)
+	long := makeTestSection(0x40, 300)
+	var stream []byte
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, s...)))
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 0, true, append([]byte{0x00}, s...))) // duplicate
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 3, true, append([]byte{0x00}, s...))) // cc error
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 4, true, append([]byte{0x00}, long[:183]...)))
+	stream = concatPacket(stream, makeTSPacket(PidPAT, 5, true, append([]byte{0x00}, s...))) // truncates long
+	tei := makeTSPacket(PidPAT, 6, true, append([]byte{0x00}, s...))
+	tei[1] |= 0x80
+	stream = concatPacket(stream, tei)
+	di := makeTSPacket(PidPAT, 0, false, nil)
+	di[3] = 0x20 // adaptation field only
+	di[4] = 0x01
+	di[5] = 0x80
+	stream = concatPacket(stream, di)
+
+	var events []Event
+	sr := NewSectionReader(bytes.NewReader(stream))
+	sr.Observe(func(e Event) {
+		events = append(events, e)
+	})
+	sr.SkipTransportErrors(true)
+	n := 0
+	if err := sr.Scan(context.Background(), func(rx *SectionReceiver) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("got %d sections, want %d", n, 2)
+	}
+
+	exp := []Event{
+		{Type: EventDuplicatePacket, PID: PidPAT, Packet: 1, Actual: 0},
+		{Type: EventContinuityError, PID: PidPAT, Packet: 2, Expected: 1, Actual: 3},
+		{Type: EventTruncatedSection, PID: PidPAT, Packet: 4},
+		{Type: EventTransportError, PID: PidPAT, Packet: 5},
+		{Type: EventDiscontinuity, PID: PidPAT, Packet: 6},
+	}
+	if len(events) != len(exp) {
+		t.Fatalf("got events %+v, want %+v", events, exp)
+	}
+	for i := range exp {
+		if events[i] != exp[i] {
+			t.Errorf("%d: got event %+v, want %+v", i, events[i], exp[i])
+		}
+	}
+}
@@ -0,0 +1,212 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "encoding/binary"
+
+// PIDs for DVB Service Information(SI).
+const (
+	PidNIT = 0x0010 // NIT, ST
+	PidSDT = 0x0011 // SDT, BAT, ST
+	PidEIT = 0x0012 // EIT, ST, CIT
+	PidRST = 0x0013 // RST, ST
+	PidTDT = 0x0014 // TDT, TOT, ST
+)
+
+// Table IDs for DVB SI.
+const (
+	TableIDNITActual         TableID = 0x40 // network_information_section - actual_network
+	TableIDNITOther          TableID = 0x41 // network_information_section - other_network
+	TableIDSDTActual         TableID = 0x42 // service_description_section - actual_transport_stream
+	TableIDSDTOther          TableID = 0x46 // service_description_section - other_transport_stream
+	TableIDBAT               TableID = 0x4A // bouquet_association_section
+	TableIDEITActual         TableID = 0x4E // event_information_section - actual_transport_stream, present/following
+	TableIDEITOther          TableID = 0x4F // event_information_section - other_transport_stream, present/following
+	TableIDEITScheduleActual TableID = 0x50 // 0x50 .. 0x5F event_information_section - actual_transport_stream, schedule
+	TableIDEITScheduleOther  TableID = 0x60 // 0x60 .. 0x6F event_information_section - other_transport_stream, schedule
+	TableIDTDT               TableID = 0x70 // time_date_section
+	TableIDRST               TableID = 0x71 // running_status_section
+	TableIDST                TableID = 0x72 // stuffing_section
+	TableIDTOT               TableID = 0x73 // time_offset_section
+)
+
+// Tags for DVB SI descriptor.
+const (
+	TagService DescriptorTag = 0x48 // service_descriptor
+)
+
+// Service types of the service_descriptor.
+const (
+	ServiceTypeDigitalTelevision     = 0x01
+	ServiceTypeDigitalRadioSound     = 0x02
+	ServiceTypeAdvancedCodecHDTV     = 0x19
+	ServiceTypeHEVCDigitalTelevision = 0x1F
+)
+
+const sdtHeaderSize = 11 // table_id .. reserved_future_use after original_network_id
+
+// SDT is a Service Description Table.
+type SDT PSI
+
+// NewSDT returns a new SDT.
+func NewSDT(b []byte) (SDT, error) {
+	minsize := sdtHeaderSize + crc32size
+	if len(b) < minsize {
+		return nil, ErrTooShort
+	}
+	return SDT(b), nil
+}
+
+// TransportStreamID returns the transport_stream_id.
+func (t SDT) TransportStreamID() TransportStreamID {
+	return TransportStreamID(binary.BigEndian.Uint16(t[3:5]))
+}
+
+// VersionNumber returns the version_number.
+func (t SDT) VersionNumber() int {
+	return VersionNumber(t)
+}
+
+// CurrentNextIndicator returns the current_next_indicator.
+func (t SDT) CurrentNextIndicator() byte {
+	return CurrentNextIndicator(t)
+}
+
+// SectionNumber returns the section_number.
+func (t SDT) SectionNumber() byte {
+	return SectionNumber(t)
+}
+
+// LastSectionNumber returns the last_section_number.
+func (t SDT) LastSectionNumber() byte {
+	return LastSectionNumber(t)
+}
+
+// OriginalNetworkID returns the original_network_id.
+func (t SDT) OriginalNetworkID() uint16 {
+	return binary.BigEndian.Uint16(t[8:10])
+}
+
+// Services returns the list of SDTService.
+func (t SDT) Services() []SDTService {
+	headsize := 5 // service_id .. descriptors_loop_length
+	var services []SDTService
+	pos := sdtHeaderSize
+	for pos+headsize <= len(t)-crc32size {
+		size := headsize + SDTService(t[pos:]).DescriptorsLoopLength()
+		if pos+size > len(t)-crc32size {
+			break
+		}
+		s := SDTService(t[pos : pos+size])
+		pos += len(s)
+		services = append(services, s)
+	}
+	return services
+}
+
+// SDTService is a service in SDT.
+type SDTService []byte
+
+// ServiceID returns the service_id, which is the same as the program_number
+// of the service.
+func (s SDTService) ServiceID() ProgramNumber {
+	return ProgramNumber(binary.BigEndian.Uint16(s[0:2]))
+}
+
+// EITScheduleFlag returns the EIT_schedule_flag.
+func (s SDTService) EITScheduleFlag() byte {
+	return s[2] & 0x02 >> 1
+}
+
+// EITPresentFollowingFlag returns the EIT_present_following_flag.
+func (s SDTService) EITPresentFollowingFlag() byte {
+	return s[2] & 0x01
+}
+
+// RunningStatus returns the running_status.
+func (s SDTService) RunningStatus() byte {
+	return s[3] >> 5
+}
+
+// FreeCAMode returns the free_CA_mode.
+func (s SDTService) FreeCAMode() byte {
+	return s[3] & 0x10 >> 4
+}
+
+// DescriptorsLoopLength returns the descriptors_loop_length.
+func (s SDTService) DescriptorsLoopLength() int {
+	return int(uint16(s[4]) | uint16(s[3]&0x0F)<<8)
+}
+
+// Descriptors returns the descriptors.
+func (s SDTService) Descriptors() []Descriptor {
+	return Descriptors(s[5:])
+}
+
+// BuildServiceDescriptor returns a new service_descriptor.
+func BuildServiceDescriptor(serviceType byte, provider, name string) Descriptor {
+	data := make([]byte, 0, 3+len(provider)+len(name))
+	data = append(data, serviceType, byte(len(provider)))
+	data = append(data, provider...)
+	data = append(data, byte(len(name)))
+	data = append(data, name...)
+	return BuildDescriptor(TagService, data)
+}
+
+// BuildSDTService returns a new service of the SDT, whose running_status is
+// "running" and free_CA_mode is 0.
+func BuildSDTService(id ProgramNumber, descriptors ...Descriptor) SDTService {
+	s := SDTService{byte(id >> 8), byte(id), 0xFC, 0x80, 0x00}
+	for _, d := range descriptors {
+		s = append(s, d...)
+	}
+	size := len(s) - 5
+	s[3] |= byte(size >> 8 & 0x0F)
+	s[4] = byte(size)
+	return s
+}
+
+// BuildSDT returns a new SDT of the actual transport stream having the
+// services.
+func BuildSDT(tsid TransportStreamID, onid uint16, version int, services []SDTService) SDT {
+	body := []byte{byte(onid >> 8), byte(onid), 0xFF}
+	for _, s := range services {
+		body = append(body, s...)
+	}
+	b := buildSection(TableIDSDTActual, uint16(tsid), version, body)
+	b[1] |= 0x40 // reserved_future_use
+	PSI(b).UpdateCRC32()
+	return SDT(b)
+}
+
+// filterServices returns a copy of the SDT section having the service only.
+// It returns nil if the section has no such service.
+func (t SDT) filterServices(number ProgramNumber) SDT {
+	for _, s := range t.Services() {
+		if s.ServiceID() != number {
+			continue
+		}
+		b := make([]byte, 0, sdtHeaderSize+len(s)+crc32size)
+		b = append(b, t[:sdtHeaderSize]...)
+		b = append(b, s...)
+		b = append(b, 0, 0, 0, 0)
+		length := len(b) - sectionMinSize
+		b[1] = b[1]&0xF0 | byte(length>>8&0x0F)
+		b[2] = byte(length)
+		PSI(b).UpdateCRC32()
+		return SDT(b)
+	}
+	return nil
+}
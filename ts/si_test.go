@@ -0,0 +1,72 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import "testing"
+
+func TestSDT(t *testing.T) {
+	sdt, err := NewSDT(buildSection(TableIDSDTActual, 0x7FE5, 2, []byte{
+		0x00, 0x01, 0xFF,
+		0x00, 0x01, 0xFD, 0x90, 0x00,
+		0x00, 0x02, 0xFF, 0x80, 0x03, 0x48, 0x01, 0x01,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tsid := sdt.TransportStreamID(); tsid != 0x7FE5 {
+		t.Errorf("TransportStreamID() => 0x%04X, want 0x7FE5", tsid)
+	}
+	if v := sdt.VersionNumber(); v != 2 {
+		t.Errorf("VersionNumber() => %d, want 2", v)
+	}
+	if id := sdt.OriginalNetworkID(); id != 1 {
+		t.Errorf("OriginalNetworkID() => %d, want 1", id)
+	}
+	services := sdt.Services()
+	if len(services) != 2 {
+		t.Fatalf("Services() => %d services, want 2", len(services))
+	}
+	for i, tc := range []struct {
+		id      ProgramNumber
+		sched   byte
+		pf      byte
+		running byte
+		freeCA  byte
+		descs   int
+	}{
+		{1, 0, 1, 4, 1, 0},
+		{2, 1, 1, 4, 0, 1},
+	} {
+		s := services[i]
+		if id := s.ServiceID(); id != tc.id {
+			t.Errorf("%0d: ServiceID() => %d, want %d", i, id, tc.id)
+		}
+		if f := s.EITScheduleFlag(); f != tc.sched {
+			t.Errorf("%0d: EITScheduleFlag() => %d, want %d", i, f, tc.sched)
+		}
+		if f := s.EITPresentFollowingFlag(); f != tc.pf {
+			t.Errorf("%0d: EITPresentFollowingFlag() => %d, want %d", i, f, tc.pf)
+		}
+		if rs := s.RunningStatus(); rs != tc.running {
+			t.Errorf("%0d: RunningStatus() => %d, want %d", i, rs, tc.running)
+		}
+		if m := s.FreeCAMode(); m != tc.freeCA {
+			t.Errorf("%0d: FreeCAMode() => %d, want %d", i, m, tc.freeCA)
+		}
+		if n := len(s.Descriptors()); n != tc.descs {
+			t.Errorf("%0d: len(Descriptors()) => %d, want %d", i, n, tc.descs)
+		}
+	}
+}
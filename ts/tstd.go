@@ -0,0 +1,320 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"sort"
+	"time"
+)
+
+// TSTDBuffers is the sizes and the leak rates of the buffers of an
+// elementary stream in the T-STD.
+type TSTDBuffers struct {
+	TB  int   // size of the transport buffer in bytes
+	Rx  int64 // leak rate of TB in bits per second
+	MB  int   // size of the multiplex buffer in bytes, or 0 for TB to leak into EB
+	Rbx int64 // leak rate of MB in bits per second
+	EB  int   // size of the elementary stream buffer in bytes
+}
+
+// TSTDLevel is the fullness of the buffers in bytes.
+type TSTDLevel struct {
+	TB int
+	MB int
+	EB int
+}
+
+// DefaultTSTDBuffers returns the buffers of the T-STD for the stream_type,
+// or false if the stream_type is not modeled. The video buffers are for the
+// maximum of Main Profile at High Level of H.262, and of level 4.1 of H.264
+// and H.265.
+func DefaultTSTDBuffers(streamType byte) (TSTDBuffers, bool) {
+	// BSmux + BSoh for the bitrate
+	mb := func(bps int64) int {
+		return int(bps*4/1000+bps/750) / 8
+	}
+	switch streamType {
+	case StreamTypeMPEG1Video, StreamTypeMPEG2Video:
+		const rmax = 80000000
+		return TSTDBuffers{TB: 512, Rx: rmax * 12 / 10, MB: mb(rmax), Rbx: rmax * 12 / 10, EB: 9781248 / 8}, true
+	case StreamTypeH264:
+		const rmax = 1200 * 50000 // cpbBrNalFactor * MaxBR
+		return TSTDBuffers{TB: 512, Rx: rmax * 12 / 10, MB: mb(rmax), Rbx: rmax, EB: 1200 * 62500 / 8}, true
+	case StreamTypeHEVC:
+		const rmax = 1100 * 20000 // CpbNalFactor * MaxBR
+		return TSTDBuffers{TB: 512, Rx: rmax * 12 / 10, MB: mb(rmax), Rbx: rmax, EB: 1100 * 20000 / 8}, true
+	case StreamTypeMPEG1Audio, StreamTypeMPEG2Audio, StreamTypeAAC, StreamTypeLATMAAC:
+		return TSTDBuffers{TB: 512, Rx: 2000000, EB: 3584}, true
+	case StreamTypeAC3:
+		return TSTDBuffers{TB: 512, Rx: 2000000, EB: 2592}, true
+	case StreamTypeEAC3:
+		return TSTDBuffers{TB: 512, Rx: 2000000, EB: 5696}, true
+	}
+	return TSTDBuffers{}, false
+}
+
+// TSTD simulates the transport stream system target decoder of ISO/IEC
+// 13818-1 for the elementary streams of the programs, and emits the events
+// of the overflows and the underflows of their buffers to the observer.
+//
+// The packets of the elementary streams of a program arrive at the time
+// derived from the PCRs of its PCR_PID, counted from the first one, and the
+// packets before the second PCR are ignored. The bytes leave TB at Rx, and MB
+// at Rbx while EB is not full. An access unit, that is the payload of a PES
+// packet, is removed from EB at its DTS by the PCRs of the program.
+type TSTD struct {
+	d        *Demuxer
+	clocks   map[PID]*streamClock // clocks by PCR_PID
+	framer   packetFramer
+	observer Observer
+	n        int64
+	buffers  map[PID]TSTDBuffers
+	streams  map[PID]*tstdStream
+}
+
+type tstdStream struct {
+	pid   PID
+	b     TSTDBuffers
+	clock *streamClock  // clock of the program
+	t     time.Duration // time the buffers are updated to
+	tb    float64
+	tbq   []tstdPacket
+	mb    float64
+	eb    float64
+	skip  float64 // bytes of the access units removed before entering EB
+	aus   []*tstdAU
+	cur   *tstdAU // access unit being received
+	over  [3]bool // TB, MB and EB are overflowing
+	peak  TSTDLevel
+}
+
+// tstdPacket is a packet in TB.
+type tstdPacket struct {
+	remain float64 // bytes still in TB
+	es     int     // bytes of the elementary stream in it
+}
+
+// tstdAU is an access unit.
+type tstdAU struct {
+	dts     time.Duration
+	size    int
+	remain  int // bytes of the PES packet not received, or -1 if unbounded
+	removed bool
+}
+
+// NewTSTD returns a new TSTD emitting the events to o.
+func NewTSTD(o Observer) *TSTD {
+	s := &TSTD{
+		d:        NewDemuxer(),
+		clocks:   make(map[PID]*streamClock),
+		observer: o,
+		buffers:  make(map[PID]TSTDBuffers),
+		streams:  make(map[PID]*tstdStream),
+	}
+	s.d.HandleProgram(s.handleProgram)
+	return s
+}
+
+// Buffers sets the buffers of the elementary stream of the PID instead of the
+// default for its stream_type. It must be called before writing.
+func (s *TSTD) Buffers(pid PID, b TSTDBuffers) {
+	s.buffers[pid] = b
+}
+
+func (s *TSTD) handleProgram(pg *Program) error {
+	clock, ok := s.clocks[pg.PCRPID()]
+	if !ok {
+		clock = newStreamClock(0)
+		s.clocks[pg.PCRPID()] = clock
+	}
+	for _, st := range pg.Streams {
+		if _, ok := s.streams[st.PID]; ok {
+			continue
+		}
+		b, ok := s.buffers[st.PID]
+		if !ok {
+			b, ok = DefaultTSTDBuffers(st.StreamType)
+		}
+		if ok {
+			s.streams[st.PID] = &tstdStream{pid: st.PID, b: b, clock: clock, t: -1}
+		}
+	}
+	return nil
+}
+
+// Peak returns the maximum fullness of the buffers of the PID.
+func (s *TSTD) Peak(pid PID) (TSTDLevel, bool) {
+	st, ok := s.streams[pid]
+	if !ok {
+		return TSTDLevel{}, false
+	}
+	return st.peak, true
+}
+
+// Write simulates the decoding of the packets in b. The packet split between
+// the calls is buffered until the rest is written. On error, it returns the
+// number of the bytes processed up to the end of the packet which caused the
+// error.
+func (s *TSTD) Write(b []byte) (int, error) {
+	return s.framer.write(b, s.WritePacket)
+}
+
+// WritePacket simulates the decoding of the packet.
+func (s *TSTD) WritePacket(p Packet) error {
+	n := s.n
+	s.n++
+	if err := s.d.WritePacket(p); err != nil {
+		return err
+	}
+	if c, ok := s.clocks[p.PID()]; ok {
+		c.packet(p, n)
+	}
+	pids := make([]PID, 0, len(s.streams))
+	for pid := range s.streams {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool {
+		return pids[i] < pids[j]
+	})
+	for _, pid := range pids {
+		if st := s.streams[pid]; st.clock.ticksPerPacket() > 0 {
+			st.update(st.clock.time(n), n, s.observer)
+		}
+	}
+	if st, ok := s.streams[p.PID()]; ok && st.clock.ticksPerPacket() > 0 {
+		s.receive(st, p, n, st.clock.time(n))
+	}
+	return nil
+}
+
+// receive puts the packet into TB. The packet with the broken adaptation
+// field carries no bytes of the elementary stream.
+func (s *TSTD) receive(st *tstdStream, p Packet, n int64, now time.Duration) {
+	var payload Payload
+	if _, err := p.AdaptationField(); err == nil {
+		payload = p.Payload()
+	}
+	es := len(payload)
+	if len(payload) > 0 && p.IsPayloadUnitStart() {
+		if pes, err := NewPES(payload); err == nil && pes.HasPTS() {
+			header := pesHeaderSize + 3 + pes.HeaderDataLength()
+			es = max(0, len(payload)-header)
+			remain := -1
+			if l := pes.PacketLength(); l > 0 {
+				remain = pesHeaderSize + l
+			}
+			st.cur = &tstdAU{dts: st.clock.timestamp(pes.DTS()), remain: remain}
+			st.aus = append(st.aus, st.cur)
+		}
+	}
+	if st.cur == nil {
+		es = 0
+	} else {
+		st.cur.size += es
+		if st.cur.remain >= 0 {
+			st.cur.remain = max(0, st.cur.remain-len(payload))
+		}
+		if st.cur.removed {
+			st.skip += float64(es)
+		}
+	}
+	st.tb += packetDefaultSize
+	st.tbq = append(st.tbq, tstdPacket{remain: packetDefaultSize, es: es})
+	st.check(now, n, s.observer)
+}
+
+// update leaks the buffers until now, and removes the access units whose
+// DTS are until now.
+func (st *tstdStream) update(now time.Duration, n int64, o Observer) {
+	if st.t < 0 {
+		st.t = now
+	}
+	for len(st.aus) > 0 && st.aus[0].dts <= now {
+		au := st.aus[0]
+		st.leak(max(au.dts, st.t))
+		complete := au != st.cur || au.remain == 0
+		if !complete || st.eb < float64(au.size) {
+			o.observe(Event{Type: EventTSTDUnderflow, PID: st.pid, Packet: n, Time: au.dts, Detail: "EB"})
+		}
+		// the rest of the access unit is discarded when it enters EB
+		st.skip += max(0, float64(au.size)-st.eb)
+		st.eb = max(0, st.eb-float64(au.size))
+		au.removed = true
+		st.aus[0] = nil
+		st.aus = st.aus[1:]
+		st.check(st.t, n, o)
+	}
+	st.leak(now)
+	st.check(now, n, o)
+}
+
+// leak transfers the bytes between the buffers until t.
+func (st *tstdStream) leak(t time.Duration) {
+	if t <= st.t {
+		return
+	}
+	dt := (t - st.t).Seconds()
+	st.t = t
+
+	var out float64 // bytes of the elementary stream leaving TB
+	for bytes := float64(st.b.Rx) / 8 * dt; bytes > 0 && len(st.tbq) > 0; {
+		pkt := &st.tbq[0]
+		k := min(bytes, pkt.remain)
+		pkt.remain -= k
+		st.tb -= k
+		bytes -= k
+		if pkt.remain > 0 {
+			break
+		}
+		out += float64(pkt.es)
+		st.tbq = st.tbq[1:]
+	}
+	if len(st.tbq) == 0 {
+		st.tb = 0
+	}
+	if st.b.MB > 0 {
+		st.mb += out
+		out = min(st.mb, float64(st.b.Rbx)/8*dt, max(0, float64(st.b.EB)-st.eb))
+		st.mb -= out
+	}
+	k := min(out, st.skip)
+	st.skip -= k
+	st.eb += out - k
+}
+
+// check emits the overflows of the buffers and updates the peak.
+func (st *tstdStream) check(t time.Duration, n int64, o Observer) {
+	st.peak.TB = max(st.peak.TB, int(st.tb))
+	st.peak.MB = max(st.peak.MB, int(st.mb))
+	st.peak.EB = max(st.peak.EB, int(st.eb))
+	for i, b := range []struct {
+		name  string
+		level float64
+		size  int
+	}{
+		{"TB", st.tb, st.b.TB},
+		{"MB", st.mb, st.b.MB},
+		{"EB", st.eb, st.b.EB},
+	} {
+		if i == 1 && st.b.MB == 0 {
+			continue
+		}
+		over := b.level > float64(b.size)
+		if over && !st.over[i] {
+			o.observe(Event{Type: EventTSTDOverflow, PID: st.pid, Packet: n, Time: t, Detail: b.name})
+		}
+		st.over[i] = over
+	}
+}
@@ -0,0 +1,212 @@
+//    Copyright 2017 drillbits
+//
+//    Licensed under the Apache License, Version 2.0 (the "License");
+//    you may not use this file except in compliance with the License.
+//    You may obtain a copy of the License at
+//
+//        http://www.apache.org/licenses/LICENSE-2.0
+//
+//    Unless required by applicable law or agreed to in writing, software
+//    distributed under the License is distributed on an "AS IS" BASIS,
+//    WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//    See the License for the specific language governing permissions and
+//    limitations under the License.
+
+package ts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTSTD(t *testing.T) {
+	var video []byte
+	for i := 0; i < 25; i++ {
+		video = append(video, 0x00, 0x00, 0x00, 0x01, 0x41, 0x9A)
+		video = append(video, bytes.Repeat([]byte{0x11}, 2000)...)
+	}
+	var audio []byte
+	for i := 0; i < 40; i++ {
+		audio = append(audio, makeTestADTS(3, 100)...)
+	}
+	m := NewESMuxer(0x0001, 1)
+	vpid, err := m.AddVideo(bytes.NewReader(video), StreamTypeH264, 25, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apid := m.AddAudio(bytes.NewReader(audio), "")
+	var es bytes.Buffer
+	if err := m.Mux(&es); err != nil {
+		t.Fatal(err)
+	}
+	// at the constant bitrate
+	cbr := NewMuxer(0x0001)
+	cbr.Bitrate(2000000)
+	if _, err := cbr.AddProgram(&es, ""); err != nil {
+		t.Fatal(err)
+	}
+	var muxed bytes.Buffer
+	if err := cbr.Mux(&muxed); err != nil {
+		t.Fatal(err)
+	}
+	vpid, apid = vpid+1, apid+1 // remapped by the Muxer
+
+	type result struct {
+		Type   EventType
+		Packet int64
+		Time   time.Duration
+		Detail string
+	}
+	for i, tc := range []struct {
+		stream  []byte
+		buffers map[PID]TSTDBuffers
+		pid     PID
+		want    []result
+	}{
+		{muxed.Bytes(), nil, vpid, nil},
+		{muxed.Bytes(), nil, apid, nil},
+		{
+			// 100 kbps from TB
+			muxed.Bytes(),
+			map[PID]TSTDBuffers{vpid: {TB: 512, Rx: 100000, MB: 1000, Rbx: 100000, EB: 100000}},
+			vpid,
+			[]result{
+				{EventTSTDOverflow, 65, 47376 * time.Microsecond, "TB"},
+				{EventTSTDUnderflow, 880, 659996 * time.Microsecond, "EB"},
+				{EventTSTDUnderflow, 933, 700029333 * time.Nanosecond, "EB"},
+				{EventTSTDUnderflow, 987, 740029333 * time.Nanosecond, "EB"},
+				{EventTSTDUnderflow, 1040, 780029333 * time.Nanosecond, "EB"},
+				{EventTSTDUnderflow, 1093, 820029333 * time.Nanosecond, "EB"},
+				{EventTSTDUnderflow, 1146, 860029333 * time.Nanosecond, "EB"},
+				{EventTSTDUnderflow, 1199, 900062666 * time.Nanosecond, "EB"},
+				{EventTSTDUnderflow, 1253, 940062666 * time.Nanosecond, "EB"},
+			},
+		},
+		{
+			// EB smaller than an access unit
+			muxed.Bytes(),
+			map[PID]TSTDBuffers{apid: {TB: 512, Rx: 2000000, EB: 200}},
+			apid,
+			[]result{{EventTSTDOverflow, 153, 113552 * time.Microsecond, "EB"}},
+		},
+		{
+			// access units decoded on arrival
+			makeTestMonitorStream(130, true, nil),
+			nil,
+			0x0100,
+			[]result{
+				{EventTSTDUnderflow, 43, 37 * time.Millisecond, "EB"},
+				{EventTSTDUnderflow, 83, 77 * time.Millisecond, "EB"},
+				{EventTSTDUnderflow, 123, 117 * time.Millisecond, "EB"},
+			},
+		},
+	} {
+		var got []result
+		s := NewTSTD(func(e Event) {
+			if e.PID == tc.pid {
+				got = append(got, result{e.Type, e.Packet, e.Time, e.Detail})
+			} else {
+				t.Errorf("%0d: unexpected event %+v", i, e)
+			}
+		})
+		for pid, b := range tc.buffers {
+			s.Buffers(pid, b)
+		}
+		if _, err := s.Write(tc.stream); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%0d: events => %+v, want %+v", i, got, tc.want)
+		}
+		for j := range tc.want {
+			if got[j] != tc.want[j] {
+				t.Errorf("%0d: events[%d] => %+v, want %+v", i, j, got[j], tc.want[j])
+			}
+		}
+		if peak, ok := s.Peak(tc.pid); !ok || peak.TB < packetDefaultSize {
+			t.Errorf("%0d: Peak(0x%04X) => %+v, %t", i, tc.pid, peak, ok)
+		}
+	}
+}
+
+// shiftTestTimestamps adds d in units of 90 kHz to the PCRs, the PTSs and the
+// DTSs in the stream.
+func shiftTestTimestamps(stream []byte, d int64) {
+	for pos := 0; pos+packetDefaultSize <= len(stream); pos += packetDefaultSize {
+		p := Packet(stream[pos : pos+packetDefaultSize])
+		af, err := p.AdaptationField()
+		if err != nil {
+			continue
+		}
+		if len(af) >= 8 && af.HasPCR() {
+			putClockReference(af.PCR(), af.PCR().Value()+d*300)
+		}
+		if p.IsPayloadUnitStart() && p.IsPES() {
+			PES(p.Payload()).addTimestamps(d)
+		}
+	}
+}
+
+func TestTSTDPrograms(t *testing.T) {
+	// two programs whose PCRs are 10 s apart
+	cbr := NewMuxer(0x0001)
+	cbr.Bitrate(2000000)
+	for _, offset := range []int64{0, 10 * TimestampFrequency} {
+		var video []byte
+		for i := 0; i < 25; i++ {
+			video = append(video, 0x00, 0x00, 0x00, 0x01, 0x41, 0x9A)
+			video = append(video, bytes.Repeat([]byte{0x11}, 2000)...)
+		}
+		var audio []byte
+		for i := 0; i < 40; i++ {
+			audio = append(audio, makeTestADTS(3, 100)...)
+		}
+		m := NewESMuxer(0x0001, 1)
+		if _, err := m.AddVideo(bytes.NewReader(video), StreamTypeH264, 25, 1); err != nil {
+			t.Fatal(err)
+		}
+		m.AddAudio(bytes.NewReader(audio), "")
+		var es bytes.Buffer
+		if err := m.Mux(&es); err != nil {
+			t.Fatal(err)
+		}
+		shiftTestTimestamps(es.Bytes(), offset)
+		if _, err := cbr.AddProgram(&es, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var muxed bytes.Buffer
+	if err := cbr.Mux(&muxed); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewTSTD(func(e Event) {
+		t.Errorf("unexpected event %+v", e)
+	})
+	if _, err := s.Write(muxed.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range []PID{0x0101, 0x0102, 0x0201, 0x0202} {
+		if peak, ok := s.Peak(pid); !ok || peak.EB == 0 {
+			t.Errorf("Peak(0x%04X) => %+v, %t", pid, peak, ok)
+		}
+	}
+}
+
+func TestTSTDBrokenAdaptationField(t *testing.T) {
+	stream := makeTestMonitorStream(300, true, func(i int, p []byte) []byte {
+		if i == 150 {
+			p[3] |= 0x30 // adaptation_field_control
+			p[4] = 200   // adaptation_field_length
+		}
+		return p
+	})
+	s := NewTSTD(nil)
+	if _, err := s.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Peak(0x0100); !ok {
+		t.Errorf("Peak(0x%04X) => false", 0x0100)
+	}
+}